@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"os"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-contrib/cors"
+	goredis "github.com/redis/go-redis/v9"
 
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/data"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/routes"
@@ -15,19 +18,47 @@ import (
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/handler"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/middleware"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/mailer"
 )
 
 func main() {
 	godotenv.Load()
 
-	r := gin.Default()
-	r.SetTrustedProxies(nil)
+	// gin.Default's middleware, with WebSocket access tokens masked in the access log
+	r := gin.New()
+	r.Use(middleware.Logger(), gin.Recovery())
+
+	// Client IPs key the WebSocket and login limits. Behind a load balancer its
+	// addresses must be listed in TRUSTED_PROXIES (IPs or CIDRs, comma
+	// separated) so X-Forwarded-For is used; otherwise every client would share
+	// the balancer's IP.
+	var trustedProxies []string
+	if s := os.Getenv("TRUSTED_PROXIES"); s != "" {
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				trustedProxies = append(trustedProxies, p)
+			}
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Browser origins allowed by CORS and by the WebSocket origin check
+	allowedOrigins := []string{"http://localhost:3001"}
+	if s := os.Getenv("CORS_ORIGINS"); s != "" {
+		allowedOrigins = strings.Split(s, ",")
+		for i := range allowedOrigins {
+			allowedOrigins[i] = strings.TrimSpace(allowedOrigins[i])
+		}
+	}
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3001"},
+		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Upgrade", "Connection"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Upgrade", "Connection",
+			"X-API-KEY", "X-API-TIMESTAMP", "X-API-SIGNATURE", "X-API-RECV-WINDOW", "X-2FA-CODE"},
+		ExposeHeaders:    []string{"Content-Length", "X-Next-Cursor"},
 		AllowCredentials: true,
 		MaxAge:           24 * time.Hour,
 	}))
@@ -65,26 +96,177 @@ func main() {
 
 	// Initialize repositories
 	marketRepo := repo.NewMarketRepo(db.DB)
+	if tz := os.Getenv("CANDLE_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatalf("invalid CANDLE_TIMEZONE: %v", err)
+		}
+		marketRepo.SetCandleLocation(loc)
+	}
 	orderRepo  := repo.NewOrderRepo(db.DB)
 	tradeRepo  := repo.NewTradeRepo(db.DB)
 	walletRepo := repo.NewWalletRepo(db.DB)
+	apiKeyRepo := repo.NewAPIKeyRepo(db.DB)
+	twofaRepo  := repo.NewTwoFARepo(db.DB)
+	webauthnRepo := repo.NewWebAuthnRepo(db.DB)
+	refreshTokenRepo := repo.NewRefreshTokenRepo(db.DB)
+	deviceRepo := repo.NewDeviceRepo(db.DB)
+	loginThrottleRepo := repo.NewLoginThrottleRepo(db.DB)
+	accountRepo := repo.NewAccountRepo(db.DB)
+	expiryRepo := repo.NewExpiryRepo(db.DB)
+
+	// Post-commit market events shared by services and WebSocket hubs
+	events := service.NewEventBus()
+
+	// With Redis, events are relayed between API instances and singleton jobs
+	// run on the elected leader only
+	instanceID := service.NewInstanceID()
+	var redisClient *goredis.Client
+	if redis != nil {
+		redisClient = redis.Client
+		if err := service.NewEventBridge(redisClient, events, instanceID).Start(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	leader := service.NewLeaderElector(redisClient, "leader:jobs", instanceID, 15*time.Second)
 
 	// Initialize services with cache
-	orderService := service.NewOrderService(db.DB, marketRepo, orderRepo, tradeRepo, walletRepo, cacheService)
+	orderService := service.NewOrderService(db.DB, marketRepo, orderRepo, tradeRepo, walletRepo, cacheService, events)
+
+	tickerService := service.NewTickerService(marketRepo, orderRepo, tradeRepo, events)
+	if err := tickerService.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	// In-memory L2 books publishing sequenced diffs
+	bookService := service.NewBookService(orderRepo, marketRepo, events)
+	if err := bookService.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	// In-memory L3 books publishing order-by-order events with anonymized ids
+	l3Service := service.NewL3Service(orderRepo, marketRepo, events, os.Getenv("L3_ORDER_ID_SECRET"))
+	if err := l3Service.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Private per-user order, fill and balance events
+	userStreamService := service.NewUserStreamService(orderRepo, tradeRepo, walletRepo, events)
+	userStreamService.Start(context.Background())
+
+	// 1m candles are built from the trade stream and saved as minutes close, by
+	// the leader only. Live candles are shared through Redis when available,
+	// else kept in process. The leader also sweeps expired tokens and challenges.
+	var candleStore service.CandleStore = service.NewMemoryCandleStore()
+	if cacheService != nil {
+		candleStore = cacheService
+	}
+	go leader.Run(context.Background(), func(ctx context.Context) {
+		service.NewCandleService(marketRepo, candleStore, events).Start(ctx)
+		service.NewExpirySweeper(expiryRepo).Start(ctx)
+		<-ctx.Done()
+	})
+
+	// Refresh tokens are rotated on every refresh, in families per login;
+	// revoked sessions are cached in Redis when available
+	var revokedSessions service.RevokedSessionCache
+	if cacheService != nil {
+		revokedSessions = cacheService
+	}
+	refreshTokenService := service.NewRefreshTokenService(refreshTokenRepo, revokedSessions, events)
+	deviceService := service.NewDeviceService(deviceRepo)
+
+	// Verification, password reset and unlock links are mailed and point to the frontend
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" && len(allowedOrigins) > 0 && allowedOrigins[0] != "*" {
+		appURL = allowedOrigins[0]
+	}
+	accountService := service.NewAccountService(accountRepo, mail, appURL)
+
+	// Failed logins are throttled per account and IP, in Redis when available
+	// with Postgres taking over while Redis fails, else in Postgres. Locked
+	// users are mailed an unlock link.
+	loginThrottleConfig, err := service.LoginThrottleConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var loginThrottleStore service.LoginThrottleStore = loginThrottleRepo
+	if cacheService != nil {
+		loginThrottleStore = service.NewFallbackLoginThrottleStore(cacheService, loginThrottleRepo)
+	}
+	loginThrottle := service.NewLoginThrottle(loginThrottleStore, loginThrottleRepo, loginThrottleConfig, accountService.SendUnlockToken)
+
+	// API key and TOTP secrets are stored encrypted since the server reads them back
+	apiKeyEncryptionKey := os.Getenv("API_KEY_ENCRYPTION_KEY")
+	if apiKeyEncryptionKey == "" {
+		log.Println("Warning: API_KEY_ENCRYPTION_KEY not set, deriving API key encryption from ACCESS_TOKEN_SECRET")
+		apiKeyEncryptionKey = os.Getenv("ACCESS_TOKEN_SECRET")
+	}
+	apiKeyService, err := service.NewAPIKeyService(apiKeyRepo, apiKeyEncryptionKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	twofaService, err := service.NewTwoFAService(twofaRepo, apiKeyEncryptionKey, os.Getenv("TOTP_ISSUER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Passkeys: the RP id is the site's domain, origins default to the CORS origins
+	webauthnConfig := service.WebAuthnConfig{
+		RPID:    os.Getenv("WEBAUTHN_RP_ID"),
+		RPName:  os.Getenv("WEBAUTHN_RP_NAME"),
+		Origins: allowedOrigins,
+	}
+	if webauthnConfig.RPID == "" {
+		webauthnConfig.RPID = "localhost"
+	}
+	if webauthnConfig.RPName == "" {
+		webauthnConfig.RPName = "Crypto Trading"
+	}
+	if s := os.Getenv("WEBAUTHN_ORIGINS"); s != "" {
+		webauthnConfig.Origins = strings.Split(s, ",")
+		for i := range webauthnConfig.Origins {
+			webauthnConfig.Origins[i] = strings.TrimSpace(webauthnConfig.Origins[i])
+		}
+	}
+	webauthnService := service.NewWebAuthnService(webauthnRepo, webauthnConfig)
 
 	// Initialize handlers with cache
-	handle := handler.NewHandler(orderService, marketRepo, orderRepo, cacheService)
+	// Handlers take the cache as an optional interface: keep it untyped nil when
+	// disabled, otherwise the "cs != nil" checks see a typed nil pointer
+	var handlerCache interface{}
+	if cacheService != nil {
+		handlerCache = cacheService
+	}
+	wsConfig, err := handler.WSConfigFromEnv(allowedOrigins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handle := handler.NewHandler(orderService, tickerService, bookService, l3Service, events, marketRepo, orderRepo, tradeRepo, handlerCache, wsConfig)
 
 	// Setup routes
 	routes.HealthRoutes(r) // Public health check for ECS/Docker
-	routes.AuthRoutes(r, db)
+	routes.AuthRoutes(r, db, events, refreshTokenService, deviceService, twofaService, loginThrottle, accountService)
+	routes.PasskeyLoginRoutes(r, db, events, refreshTokenService, deviceService, webauthnService, twofaService)
 	routes.WebSocketRoutes(r, handle)
+	routes.SSERoutes(r, handle)
 	routes.MarketRoutes(r, handle)
 
-	r.Use(middleware.RequireAuth(db.DB))
+	r.Use(middleware.RequireAuth(db.DB, apiKeyService, refreshTokenService))
 	
 	routes.UserRoutes(r, db)
-	routes.OrderRoutes(r, handle)
+	routes.SessionRoutes(r, events, refreshTokenService)
+	routes.AccountRoutes(r, accountService, refreshTokenService, twofaService, events)
+	routes.APIKeyRoutes(r, apiKeyService, twofaService)
+	routes.TwoFARoutes(r, twofaService, events)
+	routes.PasskeyRoutes(r, webauthnService, events, twofaService, accountService, loginThrottle)
+	routes.OrderRoutes(r, handle, accountService)
+	routes.L3Routes(r, handle)
+	routes.UserStreamRoutes(r, handle)
 
 	if err := r.Run(":" + port); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,147 @@
+// Command candles rebuilds 1m candles (and their rollups) from the trades table.
+//
+//	go run ./cmd/candles -symbol BTC/USDT -from 2025-01-01T00:00:00Z -to 2025-01-02T00:00:00Z -dry-run
+//
+// Without -symbol every active market is rebuilt. Without -to the range ends
+// at the last closed minute. Rebuilding is idempotent.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/data"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+)
+
+func main() {
+	symbol := flag.String("symbol", "", "market symbol to rebuild (default: all active markets)")
+	fromStr := flag.String("from", "", "range start, RFC3339 (default: first trade of the market)")
+	toStr := flag.String("to", "", "range end, RFC3339, exclusive (default: last closed minute)")
+	dryRun := flag.Bool("dry-run", false, "print the differences without writing")
+	maxDiffs := flag.Int("max-diffs", 50, "differences to print per market in dry-run mode (0 = all)")
+	flag.Parse()
+
+	godotenv.Load()
+
+	db, err := data.NewPostgres()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	marketRepo := repo.NewMarketRepo(db.DB)
+	if tz := os.Getenv("CANDLE_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatalf("invalid CANDLE_TIMEZONE: %v", err)
+		}
+		marketRepo.SetCandleLocation(loc)
+	}
+
+	ctx := context.Background()
+
+	// The live minute belongs to the candle service
+	to := time.Now().Truncate(time.Minute)
+	if *toStr != "" {
+		t, err := time.Parse(time.RFC3339, *toStr)
+		if err != nil {
+			log.Fatalf("invalid -to: %v", err)
+		}
+		if t.Before(to) {
+			to = t
+		}
+	}
+
+	var from *time.Time
+	if *fromStr != "" {
+		t, err := time.Parse(time.RFC3339, *fromStr)
+		if err != nil {
+			log.Fatalf("invalid -from: %v", err)
+		}
+		from = &t
+	}
+
+	symbols := []string{*symbol}
+	if *symbol == "" {
+		markets, err := marketRepo.GetAllActiveMarkets(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		symbols = symbols[:0]
+		for _, m := range markets {
+			symbols = append(symbols, m.Symbol)
+		}
+	} else if ok, err := marketRepo.ValidateSymbol(ctx, *symbol); err != nil {
+		log.Fatal(err)
+	} else if !ok {
+		log.Fatalf("unknown symbol: %s", *symbol)
+	}
+
+	for _, sym := range symbols {
+		start := from
+		if start == nil {
+			first, err := marketRepo.GetFirstTradeTime(ctx, sym)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if first == nil {
+				log.Printf("%s: no trades, skipping", sym)
+				continue
+			}
+			start = first
+		}
+
+		result, err := service.RebuildCandles(ctx, marketRepo, sym, *start, to, service.CandleRebuildOptions{
+			DryRun: *dryRun,
+			Progress: func(p service.CandleRebuildProgress) {
+				log.Printf("%s: %d/%d minutes (%.1f%%) up to %s",
+					p.Symbol, p.Processed, p.Total, float64(p.Processed)*100/float64(p.Total), p.ChunkEnd.Format(time.RFC3339))
+			},
+		})
+		if err == service.ErrInvalidRebuildRange {
+			log.Printf("%s: nothing to rebuild before %s", sym, to.Format(time.RFC3339))
+			continue
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", sym, err)
+		}
+
+		if *dryRun {
+			printDiffs(result, *maxDiffs)
+		}
+		log.Printf("%s: %d candles, %d missing, %d changed, %d unchanged",
+			sym, result.Minutes, result.Missing, result.Changed, result.Unchanged)
+	}
+
+	if *dryRun {
+		log.Println("Dry run, nothing was written")
+	}
+}
+
+func printDiffs(result *service.CandleRebuildResult, max int) {
+	for i, d := range result.Diffs {
+		if max > 0 && i >= max {
+			fmt.Printf("... %d more\n", len(result.Diffs)-max)
+			return
+		}
+
+		n := d.New
+		if d.Old == nil {
+			fmt.Printf("+ %s %s O=%g H=%g L=%g C=%g V=%g\n",
+				result.Symbol, d.OpenTime.UTC().Format(time.RFC3339), n.Open, n.High, n.Low, n.Close, n.Volume)
+			continue
+		}
+		o := d.Old
+		fmt.Printf("~ %s %s O=%g->%g H=%g->%g L=%g->%g C=%g->%g V=%g->%g\n",
+			result.Symbol, d.OpenTime.UTC().Format(time.RFC3339),
+			o.Open, n.Open, o.High, n.High, o.Low, n.Low, o.Close, n.Close, o.Volume, n.Volume)
+	}
+}
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func accountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrIncorrectPassword):
+		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+	case errors.Is(err, service.ErrInvalidPassword),
+		errors.Is(err, service.ErrInvalidAccountToken):
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	case errors.Is(err, service.ErrEmailAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+	case errors.Is(err, service.ErrMailRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": err.Error()})
+	default:
+		log.Printf("Account error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "System error"})
+	}
+}
+
+// ChangePassword sets a new password and signs out the user's other sessions
+func ChangePassword(events *service.EventBus, accounts *service.AccountService, refreshTokens *service.RefreshTokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		var req ChangePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "currentPassword, newPassword are required."})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := accounts.ChangePassword(ctx, userID.String(), req.CurrentPassword, req.NewPassword); err != nil {
+			accountError(c, err)
+			return
+		}
+
+		var err error
+		if current := currentSessionID(c); current != "" {
+			_, err = refreshTokens.RevokeOtherSessions(ctx, userID.String(), current)
+		} else {
+			_, err = refreshTokens.RevokeAll(ctx, userID.String())
+		}
+		if err != nil {
+			log.Printf("Error revoking sessions after password change: %v", err)
+		}
+
+		service.PublishSecurityEvent(events, userID.String(), service.SecurityPasswordChanged, c.ClientIP(), c.Request.UserAgent())
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ForgotPassword mails a reset link. It answers the same whether or not the
+// email belongs to an account: the lookup and the mail run after the response,
+// so its timing does not tell either.
+func ForgotPassword(accounts *service.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ForgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "email is required."})
+			return
+		}
+
+		go func(email string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := accounts.RequestPasswordReset(ctx, email); err != nil {
+				log.Printf("Password reset request error: %v", err)
+			}
+		}(req.Email)
+		c.JSON(http.StatusAccepted, gin.H{"message": "If the email belongs to an account, a reset link was sent."})
+	}
+}
+
+// ResetPassword sets a new password with a reset token, signs out every
+// session and lifts a login lockout
+func ResetPassword(events *service.EventBus, accounts *service.AccountService, refreshTokens *service.RefreshTokenService, throttle *service.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "token, newPassword are required."})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		userID, err := accounts.ResetPassword(ctx, req.Token, req.NewPassword)
+		if err != nil {
+			accountError(c, err)
+			return
+		}
+
+		if _, err := refreshTokens.RevokeAll(ctx, userID); err != nil {
+			log.Printf("Error revoking sessions after password reset: %v", err)
+		}
+		if err := throttle.Success(ctx, userID); err != nil {
+			log.Printf("Login throttle reset error: %v", err)
+		}
+
+		service.PublishSecurityEvent(events, userID, service.SecurityPasswordReset, c.ClientIP(), c.Request.UserAgent())
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// VerifyEmail verifies the user's email with the token mailed at registration
+func VerifyEmail(events *service.EventBus, accounts *service.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req VerifyEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "token is required."})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		userID, err := accounts.VerifyEmail(ctx, req.Token)
+		if err != nil {
+			accountError(c, err)
+			return
+		}
+
+		service.PublishSecurityEvent(events, userID, service.SecurityEmailVerified, c.ClientIP(), c.Request.UserAgent())
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func GetEmailStatus(accounts *service.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		e, err := accounts.Email(c.Request.Context(), userID.String())
+		if err != nil {
+			accountError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"email":      e.Email,
+			"verified":   e.VerifiedAt != nil,
+			"verifiedAt": e.VerifiedAt,
+		})
+	}
+}
+
+// ResendEmailVerification mails a new verification link
+func ResendEmailVerification(accounts *service.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		if err := accounts.SendEmailVerification(ctx, userID.String()); err != nil {
+			accountError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent."})
+	}
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/middleware"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreateAPIKeyRequest struct {
+	Label       string     `json:"label"`
+	Scopes      []string   `json:"scopes" binding:"required"`
+	IPAllowlist []string   `json:"ipAllowlist"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIKeyResponse is the only response that includes the secret
+type CreateAPIKeyResponse struct {
+	*models.APIKey
+	Secret string `json:"secret"`
+}
+
+func contextUserID(c *gin.Context) (uuid.UUID, bool) {
+	userVal, ok := c.Get("user")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return uuid.Nil, false
+	}
+	u, ok := userVal.(middleware.UserContext)
+	if !ok || u.ID == uuid.Nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Invalid user data in context"})
+		return uuid.Nil, false
+	}
+	return u.ID, true
+}
+
+func CreateAPIKey(apiKeys *service.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		var req CreateAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request: " + err.Error()})
+			return
+		}
+
+		key, secret, err := apiKeys.Create(c.Request.Context(), userID.String(), service.NewAPIKey{
+			Label:       req.Label,
+			Scopes:      req.Scopes,
+			IPAllowlist: req.IPAllowlist,
+			ExpiresAt:   req.ExpiresAt,
+		})
+		if errors.Is(err, service.ErrInvalidAPIKeyRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error creating API key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, CreateAPIKeyResponse{APIKey: key, Secret: secret})
+	}
+}
+
+func ListAPIKeys(apiKeys *service.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		keys, err := apiKeys.List(c.Request.Context(), userID.String())
+		if err != nil {
+			log.Printf("Error listing API keys: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+func RevokeAPIKey(apiKeys *service.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid API key id"})
+			return
+		}
+
+		revoked, err := apiKeys.Revoke(c.Request.Context(), userID.String(), id.String())
+		if err != nil {
+			log.Printf("Error revoking API key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+		if !revoked {
+			c.JSON(http.StatusNotFound, gin.H{"message": "API key not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	}
+}
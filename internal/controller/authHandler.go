@@ -3,9 +3,7 @@ package controller
 import (
 	"context"
 	"database/sql"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"net/http"
 	"time"
 	"log"
@@ -16,6 +14,8 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+
 	// "github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
 	// "github.com/dangdinh2405/cryto-trading-web-backend/internal/data"
 )
@@ -36,16 +36,17 @@ type SignInRequest struct {
 }
 
 type jwtClaims struct {
-	UserID string `json:"userId"`
+	UserID    string `json:"userId"`
+	SessionID string `json:"sid,omitempty"` // Refresh token family of the login
 	jwt.RegisteredClaims
 }
 
 
 var accessTTL = 15 * time.Minute
-var refreshTTL = 7 * 24 * time.Hour
+var refreshTTL = service.RefreshTokenTTL
 
 
-func Register(db *sql.DB) gin.HandlerFunc {
+func Register(db *sql.DB, accounts *service.AccountService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req RegisterRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -142,6 +143,11 @@ func Register(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// 7. Gửi email xác minh; nếu lỗi, user có thể gửi lại sau khi đăng nhập
+		if err := accounts.SendEmailVerification(ctx, userID.String()); err != nil {
+			log.Println("SendEmailVerification error:", err)
+		}
+
 		c.Status(http.StatusNoContent)
 	}
 }
@@ -161,10 +167,8 @@ func LogLoginActivity(db *sql.DB, userID uuid.UUID, ip, userAgent string, succes
 }
 
 
-func SignIn(db *sql.DB) gin.HandlerFunc {
+func SignIn(db *sql.DB, events *service.EventBus, refreshTokens *service.RefreshTokenService, devices *service.DeviceService, twofa *service.TwoFAService, throttle *service.LoginThrottle) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		jwtSecret := os.Getenv("ACCESS_TOKEN_SECRET")
-
 		var req SignInRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid data"})
@@ -184,149 +188,179 @@ func SignIn(db *sql.DB) gin.HandlerFunc {
 			userID       uuid.UUID
 			displayName  string
 			passwordHash string
+			twofaEnabled bool
 		)
 
 		err := db.QueryRowContext(ctx, `
 			SELECT 
 				u.id,
 				u.first_name || ' ' || u.last_name AS display_name,
-				ua.password_hash
+				ua.password_hash,
+				ua.twofa_enabled
 			FROM users u
 			JOIN user_auth ua ON ua.user_id = u.id
 			WHERE u.username = $1
-		`, req.Username).Scan(&userID, &displayName, &passwordHash)
+		`, req.Username).Scan(&userID, &displayName, &passwordHash, &twofaEnabled)
 
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusUnauthorized, gin.H{"message": "Incorrect username or password"})
-				return
-			}
+		if err != nil && err != sql.ErrNoRows {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
 			return
 		}
 
-		// 2. So sánh password
-		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Incorrect username or password"})
+		// 2. Chặn khi tài khoản hoặc IP đang bị khóa / phải chờ vì sai nhiều lần
+		if !checkLoginThrottle(c, throttle, userID) {
 			return
 		}
 
-		// 3. Tạo JWT access token
-		claims := jwtClaims{
-			UserID: userID.String(),
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTTL)),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-			},
+		if err == sql.ErrNoRows {
+			if !recordLoginFailure(c, db, events, throttle, uuid.Nil) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Incorrect username or password"})
+			return
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		accessToken, err := token.SignedString([]byte(jwtSecret))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (jwt)"})
+
+		// 3. So sánh password
+		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+			if !recordLoginFailure(c, db, events, throttle, userID) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Incorrect username or password"})
 			return
 		}
 
-		// 4. Tạo refresh token random
-		buf := make([]byte, 64)
-		if _, err := rand.Read(buf); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Lỗi hệ thống (gen refresh token)"})
+		// 4. Với 2FA: chỉ trả về challenge, token được cấp ở /auth/login/2fa
+		if twofaEnabled {
+			challenge, err := twofa.StartLogin(ctx, userID.String(), c.ClientIP())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (create login challenge)"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"twoFactorRequired": true,
+				"challengeToken":    challenge,
+				"expiresIn":         int(service.LoginChallengeTTL.Seconds()),
+			})
 			return
 		}
-		refreshToken := hex.EncodeToString(buf)
 
-		// 5. Hash refresh token trước khi lưu DB
-		hashBytes := sha256.Sum256([]byte(refreshToken))
-		tokenHash := hex.EncodeToString(hashBytes[:])
+		recordLoginSuccess(c, throttle, userID)
+		issueSession(c, db, events, refreshTokens, devices, userID, displayName)
+	}
+}
+
+// issueSession completes a login: access token, refresh token cookie, login
+// activity and security event
+func issueSession(c *gin.Context, db *sql.DB, events *service.EventBus, refreshTokens *service.RefreshTokenService, devices *service.DeviceService, userID uuid.UUID, displayName string) {
+	jwtSecret := os.Getenv("ACCESS_TOKEN_SECRET")
 
-		expiresAt := time.Now().Add(refreshTTL)
-		ipAddr := c.ClientIP()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
 
-		// 6. Lưu vào bảng refresh_tokens (đúng schema mới)
-		_, err = db.ExecContext(ctx, `
-			INSERT INTO refresh_tokens (
-				user_id,
-				token_hash,
-				ip_address,
-				expires_at
-			) VALUES ($1, $2, $3::inet, $4)
-		`,
-			userID,
-			tokenHash,
-			ipAddr,
-			expiresAt,
-		)
+	// 1. Tạo refresh token: mỗi lần đăng nhập là một family mới (một session)
+	ipAddr := c.ClientIP()
+	refreshToken, sessionID, err := refreshTokens.Issue(ctx, userID.String(), ipAddr, c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (create refresh token in postgres)"})
+		return
+	}
+
+	// 2. Tạo JWT access token gắn với session
+	claims := jwtClaims{
+		UserID:    userID.String(),
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	accessToken, err := token.SignedString([]byte(jwtSecret))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (jwt)"})
+		return
+	}
+
+	// 3. Set cookie refreshToken
+	setRefreshCookie(c, refreshToken)
+
+	// 4. Nhận diện thiết bị qua cookie deviceId, báo khi đăng nhập từ thiết bị mới
+	deviceID, err := c.Cookie("deviceId")
+	if err != nil || deviceID == "" {
+		if deviceID, err = service.NewDeviceID(); err != nil {
+			log.Println("NewDeviceID error:", err)
+		} else {
+			setDeviceCookie(c, deviceID)
+		}
+	}
+	if deviceID != "" {
+		newDevice, err := devices.Seen(ctx, userID.String(), deviceID, ipAddr, c.Request.UserAgent())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (create refresh token in postgres)"})
-			return
+			log.Println("Device check error:", err)
+		} else if newDevice {
+			service.PublishSecurityEvent(events, userID.String(), service.SecurityNewDevice, ipAddr, c.Request.UserAgent())
 		}
+	}
 
-		// 7. Set cookie refreshToken
-		// c.SetSameSite(http.SameSiteNoneMode)
-		secure := false // đổi true khi dùng HTTPS
-
-		c.SetCookie(
-			"refreshToken",
-			refreshToken,              // raw token chỉ nằm ở cookie
-			int(refreshTTL.Seconds()), // max-age
-			"/",
-			"",
-			secure,
-			true, // httpOnly
-		)
-		
-		LogLoginActivity(db, userID, ipAddr, c.Request.UserAgent(), true)
+	LogLoginActivity(db, userID, ipAddr, c.Request.UserAgent(), true)
+	service.PublishSecurityEvent(events, userID.String(), service.SecurityLogin, ipAddr, c.Request.UserAgent())
 
-		c.JSON(http.StatusOK, gin.H{
-			"message":     "User " + displayName + " đã logged in!",
-			"accessToken": accessToken,
-			"expiresIn":   int(accessTTL.Seconds()),
-		})
-	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "User " + displayName + " đã logged in!",
+		"accessToken": accessToken,
+		"expiresIn":   int(accessTTL.Seconds()),
+	})
 }
 
-func SignOut(db *sql.DB) gin.HandlerFunc {
+func SignOut(events *service.EventBus, refreshTokens *service.RefreshTokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		refreshToken, err := c.Cookie("refreshToken")
 		if err == nil && refreshToken != "" {
 
-			hashBytes := sha256.Sum256([]byte(refreshToken))
-			tokenHash := hex.EncodeToString(hashBytes[:])
-
 			ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 			defer cancel()
 
-			// Soft delete: cập nhật revoked_at
-			_, err := db.ExecContext(ctx, `
-				UPDATE refresh_tokens
-				SET revoked_at = NOW()
-				WHERE token_hash = $1
-				  AND revoked_at IS NULL
-			`, tokenHash)
+			// Thu hồi cả family của token (phiên đăng nhập này)
+			userID, err := refreshTokens.Revoke(ctx, refreshToken)
 
 			// Không xử lý lỗi (logout vẫn tiếp tục)
-			_ = err
-
-			// Xoá cookie
-			// c.SetSameSite(http.SameSiteNoneMode)
-			secure := false // dùng HTTPS thì đổi true
-
-			c.SetCookie(
-				"refreshToken",
-				"",   // xoá value
-				-1,   // MaxAge < 0 => trình duyệt xoá cookie
-				"/",
-				"",
-				secure,
-				true,
-			)
+			if err == nil {
+				service.PublishSecurityEvent(events, userID, service.SecurityLogout, c.ClientIP(), c.Request.UserAgent())
+			}
+
+			clearRefreshCookie(c)
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// SignOutAll revokes every refresh token of the user (logout everywhere).
+// RequireAuth refuses the access tokens of the revoked sessions from then on.
+func SignOutAll(events *service.EventBus, refreshTokens *service.RefreshTokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		if _, err := refreshTokens.RevokeAll(ctx, userID.String()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
 		}
+		service.PublishSecurityEvent(events, userID.String(), service.SecurityLogoutAll, c.ClientIP(), c.Request.UserAgent())
 
+		clearRefreshCookie(c)
 		c.Status(http.StatusNoContent)
 	}
 }
 
-func RefreshToken(db *sql.DB) gin.HandlerFunc {
+// RefreshToken issues a new access token and rotates the refresh token
+func RefreshToken(events *service.EventBus, refreshTokens *service.RefreshTokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		jwtSecret := os.Getenv("ACCESS_TOKEN_SECRET")
 
@@ -337,59 +371,41 @@ func RefreshToken(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// 2) Hash refresh token để so với token_hash trong DB
-		hashBytes := sha256.Sum256([]byte(refreshToken))
-		tokenHash := hex.EncodeToString(hashBytes[:])
-
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 
-		// 3) Tìm bản ghi refresh token trong DB
-		var (
-			userID    uuid.UUID
-			expiresAt time.Time
-			revokedAt *time.Time
-		)
-
-		err = db.QueryRowContext(ctx, `
-			SELECT user_id, expires_at, revoked_at
-			FROM refresh_tokens
-			WHERE token_hash = $1
-		`, tokenHash).Scan(&userID, &expiresAt, &revokedAt)
-
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusForbidden, gin.H{"message": "Token không hợp lệ hoặc đã hết hạn"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Lỗi hệ thống (db)"})
+		// 2) Đổi token cũ lấy token mới cùng family
+		userID, sessionID, nextToken, err := refreshTokens.Rotate(ctx, refreshToken, c.ClientIP(), c.Request.UserAgent())
+		switch {
+		case err == nil:
+		case errors.Is(err, service.ErrRefreshTokenReused):
+			// Token đã được dùng: có thể bị lộ, cả family đã bị thu hồi
+			service.PublishSecurityEvent(events, userID, service.SecurityRefreshTokenReuse, c.ClientIP(), c.Request.UserAgent())
+			clearRefreshCookie(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
 			return
-		}
-
-		// 4) Kiểm tra token đã bị revoke chưa
-		if revokedAt != nil {
+		case errors.Is(err, service.ErrRefreshTokenRaced):
+			// Một request song song vừa đổi token này; cookie mới đến từ response của nó
+			c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+			return
+		case errors.Is(err, service.ErrRefreshTokenInvalid):
+			c.JSON(http.StatusForbidden, gin.H{"message": "Token không hợp lệ hoặc đã hết hạn"})
+			return
+		case errors.Is(err, service.ErrRefreshTokenRevoked):
 			c.JSON(http.StatusForbidden, gin.H{"message": "Token đã bị thu hồi."})
 			return
-		}
-
-		// 5) Kiểm tra hết hạn
-		if time.Now().After(expiresAt) {
-			// Có thể xoá luôn bản ghi hết hạn nếu bạn muốn
-			// go func() {
-			// 	ctx2, cancel2 := context.WithTimeout(context.Background(), 3*time.Second)
-			// 	defer cancel2()
-			// 	_, _ = db.ExecContext(ctx2, `
-			// 		DELETE FROM refresh_tokens
-			// 		WHERE token_hash = $1
-			// 	`, tokenHash)
-			// }()
+		case errors.Is(err, service.ErrRefreshTokenExpired):
 			c.JSON(http.StatusForbidden, gin.H{"message": "Token đã hết hạn."})
 			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Lỗi hệ thống (db)"})
+			return
 		}
 
-		// 6) Tạo access token mới
+		// 3) Tạo access token mới
 		claims := jwtClaims{
-			UserID: userID.String(),
+			UserID:    userID,
+			SessionID: sessionID,
 			RegisteredClaims: jwt.RegisteredClaims{
 				ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTTL)),
 				IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -403,10 +419,51 @@ func RefreshToken(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// 7) Trả về access token mới
+		// 4) Set cookie với refresh token mới, trả về access token mới
+		setRefreshCookie(c, nextToken)
 		c.JSON(http.StatusOK, gin.H{
 			"accessToken": accessToken,
 			"expiresIn":   int(accessTTL.Seconds()),
 		})
 	}
 }
+
+// setRefreshCookie stores the raw refresh token in an httpOnly cookie
+func setRefreshCookie(c *gin.Context, refreshToken string) {
+	// c.SetSameSite(http.SameSiteNoneMode)
+	secure := false // đổi true khi dùng HTTPS
+
+	c.SetCookie(
+		"refreshToken",
+		refreshToken,              // raw token chỉ nằm ở cookie
+		int(refreshTTL.Seconds()), // max-age
+		"/",
+		"",
+		secure,
+		true, // httpOnly
+	)
+}
+
+// deviceCookieTTL keeps a browser recognized as a known device for a year
+const deviceCookieTTL = 365 * 24 * time.Hour
+
+func setDeviceCookie(c *gin.Context, deviceID string) {
+	secure := false // đổi true khi dùng HTTPS
+
+	c.SetCookie("deviceId", deviceID, int(deviceCookieTTL.Seconds()), "/", "", secure, true)
+}
+
+func clearRefreshCookie(c *gin.Context) {
+	// c.SetSameSite(http.SameSiteNoneMode)
+	secure := false // dùng HTTPS thì đổi true
+
+	c.SetCookie(
+		"refreshToken",
+		"",   // xoá value
+		-1,   // MaxAge < 0 => trình duyệt xoá cookie
+		"/",
+		"",
+		secure,
+		true,
+	)
+}
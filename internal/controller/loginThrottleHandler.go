@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+)
+
+type UnlockAccountRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// checkLoginThrottle answers 429 and returns false when logins of the user
+// (uuid.Nil when unknown) or from the client IP are blocked. Logins are
+// refused with 503 while the throttle cannot be read, never let through.
+func checkLoginThrottle(c *gin.Context, throttle *service.LoginThrottle, userID uuid.UUID) bool {
+	id := ""
+	if userID != uuid.Nil {
+		id = userID.String()
+	}
+	block, err := throttle.Check(c.Request.Context(), id, c.ClientIP())
+	if err != nil {
+		log.Printf("Login throttle check error: %v", err)
+		rejectUnthrottledLogin(c)
+		return false
+	}
+	if block == nil {
+		return true
+	}
+	rejectBlockedLogin(c, block)
+	return false
+}
+
+func rejectBlockedLogin(c *gin.Context, block *service.LoginBlock) {
+	retryAfter := int(block.RetryAfter().Seconds())
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	message := "Too many failed login attempts, try again later"
+	if block.Locked {
+		message = "Account temporarily locked after too many failed login attempts"
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"message":    message,
+		"locked":     block.Locked,
+		"retryAfter": retryAfter,
+	})
+}
+
+// rejectUnthrottledLogin answers 503 to a login the throttle could not check or count
+func rejectUnthrottledLogin(c *gin.Context) {
+	c.Header("Retry-After", "5")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Login is temporarily unavailable, try again later"})
+}
+
+// recordLoginFailure logs a failed login of the user (uuid.Nil when unknown)
+// and counts it against the user and the client IP. It answers 503 and
+// returns false when the failure could not be counted.
+func recordLoginFailure(c *gin.Context, db *sql.DB, events *service.EventBus, throttle *service.LoginThrottle, userID uuid.UUID) bool {
+	ip, ua := c.ClientIP(), c.Request.UserAgent()
+	id := ""
+	if userID != uuid.Nil {
+		id = userID.String()
+		LogLoginActivity(db, userID, ip, ua, false)
+		service.PublishSecurityEvent(events, id, service.SecurityLoginFailed, ip, ua)
+	}
+
+	block, err := throttle.Failure(c.Request.Context(), id, ip)
+	if err != nil {
+		log.Printf("Login throttle error: %v", err)
+		rejectUnthrottledLogin(c)
+		return false
+	}
+	if block != nil {
+		c.Header("Retry-After", strconv.Itoa(int(block.RetryAfter().Seconds())))
+		if block.NewLock {
+			service.PublishSecurityEvent(events, id, service.SecurityAccountLocked, ip, ua)
+		}
+	}
+	return true
+}
+
+// recordLoginSuccess clears the failed logins of the user
+func recordLoginSuccess(c *gin.Context, throttle *service.LoginThrottle, userID uuid.UUID) {
+	if err := throttle.Success(c.Request.Context(), userID.String()); err != nil {
+		log.Printf("Login throttle reset error: %v", err)
+	}
+}
+
+// UnlockAccount lifts a lockout with the token sent when the account was locked
+func UnlockAccount(events *service.EventBus, throttle *service.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UnlockAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "token is required."})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		userID, err := throttle.Unlock(ctx, req.Token)
+		if errors.Is(err, service.ErrInvalidUnlockToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Unlock account error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (unlock account)"})
+			return
+		}
+
+		service.PublishSecurityEvent(events, userID, service.SecurityAccountUnlocked, c.ClientIP(), c.Request.UserAgent())
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/middleware"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type PasskeyNameRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type PasskeyLoginBeginRequest struct {
+	Username string `json:"username"` // Optional: empty for discoverable credentials
+}
+
+// passkeyError writes the response of a WebAuthn service error
+func passkeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidPasskeyName):
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	case errors.Is(err, service.ErrPasskeyChallenge),
+		errors.Is(err, service.ErrPasskeyVerification),
+		errors.Is(err, service.ErrPasskeySignCount):
+		c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+	case errors.Is(err, service.ErrPasskeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	case errors.Is(err, service.ErrPasskeyExists):
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+	default:
+		log.Printf("Passkey error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+	}
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create
+func BeginPasskeyRegistration(webauthn *service.WebAuthnService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userVal, _ := c.Get("user")
+		user, ok := userVal.(middleware.UserContext)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+
+		var req PasskeyNameRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "name is required."})
+			return
+		}
+
+		opts, err := webauthn.BeginRegistration(c.Request.Context(), user.ID.String(), user.Username, user.FirstName+" "+user.LastName, req.Name)
+		if err != nil {
+			passkeyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, opts)
+	}
+}
+
+// FinishPasskeyRegistration verifies and stores the created credential
+func FinishPasskeyRegistration(webauthn *service.WebAuthnService, events *service.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		var req service.RegistrationResponse
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid data"})
+			return
+		}
+
+		cred, err := webauthn.FinishRegistration(c.Request.Context(), userID.String(), req)
+		if err != nil {
+			passkeyError(c, err)
+			return
+		}
+		service.PublishSecurityEvent(events, userID.String(), service.SecurityPasskeyAdded, c.ClientIP(), c.Request.UserAgent())
+
+		c.JSON(http.StatusCreated, cred)
+	}
+}
+
+func ListPasskeys(webauthn *service.WebAuthnService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		creds, err := webauthn.List(c.Request.Context(), userID.String())
+		if err != nil {
+			passkeyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, creds)
+	}
+}
+
+func RenamePasskey(webauthn *service.WebAuthnService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		var req PasskeyNameRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "name is required."})
+			return
+		}
+
+		if err := webauthn.Rename(c.Request.Context(), userID.String(), c.Param("id"), req.Name); err != nil {
+			passkeyError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func RemovePasskey(webauthn *service.WebAuthnService, events *service.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		if err := webauthn.Remove(c.Request.Context(), userID.String(), c.Param("id")); err != nil {
+			passkeyError(c, err)
+			return
+		}
+		service.PublishSecurityEvent(events, userID.String(), service.SecurityPasskeyRemoved, c.ClientIP(), c.Request.UserAgent())
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get
+func BeginPasskeyLogin(db *sql.DB, webauthn *service.WebAuthnService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PasskeyLoginBeginRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid data"})
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		// An unknown username gets options without credentials, like a user
+		// without passkeys, so usernames cannot be probed
+		var userID string
+		if req.Username != "" {
+			err := db.QueryRowContext(ctx, `
+				SELECT id FROM users WHERE username = $1
+			`, req.Username).Scan(&userID)
+			if err != nil && err != sql.ErrNoRows {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+				return
+			}
+		}
+
+		opts, err := webauthn.BeginLogin(ctx, userID)
+		if err != nil {
+			passkeyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, opts)
+	}
+}
+
+// FinishPasskeyLogin verifies the assertion and signs the user in with the
+// same tokens as SignIn. With 2FA on, a passkey used without user
+// verification still needs the TOTP step.
+func FinishPasskeyLogin(db *sql.DB, events *service.EventBus, refreshTokens *service.RefreshTokenService, devices *service.DeviceService, webauthn *service.WebAuthnService, twofa *service.TwoFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req service.AssertionResponse
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid data"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		login, err := webauthn.FinishLogin(ctx, req)
+		if err != nil {
+			if login != nil {
+				service.PublishSecurityEvent(events, login.UserID, service.SecurityLoginFailed, c.ClientIP(), c.Request.UserAgent())
+			}
+			passkeyError(c, err)
+			return
+		}
+
+		userID, err := uuid.Parse(login.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "invalid user id"})
+			return
+		}
+
+		var (
+			displayName  string
+			twofaEnabled bool
+		)
+		err = db.QueryRowContext(ctx, `
+			SELECT u.first_name || ' ' || u.last_name, ua.twofa_enabled
+			FROM users u
+			JOIN user_auth ua ON ua.user_id = u.id
+			WHERE u.id = $1
+			  AND u.status = 'active'
+		`, userID).Scan(&displayName, &twofaEnabled)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusUnauthorized, gin.H{"message": "User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+
+		if twofaEnabled && !login.UserVerified {
+			challenge, err := twofa.StartLogin(ctx, userID.String(), c.ClientIP())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (create login challenge)"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"twoFactorRequired": true,
+				"challengeToken":    challenge,
+				"expiresIn":         int(service.LoginChallengeTTL.Seconds()),
+			})
+			return
+		}
+
+		issueSession(c, db, events, refreshTokens, devices, userID, displayName)
+	}
+}
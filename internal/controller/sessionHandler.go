@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// currentSessionID is the session of the request's access token, "" for
+// tokens issued before sessions had ids
+func currentSessionID(c *gin.Context) string {
+	return c.GetString("sessionId")
+}
+
+func ListSessions(refreshTokens *service.RefreshTokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		sessions, err := refreshTokens.Sessions(c.Request.Context(), userID.String(), currentSessionID(c))
+		if err != nil {
+			log.Printf("Error listing sessions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeSession signs out one session. RequireAuth refuses its access tokens
+// from then on.
+func RevokeSession(events *service.EventBus, refreshTokens *service.RefreshTokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		sessionID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid session id"})
+			return
+		}
+
+		err = refreshTokens.RevokeSession(c.Request.Context(), userID.String(), sessionID.String())
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error revoking session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+		service.PublishSecurityEvent(events, userID.String(), service.SecuritySessionRevoked, c.ClientIP(), c.Request.UserAgent())
+
+		if sessionID.String() == currentSessionID(c) {
+			clearRefreshCookie(c)
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RevokeOtherSessions signs out every session but the caller's
+func RevokeOtherSessions(events *service.EventBus, refreshTokens *service.RefreshTokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		current := currentSessionID(c)
+		if current == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Current session unknown, sign in again"})
+			return
+		}
+
+		n, err := refreshTokens.RevokeOtherSessions(c.Request.Context(), userID.String(), current)
+		if err != nil {
+			log.Printf("Error revoking sessions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+		if n > 0 {
+			service.PublishSecurityEvent(events, userID.String(), service.SecuritySessionRevoked, c.ClientIP(), c.Request.UserAgent())
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -1,9 +1,10 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
-	"strconv"
 
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/handler"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/middleware"
 	"github.com/gin-gonic/gin"
@@ -29,21 +30,26 @@ func GetUserTradesHandler(db *sql.DB) gin.HandlerFunc {
 		// Get user ID as string
 		userId := user.ID.String()
 
-		// Get limit from query params (default 100)
-		limitStr := c.DefaultQuery("limit", "100")
-		limit, err := strconv.Atoi(limitStr)
-		if err != nil || limit <= 0 {
-			limit = 100
+		// Get filters and pagination from query params (default limit 100)
+		query, err := handler.ParseHistoryQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
 		// Fetch trades
 		tradeRepo := repo.NewTradeRepo(db)
-		trades, err := tradeRepo.GetByUserId(c.Request.Context(), userId, limit)
+		trades, next, err := tradeRepo.GetByUserId(c.Request.Context(), userId, query.TradeFilter())
+		if errors.Is(err, repo.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trades"})
 			return
 		}
 
+		handler.SetNextCursor(c, next)
 		c.JSON(http.StatusOK, trades)
 	}
 }
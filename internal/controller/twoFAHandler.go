@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/middleware"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type TwoFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type SignInTwoFARequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// twoFAError writes the response of a 2FA service error
+func twoFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTwoFACode):
+		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+	case errors.Is(err, service.ErrTwoFALocked):
+		twoFALocked(c, err)
+	case errors.Is(err, service.ErrTwoFAAlreadyEnabled),
+		errors.Is(err, service.ErrTwoFANotEnabled),
+		errors.Is(err, service.ErrTwoFANotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+	default:
+		log.Printf("2FA error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+	}
+}
+
+// twoFALocked answers 429 while 2FA code checks are locked
+func twoFALocked(c *gin.Context, err error) {
+	var locked *service.TwoFALockedError
+	if errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"message": err.Error()})
+}
+
+// SignInTwoFA is the second step of a login with 2FA: it exchanges the
+// challenge token from /auth/login and a TOTP or recovery code for tokens
+func SignInTwoFA(db *sql.DB, events *service.EventBus, refreshTokens *service.RefreshTokenService, devices *service.DeviceService, twofa *service.TwoFAService, throttle *service.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SignInTwoFARequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "challengeToken, code are required."})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		// Wrong codes count like wrong passwords, so a known password does
+		// not allow guessing codes over many challenges
+		userIDStr, err := twofa.LoginUser(ctx, req.ChallengeToken)
+		if errors.Is(err, service.ErrInvalidLoginChallenge) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("2FA login error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "invalid user id"})
+			return
+		}
+		if !checkLoginThrottle(c, throttle, userID) {
+			return
+		}
+
+		_, err = twofa.CompleteLogin(ctx, req.ChallengeToken, req.Code)
+		if errors.Is(err, service.ErrInvalidTwoFACode) {
+			if !recordLoginFailure(c, db, events, throttle, userID) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidLoginChallenge) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrTwoFALocked) {
+			twoFALocked(c, err)
+			return
+		}
+		if err != nil {
+			log.Printf("2FA login error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+
+		var displayName string
+		err = db.QueryRowContext(ctx, `
+			SELECT first_name || ' ' || last_name
+			FROM users
+			WHERE id = $1
+		`, userID).Scan(&displayName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			return
+		}
+
+		recordLoginSuccess(c, throttle, userID)
+		issueSession(c, db, events, refreshTokens, devices, userID, displayName)
+	}
+}
+
+func GetTwoFAStatus(twofa *service.TwoFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		status, err := twofa.Status(c.Request.Context(), userID.String())
+		if err != nil {
+			twoFAError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// EnrollTwoFA returns a new secret and its otpauth URI, to show as a QR code.
+// 2FA is enabled once a code is confirmed with EnableTwoFA.
+func EnrollTwoFA(twofa *service.TwoFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userVal, _ := c.Get("user")
+		user, ok := userVal.(middleware.UserContext)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+
+		secret, uri, err := twofa.Enroll(c.Request.Context(), user.ID.String(), user.Email)
+		if err != nil {
+			twoFAError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":     secret,
+			"otpauthUri": uri,
+		})
+	}
+}
+
+// EnableTwoFA confirms enrollment with a code and returns the recovery codes
+func EnableTwoFA(twofa *service.TwoFAService, events *service.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		var req TwoFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "code is required."})
+			return
+		}
+
+		codes, err := twofa.Enable(c.Request.Context(), userID.String(), req.Code)
+		if err != nil {
+			twoFAError(c, err)
+			return
+		}
+		service.PublishSecurityEvent(events, userID.String(), service.SecurityTwoFAEnabled, c.ClientIP(), c.Request.UserAgent())
+
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
+
+func DisableTwoFA(twofa *service.TwoFAService, events *service.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		var req TwoFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "code is required."})
+			return
+		}
+
+		if err := twofa.Disable(c.Request.Context(), userID.String(), req.Code); err != nil {
+			twoFAError(c, err)
+			return
+		}
+		service.PublishSecurityEvent(events, userID.String(), service.SecurityTwoFADisabled, c.ClientIP(), c.Request.UserAgent())
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, invalidating the old ones
+func RegenerateRecoveryCodes(twofa *service.TwoFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+
+		var req TwoFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "code is required."})
+			return
+		}
+
+		codes, err := twofa.RegenerateRecoveryCodes(c.Request.Context(), userID.String(), req.Code)
+		if err != nil {
+			twoFAError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
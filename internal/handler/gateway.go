@@ -0,0 +1,540 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// Topic kinds of the /ws gateway
+const (
+	topicCandles = "candles" // candles.<SYMBOL>.<interval>
+	topicDepth   = "depth"   // depth.<SYMBOL>
+	topicTrades  = "trades"  // trades.<SYMBOL>
+	topicTicker  = "ticker"  // ticker.<SYMBOL> or ticker.*
+)
+
+// Gateway error codes
+const (
+	gwInvalidRequest      = "invalid_request"
+	gwUnknownMethod       = "unknown_method"
+	gwInvalidTopic        = "invalid_topic"
+	gwUnknownSymbol       = "unknown_symbol"
+	gwUnsupportedInterval = "unsupported_interval"
+	gwUnavailable         = "unavailable"
+	gwTooManySubs         = "too_many_subscriptions"
+)
+
+// marketsReloadInterval limits how often an unknown symbol reloads the markets
+const marketsReloadInterval = 10 * time.Second
+
+// GatewayRequest is a client request on /ws:
+// {"id": 1, "method": "subscribe", "topics": ["depth.BTCUSDT"]}
+type GatewayRequest struct {
+	ID     interface{} `json:"id,omitempty"` // Echoed in the ack and errors
+	Method string      `json:"method"`       // "subscribe", "unsubscribe", "list"
+	Topics []string    `json:"topics"`
+}
+
+// GatewayMessage is the envelope of every message sent on /ws.
+//
+// Types: "ack" (request done, with the applied topics), "error" (one failed
+// request or topic), "snapshot" (current state right after subscribing) and
+// "update" (a change on a subscribed topic).
+type GatewayMessage struct {
+	Type   string        `json:"type"`
+	ID     interface{}   `json:"id,omitempty"`
+	Topic  string        `json:"topic,omitempty"`
+	Topics []string      `json:"topics,omitempty"`
+	Data   interface{}   `json:"data,omitempty"`
+	Error  *GatewayError `json:"error,omitempty"`
+}
+
+// GatewayError describes why a request or topic was rejected
+type GatewayError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// gatewayCandle is a candle on a candles topic
+type gatewayCandle struct {
+	models.OHLCV
+	Interval string `json:"interval"`
+	Closed   bool   `json:"closed"` // Final candle of the bucket, sent once it is saved
+}
+
+// gatewayTopic is a parsed topic
+type gatewayTopic struct {
+	name     string // Canonical name, e.g. "depth.BTCUSDT"
+	kind     string
+	symbol   string // Compact symbol, "*" for all tickers
+	interval string // Candles only
+	market   *models.Market
+}
+
+// gatewaySink queues the messages of a gateway client: a WebSocket
+// connection or an SSE stream
+type gatewaySink interface {
+	enqueue(key string, msg *wsMessage) bool
+	close()
+}
+
+// gatewayClient is one /ws connection or /sse stream
+type gatewayClient struct {
+	gatewaySink
+	gateway *Gateway
+	topics  map[string]*gatewaySub // Only used by the gateway loop
+}
+
+// gatewaySub is one topic subscription of a client
+type gatewaySub struct {
+	last int64 // Depth: last update id sent
+}
+
+type gatewayRequest struct {
+	client      *gatewayClient
+	req         GatewayRequest
+	lastEventID string // SSE resume: replay the topics' updates after this event
+}
+
+// Gateway multiplexes every public market data stream over one /ws
+// connection with topic subscriptions. Subscriptions and broadcasts are
+// handled by a single loop, so a depth snapshot and the diffs after it reach
+// a client in update id order.
+type Gateway struct {
+	clients    map[*gatewayClient]bool
+	subs       map[string]map[*gatewayClient]*gatewaySub // topic -> subscribers
+	events     chan service.Event
+	requests   chan gatewayRequest
+	register   chan *gatewayClient
+	unregister chan *gatewayClient
+
+	marketRepo *repo.MarketRepo
+	tickers    *service.TickerService
+	books      *service.BookService
+
+	markets         map[string]*models.Market // Compact symbol -> market
+	marketsLoadedAt time.Time
+
+	candles map[string]gatewayCandle // Candles topic -> latest in-progress candle
+
+	epoch  string                   // Event id prefix of this process
+	seq    int64                    // Sequence of the last update
+	replay map[string]*replayBuffer // Topic -> recent updates
+
+	guard *wsGuard
+}
+
+// NewGateway creates the /ws gateway
+func NewGateway(marketRepo *repo.MarketRepo, tickers *service.TickerService, books *service.BookService, guard *wsGuard) *Gateway {
+	return &Gateway{
+		clients:    make(map[*gatewayClient]bool),
+		subs:       make(map[string]map[*gatewayClient]*gatewaySub),
+		events:     make(chan service.Event, 1024),
+		requests:   make(chan gatewayRequest, 256),
+		register:   make(chan *gatewayClient),
+		unregister: make(chan *gatewayClient),
+		marketRepo: marketRepo,
+		tickers:    tickers,
+		books:      books,
+		markets:    make(map[string]*models.Market),
+		candles:    make(map[string]gatewayCandle),
+		epoch:      newReplayEpoch(),
+		replay:     make(map[string]*replayBuffer),
+		guard:      guard,
+	}
+}
+
+// compactSymbol turns a market symbol into its topic form: "BTC/USDT" -> "BTCUSDT"
+func compactSymbol(symbol string) string {
+	return strings.ToUpper(strings.ReplaceAll(symbol, "/", ""))
+}
+
+// Run starts the gateway's main loop
+func (g *Gateway) Run() {
+	for {
+		select {
+		case client := <-g.register:
+			g.clients[client] = true
+			log.Printf("Gateway client connected. Total clients: %d", len(g.clients))
+
+		case client := <-g.unregister:
+			if g.clients[client] {
+				for topic := range client.topics {
+					g.removeSub(topic, client)
+				}
+				delete(g.clients, client)
+				client.close()
+			}
+			log.Printf("Gateway client disconnected. Total clients: %d", len(g.clients))
+
+		case r := <-g.requests:
+			if g.clients[r.client] {
+				g.handleRequest(r)
+			}
+
+		case ev := <-g.events:
+			g.dispatch(ev)
+		}
+	}
+}
+
+// StartGatewayBroadcaster forwards market events from the bus to the gateway loop
+func (g *Gateway) StartGatewayBroadcaster(events *service.EventBus) {
+	ch, unsubscribe := events.Subscribe(4096)
+	defer unsubscribe()
+
+	log.Println("Gateway broadcaster started")
+
+	for ev := range ch {
+		switch ev.Type {
+		case service.EventCandles, service.EventTrades, service.EventTicker, service.EventBookDiff:
+			g.events <- ev
+		}
+	}
+}
+
+// dispatch sends an event to the subscribers of its topics
+func (g *Gateway) dispatch(ev service.Event) {
+	switch ev.Type {
+	case service.EventCandles:
+		for _, c := range ev.Candles {
+			topic := topicCandles + "." + compactSymbol(c.Symbol) + "." + ev.Interval
+			candle := gatewayCandle{OHLCV: c, Interval: ev.Interval, Closed: ev.Closed}
+			if !ev.Closed {
+				g.candles[topic] = candle
+			}
+			// A live candle supersedes the queued one; closed candles are all delivered
+			g.publish(topic, candle, !ev.Closed)
+		}
+
+	case service.EventTrades:
+		trades := make([]repo.PublicTrade, len(ev.Trades))
+		for i, t := range ev.Trades {
+			trades[i] = repo.PublicTrade{
+				ID: t.Seq, Price: t.Price, Amount: t.Amount, QuoteAmount: t.QuoteAmount,
+				TakerSide: t.TakerSide, Time: t.TradeTime,
+			}
+		}
+		g.publish(topicTrades+"."+compactSymbol(ev.Symbol), trades, false)
+
+	case service.EventTicker:
+		for _, t := range ev.Tickers {
+			g.publishTicker(t)
+		}
+
+	case service.EventBookDiff:
+		diff := ev.BookDiff
+		if diff == nil {
+			return
+		}
+		topic := topicDepth + "." + compactSymbol(diff.Symbol)
+		msg := newWSMessage(GatewayMessage{Type: "update", Topic: topic, Data: diff})
+		g.record(topic, msg)
+		for client, sub := range g.subs[topic] {
+			// Diffs up to the snapshot's update id are already in it
+			if diff.LastUpdateID <= sub.last {
+				continue
+			}
+			sub.last = diff.LastUpdateID
+			client.deliver("", msg)
+		}
+	}
+}
+
+// publish sends data as an update to every subscriber of the topic. A
+// conflatable update may replace the topic's previous one in a slow client's queue.
+func (g *Gateway) publish(topic string, data interface{}, conflate bool) {
+	msg := newWSMessage(GatewayMessage{Type: "update", Topic: topic, Data: data})
+	g.record(topic, msg)
+
+	key := ""
+	if conflate {
+		key = topic
+	}
+	for client := range g.subs[topic] {
+		client.deliver(key, msg)
+	}
+}
+
+// publishTicker sends a ticker once to each client subscribed to its symbol or to ticker.*
+func (g *Gateway) publishTicker(t service.Ticker) {
+	topic := topicTicker + "." + compactSymbol(t.Symbol)
+	msg := newWSMessage(GatewayMessage{Type: "update", Topic: topic, Data: t})
+	g.record(topic, msg)
+
+	bySymbol, all := g.subs[topic], g.subs[topicTicker+".*"]
+	for client := range bySymbol {
+		client.deliver(topic, msg)
+	}
+	for client := range all {
+		if _, dup := bySymbol[client]; !dup {
+			client.deliver(topic, msg)
+		}
+	}
+}
+
+// handleRequest applies a client request; it runs on the gateway loop
+func (g *Gateway) handleRequest(r gatewayRequest) {
+	c, req := r.client, r.req
+	switch req.Method {
+	case "subscribe":
+		after, resume := int64(0), false
+		if r.lastEventID != "" {
+			after, resume = g.parseEventID(r.lastEventID)
+		}
+
+		var done []string
+		for _, name := range req.Topics {
+			topic, gerr := g.parseTopic(name)
+			if gerr != nil {
+				c.sendError(req.ID, name, gerr)
+				continue
+			}
+			if gerr := g.subscribe(c, topic, after, resume); gerr != nil {
+				c.sendError(req.ID, topic.name, gerr)
+				continue
+			}
+			done = append(done, topic.name)
+		}
+		c.sendMessage(GatewayMessage{Type: "ack", ID: req.ID, Topics: nonNil(done)})
+
+	case "unsubscribe":
+		var done []string
+		for _, name := range req.Topics {
+			name = canonicalTopic(name)
+			if _, ok := c.topics[name]; ok {
+				g.removeSub(name, c)
+				delete(c.topics, name)
+				done = append(done, name)
+			}
+		}
+		c.sendMessage(GatewayMessage{Type: "ack", ID: req.ID, Topics: nonNil(done)})
+
+	case "list":
+		topics := make([]string, 0, len(c.topics))
+		for name := range c.topics {
+			topics = append(topics, name)
+		}
+		sort.Strings(topics)
+		c.sendMessage(GatewayMessage{Type: "ack", ID: req.ID, Topics: topics})
+
+	default:
+		c.sendError(req.ID, "", &GatewayError{Code: gwUnknownMethod, Message: fmt.Sprintf("unknown method %q", req.Method)})
+	}
+}
+
+// subscribe adds the subscription and sends the topic's current state if it has one.
+// Subscribing again to a topic re-sends its snapshot, which is how depth resyncs.
+// A resumed subscription replays the updates after the given sequence instead,
+// when they are all still buffered.
+func (g *Gateway) subscribe(c *gatewayClient, topic gatewayTopic, after int64, resume bool) *GatewayError {
+	if _, resubscribe := c.topics[topic.name]; !resubscribe && g.guard.subscriptionsFull(len(c.topics)) {
+		return &GatewayError{Code: gwTooManySubs, Message: fmt.Sprintf("at most %d topics per connection", g.guard.cfg.MaxSubscriptions)}
+	}
+	sub := &gatewaySub{}
+
+	var replay []*wsMessage
+	if resume {
+		replay, resume = g.replayAfter(topic.name, after)
+	}
+
+	switch {
+	case resume:
+		// The client is up to date as of the resumed event: later depth diffs all apply
+		for _, msg := range replay {
+			c.deliver("", msg)
+		}
+
+	case topic.kind == topicDepth:
+		snapshot, ok := g.books.Snapshot(topic.market.ID, 0)
+		if !ok {
+			return &GatewayError{Code: gwUnavailable, Message: "order book not loaded"}
+		}
+		sub.last = snapshot.LastUpdateID
+		c.sendMessage(GatewayMessage{Type: "snapshot", Topic: topic.name, Data: snapshot})
+
+	case topic.kind == topicCandles:
+		if candle, ok := g.candles[topic.name]; ok {
+			c.sendMessage(GatewayMessage{Type: "snapshot", Topic: topic.name, Data: candle})
+		}
+
+	case topic.kind == topicTicker:
+		if topic.symbol == allTickers {
+			c.sendMessage(GatewayMessage{Type: "snapshot", Topic: topic.name, Data: g.tickers.All()})
+		} else if t, ok := g.tickers.Get(topic.market.Symbol); ok {
+			c.sendMessage(GatewayMessage{Type: "snapshot", Topic: topic.name, Data: t})
+		}
+	}
+
+	c.topics[topic.name] = sub
+	if g.subs[topic.name] == nil {
+		g.subs[topic.name] = make(map[*gatewayClient]*gatewaySub)
+	}
+	g.subs[topic.name][c] = sub
+	return nil
+}
+
+func (g *Gateway) removeSub(topic string, c *gatewayClient) {
+	delete(g.subs[topic], c)
+	if len(g.subs[topic]) == 0 {
+		delete(g.subs, topic)
+	}
+}
+
+// canonicalTopic uppercases the symbol part of a topic name
+func canonicalTopic(name string) string {
+	parts := strings.Split(name, ".")
+	if len(parts) >= 2 {
+		parts[0] = strings.ToLower(parts[0])
+		parts[1] = compactSymbol(parts[1])
+	}
+	return strings.Join(parts, ".")
+}
+
+// parseTopic validates a topic name and resolves its market
+func (g *Gateway) parseTopic(name string) (gatewayTopic, *GatewayError) {
+	name = canonicalTopic(name)
+	parts := strings.Split(name, ".")
+	invalid := &GatewayError{Code: gwInvalidTopic, Message: fmt.Sprintf("invalid topic %q", name)}
+	if len(parts) < 2 || parts[1] == "" {
+		return gatewayTopic{}, invalid
+	}
+
+	topic := gatewayTopic{name: name, kind: parts[0], symbol: parts[1]}
+	switch topic.kind {
+	case topicCandles:
+		if len(parts) != 3 {
+			return topic, invalid
+		}
+		topic.interval = parts[2]
+		if !validInterval(topic.interval) {
+			return topic, &GatewayError{Code: gwUnsupportedInterval, Message: fmt.Sprintf("interval must be one of %s", strings.Join(repo.CandleIntervals(), ", "))}
+		}
+	case topicDepth, topicTrades:
+		if len(parts) != 2 {
+			return topic, invalid
+		}
+	case topicTicker:
+		if len(parts) != 2 {
+			return topic, invalid
+		}
+		if topic.symbol == allTickers {
+			return topic, nil
+		}
+	default:
+		return topic, invalid
+	}
+
+	market, err := g.lookupMarket(topic.symbol)
+	if err != nil {
+		return topic, &GatewayError{Code: gwUnknownSymbol, Message: err.Error()}
+	}
+	topic.market = market
+	return topic, nil
+}
+
+func validInterval(interval string) bool {
+	for _, iv := range repo.CandleIntervals() {
+		if iv == interval {
+			return true
+		}
+	}
+	return false
+}
+
+var errUnknownSymbol = errors.New("unknown symbol")
+
+// lookupMarket resolves a compact symbol, reloading the active markets at most
+// once per marketsReloadInterval when it is not known
+func (g *Gateway) lookupMarket(symbol string) (*models.Market, error) {
+	if m, ok := g.markets[symbol]; ok {
+		return m, nil
+	}
+	if time.Since(g.marketsLoadedAt) < marketsReloadInterval {
+		return nil, errUnknownSymbol
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	g.marketsLoadedAt = time.Now()
+	markets, err := g.marketRepo.GetAllActiveMarkets(ctx)
+	if err != nil {
+		log.Printf("Gateway: error loading markets: %v", err)
+		return nil, errUnknownSymbol
+	}
+	g.markets = make(map[string]*models.Market, len(markets))
+	for i := range markets {
+		g.markets[compactSymbol(markets[i].Symbol)] = &markets[i]
+	}
+
+	if m, ok := g.markets[symbol]; ok {
+		return m, nil
+	}
+	return nil, errUnknownSymbol
+}
+
+func nonNil(topics []string) []string {
+	if topics == nil {
+		return []string{}
+	}
+	return topics
+}
+
+// deliver queues a message; a slow client is handled by the slow-consumer
+// policy. Depth subscribers that miss diffs see the update id gap and resubscribe.
+func (c *gatewayClient) deliver(key string, msg *wsMessage) {
+	c.enqueue(key, msg)
+}
+
+// sendMessage queues a message to this client only
+func (c *gatewayClient) sendMessage(msg GatewayMessage) {
+	c.deliver("", newWSMessage(msg))
+}
+
+func (c *gatewayClient) sendError(id interface{}, topic string, gerr *GatewayError) {
+	c.sendMessage(GatewayMessage{Type: "error", ID: id, Topic: topic, Error: gerr})
+}
+
+// HandleWebSocket handles new /ws connections. Messages are JSON text frames
+// unless the client negotiates MessagePack binary frames with the "msgpack"
+// subprotocol or ?encoding=msgpack.
+func (g *Gateway) HandleWebSocket(c *gin.Context) {
+	conn, ok := g.guard.accept(c, "")
+	if !ok {
+		return
+	}
+
+	client := &gatewayClient{
+		gatewaySink: conn,
+		gateway:     g,
+		topics:      make(map[string]*gatewaySub),
+	}
+
+	g.register <- client
+
+	go conn.writePump()
+	go conn.readPump(func(messageType int, message []byte) {
+		client.onMessage(conn.encoding, messageType, message)
+	}, func() { g.unregister <- client })
+}
+
+// onMessage parses a request and hands it to the gateway loop
+func (c *gatewayClient) onMessage(encoding wsEncoding, messageType int, message []byte) {
+	var req GatewayRequest
+	if err := encoding.unmarshal(messageType, message, &req); err != nil {
+		c.sendError(nil, "", &GatewayError{Code: gwInvalidRequest, Message: "request must be an object"})
+		return
+	}
+	c.gateway.requests <- gatewayRequest{client: c, req: req}
+}
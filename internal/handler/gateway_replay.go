@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replayBufferSize is the number of recent updates kept per topic for
+// Last-Event-ID resume
+const replayBufferSize = 100
+
+// replayEntry is one buffered update with its sequence number
+type replayEntry struct {
+	seq int64
+	msg *wsMessage
+}
+
+// replayBuffer holds the latest updates of a topic
+type replayBuffer struct {
+	entries []replayEntry
+	evicted int64 // Sequence of the newest update no longer buffered
+}
+
+// newReplayEpoch identifies this process in event ids: sequences restart with
+// the process, so an id from another epoch cannot be resumed from
+func newReplayEpoch() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// record numbers an update of a topic, sets its event id and buffers it. Every
+// update is recorded, with or without subscribers, so a resumed stream has no
+// hole; messages are only serialized when sent.
+func (g *Gateway) record(topic string, msg *wsMessage) {
+	g.seq++
+	msg.eventID = g.epoch + "-" + strconv.FormatInt(g.seq, 10)
+
+	buf, ok := g.replay[topic]
+	if !ok {
+		buf = &replayBuffer{}
+		g.replay[topic] = buf
+	}
+	if len(buf.entries) >= replayBufferSize {
+		buf.evicted = buf.entries[0].seq
+		buf.entries = buf.entries[1:]
+	}
+	buf.entries = append(buf.entries, replayEntry{seq: g.seq, msg: msg})
+}
+
+// parseEventID returns the sequence of an event id of this process
+func (g *Gateway) parseEventID(id string) (int64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != g.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || n < 0 || n > g.seq {
+		return 0, false
+	}
+	return n, true
+}
+
+// replayAfter returns the updates of a topic after the given sequence, in
+// order. ok is false when some of them are no longer buffered. ticker.*
+// replays the updates of every ticker topic.
+func (g *Gateway) replayAfter(topic string, after int64) (msgs []*wsMessage, ok bool) {
+	var buffers []*replayBuffer
+	if topic == topicTicker+"."+allTickers {
+		for name, buf := range g.replay {
+			if strings.HasPrefix(name, topicTicker+".") {
+				buffers = append(buffers, buf)
+			}
+		}
+	} else if buf, found := g.replay[topic]; found {
+		buffers = append(buffers, buf)
+	}
+
+	var entries []replayEntry
+	for _, buf := range buffers {
+		if buf.evicted > after {
+			return nil, false
+		}
+		i := sort.Search(len(buf.entries), func(i int) bool { return buf.entries[i].seq > after })
+		entries = append(entries, buf.entries[i:]...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	msgs = make([]*wsMessage, len(entries))
+	for i, e := range entries {
+		msgs[i] = e.msg
+	}
+	return msgs, true
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func newReplayGateway() *Gateway {
+	return &Gateway{epoch: "ep", replay: make(map[string]*replayBuffer)}
+}
+
+// recordValues records one update per value on topic, the value being its payload
+func recordValues(g *Gateway, topic string, values ...string) {
+	for _, v := range values {
+		g.record(topic, newWSMessage(v))
+	}
+}
+
+func values(msgs []*wsMessage) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.value.(string)
+	}
+	return out
+}
+
+func TestGatewayRecordEventIDs(t *testing.T) {
+	g := newReplayGateway()
+	a, b := newWSMessage("a"), newWSMessage("b")
+	g.record("trade.BTCUSDT", a)
+	g.record("depth.BTCUSDT", b)
+
+	if a.eventID != "ep-1" || b.eventID != "ep-2" {
+		t.Errorf("event ids = %q, %q, want ep-1, ep-2", a.eventID, b.eventID)
+	}
+}
+
+func TestGatewayParseEventID(t *testing.T) {
+	g := newReplayGateway()
+	recordValues(g, "trade.BTCUSDT", "a", "b", "c")
+
+	tests := []struct {
+		id     string
+		want   int64
+		wantOK bool
+	}{
+		{"ep-0", 0, true},
+		{"ep-2", 2, true},
+		{"ep-3", 3, true},
+		{"ep-4", 0, false}, // Not issued yet
+		{"ep--1", 0, false},
+		{"other-2", 0, false}, // Another process
+		{"ep-x", 0, false},
+		{"2", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := g.parseEventID(tt.id)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseEventID(%q) = (%d, %v), want (%d, %v)", tt.id, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestGatewayReplayAfter(t *testing.T) {
+	g := newReplayGateway()
+	recordValues(g, "trade.BTCUSDT", "t1")    // 1
+	recordValues(g, "ticker.BTCUSDT", "btc1") // 2
+	recordValues(g, "ticker.ETHUSDT", "eth1") // 3
+	recordValues(g, "trade.BTCUSDT", "t2")    // 4
+	recordValues(g, "ticker.BTCUSDT", "btc2") // 5
+	recordValues(g, "depth.BTCUSDT", "d1")    // 6
+	recordValues(g, "ticker.ETHUSDT", "eth2") // 7
+
+	tests := []struct {
+		name   string
+		topic  string
+		after  int64
+		want   []string
+		wantOK bool
+	}{
+		{"from start", "trade.BTCUSDT", 0, []string{"t1", "t2"}, true},
+		{"after first update", "trade.BTCUSDT", 1, []string{"t2"}, true},
+		{"between updates", "trade.BTCUSDT", 3, []string{"t2"}, true},
+		{"up to date", "trade.BTCUSDT", 7, []string{}, true},
+		{"unknown topic", "trade.SOLUSDT", 0, []string{}, true},
+		{"every ticker in order", "ticker.*", 0, []string{"btc1", "eth1", "btc2", "eth2"}, true},
+		{"every ticker after", "ticker.*", 3, []string{"btc2", "eth2"}, true},
+		{"one ticker", "ticker.ETHUSDT", 2, []string{"eth1", "eth2"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, ok := g.replayAfter(tt.topic, tt.after)
+			if ok != tt.wantOK {
+				t.Fatalf("replayAfter ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got := values(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replayAfter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGatewayReplayEviction(t *testing.T) {
+	g := newReplayGateway()
+	for i := 1; i <= replayBufferSize+5; i++ {
+		recordValues(g, "trade.BTCUSDT", fmt.Sprint(i))
+	}
+	recordValues(g, "ticker.ETHUSDT", "eth") // replayBufferSize+6, never evicted
+
+	tests := []struct {
+		name      string
+		topic     string
+		after     int64
+		wantFirst string
+		wantLen   int
+		wantOK    bool
+	}{
+		{"evicted updates", "trade.BTCUSDT", 0, "", 0, false},
+		{"just before the last evicted", "trade.BTCUSDT", 4, "", 0, false},
+		{"from the last evicted", "trade.BTCUSDT", 5, "6", replayBufferSize, true},
+		{"buffered updates", "trade.BTCUSDT", 50, "51", replayBufferSize + 5 - 50, true},
+		{"other topic unaffected", "ticker.*", 0, "eth", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, ok := g.replayAfter(tt.topic, tt.after)
+			if ok != tt.wantOK {
+				t.Fatalf("replayAfter ok = %v, want %v", ok, tt.wantOK)
+			}
+			if len(msgs) != tt.wantLen {
+				t.Fatalf("replayAfter returned %d updates, want %d", len(msgs), tt.wantLen)
+			}
+			if tt.wantLen > 0 && msgs[0].value != tt.wantFirst {
+				t.Errorf("first update = %v, want %s", msgs[0].value, tt.wantFirst)
+			}
+		})
+	}
+}
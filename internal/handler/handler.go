@@ -10,21 +10,48 @@ type Handler struct {
 	MarketHandler *MarketHandler
 	WSHub         *Hub
 	OrderbookHub  *OrderbookHub
+	TickerHub     *TickerHub
+	L3Hub         *L3Hub
+	UserHub       *UserHub
+	Gateway       *Gateway
 }
 
-func NewHandler(orderSvc *service.OrderService, marketRepo *repo.MarketRepo, orderRepo *repo.OrderRepo, cache interface{}) *Handler {
-	hub := NewHub(marketRepo, cache)
+func NewHandler(orderSvc *service.OrderService, tickerSvc *service.TickerService, bookSvc *service.BookService, l3Svc *service.L3Service, events *service.EventBus, marketRepo *repo.MarketRepo, orderRepo *repo.OrderRepo, tradeRepo *repo.TradeRepo, cache interface{}, wsCfg WSConfig) *Handler {
+	guard := newWSGuard(wsCfg)
+	go guard.startSessionRevoker(events)
+
+	hub := NewHub(marketRepo, guard)
 	go hub.Run()
-	go hub.StartCandleBroadcaster()
+	go hub.StartCandleBroadcaster(events)
 
-	orderbookHub := NewOrderbookHub(orderRepo, cache)
+	orderbookHub := NewOrderbookHub(bookSvc, guard)
 	go orderbookHub.Run()
-	go orderbookHub.StartOrderbookBroadcaster(marketRepo)
+	go orderbookHub.StartOrderbookBroadcaster(events)
+
+	tickerHub := NewTickerHub(tickerSvc, guard)
+	go tickerHub.Run()
+	go tickerHub.StartTickerBroadcaster(events)
+
+	l3Hub := NewL3Hub(l3Svc, guard)
+	go l3Hub.Run()
+	go l3Hub.StartL3Broadcaster(events)
+
+	userHub := NewUserHub(guard)
+	go userHub.Run()
+	go userHub.StartUserBroadcaster(events)
+
+	gateway := NewGateway(marketRepo, tickerSvc, bookSvc, guard)
+	go gateway.Run()
+	go gateway.StartGatewayBroadcaster(events)
 	
 	return &Handler{
 		OrderHandler:  NewOrderHandler(orderSvc),
-		MarketHandler: NewMarketHandler(marketRepo),
+		MarketHandler: NewMarketHandler(marketRepo, tradeRepo, tickerSvc, bookSvc, cache),
 		WSHub:         hub,
 		OrderbookHub:  orderbookHub,
+		TickerHub:     tickerHub,
+		L3Hub:         l3Hub,
+		UserHub:       userHub,
+		Gateway:       gateway,
 	}
 }
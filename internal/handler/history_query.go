@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 500
+)
+
+// HistoryQuery holds the filters shared by the order and trade history endpoints
+type HistoryQuery struct {
+	Symbol        string
+	MarketID      string
+	Side          models.OrderSide
+	Type          models.OrderType
+	Statuses      []models.OrderStatus
+	From          *time.Time
+	To            *time.Time
+	ClientOrderID string
+	Cursor        *repo.Cursor
+	Limit         int
+}
+
+// ParseHistoryQuery reads history filters from the query string:
+// symbol, marketId, side, type, status (comma separated), startTime, endTime (RFC3339),
+// clientOrderId, cursor and limit (default 100, max 500)
+func ParseHistoryQuery(c *gin.Context) (HistoryQuery, error) {
+	q := HistoryQuery{
+		Symbol:        c.Query("symbol"),
+		MarketID:      c.Query("marketId"),
+		ClientOrderID: c.Query("clientOrderId"),
+		Limit:         defaultHistoryLimit,
+	}
+
+	if side := c.Query("side"); side != "" {
+		if side != string(models.Buy) && side != string(models.Sell) {
+			return q, fmt.Errorf("invalid side %q", side)
+		}
+		q.Side = models.OrderSide(side)
+	}
+
+	if typ := c.Query("type"); typ != "" {
+		if typ != string(models.OrderTypeMarket) && typ != string(models.OrderTypeLimit) {
+			return q, fmt.Errorf("invalid type %q", typ)
+		}
+		q.Type = models.OrderType(typ)
+	}
+
+	if status := c.Query("status"); status != "" {
+		for _, st := range strings.Split(status, ",") {
+			st = strings.TrimSpace(st)
+			switch models.OrderStatus(st) {
+			case models.Open, models.PartiallyFilled, models.Filled, models.Canceled, models.Rejected, models.Expired:
+				q.Statuses = append(q.Statuses, models.OrderStatus(st))
+			default:
+				return q, fmt.Errorf("invalid status %q", st)
+			}
+		}
+	}
+
+	if s := c.Query("startTime"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("invalid startTime format, use RFC3339")
+		}
+		q.From = &t
+	}
+	if s := c.Query("endTime"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("invalid endTime format, use RFC3339")
+		}
+		q.To = &t
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return q, fmt.Errorf("startTime must be before endTime")
+	}
+
+	if s := c.Query("cursor"); s != "" {
+		cur, err := repo.DecodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		q.Cursor = cur
+	}
+
+	if s := c.Query("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+		if limit > maxHistoryLimit {
+			limit = maxHistoryLimit
+		}
+		q.Limit = limit
+	}
+
+	return q, nil
+}
+
+// OrderFilter converts the query into an order repository filter
+func (q HistoryQuery) OrderFilter() repo.OrderFilter {
+	return repo.OrderFilter{
+		Symbol: q.Symbol, MarketID: q.MarketID, Side: q.Side, Type: q.Type,
+		Statuses: q.Statuses, From: q.From, To: q.To, ClientOrderID: q.ClientOrderID,
+		Cursor: q.Cursor, Limit: q.Limit,
+	}
+}
+
+// TradeFilter converts the query into a trade repository filter
+func (q HistoryQuery) TradeFilter() repo.TradeFilter {
+	return repo.TradeFilter{
+		Symbol: q.Symbol, MarketID: q.MarketID, Side: q.Side, Type: q.Type,
+		Statuses: q.Statuses, From: q.From, To: q.To, ClientOrderID: q.ClientOrderID,
+		Cursor: q.Cursor, Limit: q.Limit,
+	}
+}
+
+// SetNextCursor exposes the cursor of the next page in the X-Next-Cursor header,
+// so list endpoints can keep returning a plain JSON array
+func SetNextCursor(c *gin.Context, next *repo.Cursor) {
+	if next != nil {
+		c.Header("X-Next-Cursor", next.Encode())
+	}
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// l3SnapshotMessage is sent on subscribe and resync
+type l3SnapshotMessage struct {
+	Type string `json:"type"` // "snapshot"
+	*service.L3Snapshot
+}
+
+// l3UpdateMessage carries order events after the snapshot
+type l3UpdateMessage struct {
+	Type string `json:"type"` // "update"
+	*service.L3Update
+}
+
+// L3Client represents an authenticated /ws/l3 connection
+type L3Client struct {
+	*wsConn
+	hub         *L3Hub
+	userID      string
+	marketIDs   map[string]int64 // Subscribed markets -> last sequence sent
+	marketsLock sync.RWMutex
+}
+
+type l3Request struct {
+	client *L3Client
+	msg    OrderbookMessage
+}
+
+// L3Hub pushes order-by-order book events to authenticated clients.
+//
+// Protocol: same messages as /ws/orderbook ("subscribe", "unsubscribe",
+// "resync" with market_ids). A client receives a "snapshot" with sequence,
+// then "update" messages with firstSequence/lastSequence and the events.
+// Updates with lastSequence up to the snapshot's are already included in it;
+// a firstSequence above the last applied sequence + 1 means a gap, resync.
+type L3Hub struct {
+	clients    map[*L3Client]bool
+	broadcast  chan *service.L3Update
+	requests   chan l3Request
+	register   chan *L3Client
+	unregister chan *L3Client
+	mu         sync.RWMutex
+	l3         *service.L3Service
+	guard      *wsGuard
+}
+
+// NewL3Hub creates an L3 hub
+func NewL3Hub(l3 *service.L3Service, guard *wsGuard) *L3Hub {
+	return &L3Hub{
+		clients:    make(map[*L3Client]bool),
+		broadcast:  make(chan *service.L3Update, 1024),
+		requests:   make(chan l3Request, 256),
+		register:   make(chan *L3Client),
+		unregister: make(chan *L3Client),
+		l3:         l3,
+		guard:      guard,
+	}
+}
+
+// Run starts the hub's main loop
+func (h *L3Hub) Run() {
+	for {
+		select {
+		case client := <-h.register:
+			h.mu.Lock()
+			h.clients[client] = true
+			h.mu.Unlock()
+			log.Printf("L3 client connected (user %s). Total clients: %d", client.userID, len(h.clients))
+
+		case client := <-h.unregister:
+			h.mu.Lock()
+			if _, ok := h.clients[client]; ok {
+				delete(h.clients, client)
+				client.close()
+			}
+			h.mu.Unlock()
+			log.Printf("L3 client disconnected. Total clients: %d", len(h.clients))
+
+		case req := <-h.requests:
+			h.mu.RLock()
+			if h.clients[req.client] {
+				req.client.handleMessage(req.msg)
+			}
+			h.mu.RUnlock()
+
+		case update := <-h.broadcast:
+			msg := newWSMessage(l3UpdateMessage{Type: "update", L3Update: update})
+
+			h.mu.RLock()
+			for client := range h.clients {
+				if !client.wantsUpdate(update) {
+					continue
+				}
+
+				// A slow client that misses updates sees the sequence gap and resyncs
+				client.enqueue("", msg)
+			}
+			h.mu.RUnlock()
+		}
+	}
+}
+
+// StartL3Broadcaster forwards L3 updates from the bus to subscribed clients
+func (h *L3Hub) StartL3Broadcaster(events *service.EventBus) {
+	ch, unsubscribe := events.Subscribe(4096)
+	defer unsubscribe()
+
+	log.Println("L3 broadcaster started")
+
+	for ev := range ch {
+		if ev.Type == service.EventL3 && ev.L3 != nil {
+			h.broadcast <- ev.L3
+		}
+	}
+}
+
+// wantsUpdate reports whether the client subscribed to the update's market and
+// does not have it in its snapshot yet
+func (c *L3Client) wantsUpdate(update *service.L3Update) bool {
+	c.marketsLock.Lock()
+	defer c.marketsLock.Unlock()
+
+	last, ok := c.marketIDs[update.MarketID]
+	if !ok || update.LastSequence <= last {
+		return false
+	}
+	c.marketIDs[update.MarketID] = update.LastSequence
+	return true
+}
+
+// handleMessage processes subscribe/unsubscribe/resync requests; it runs on the hub loop
+func (c *L3Client) handleMessage(msg OrderbookMessage) {
+	switch msg.Type {
+	case "subscribe", "resync":
+		for _, marketID := range msg.MarketIDs {
+			c.marketsLock.RLock()
+			_, resubscribe := c.marketIDs[marketID]
+			full := c.hub.guard.subscriptionsFull(len(c.marketIDs))
+			c.marketsLock.RUnlock()
+			if !resubscribe && full {
+				c.sendError(marketID, errTooManySubscriptions)
+				continue
+			}
+			c.sendSnapshot(marketID)
+		}
+
+	case "unsubscribe":
+		c.marketsLock.Lock()
+		for _, marketID := range msg.MarketIDs {
+			delete(c.marketIDs, marketID)
+		}
+		c.marketsLock.Unlock()
+
+	default:
+		log.Printf("Unknown message type: %s", msg.Type)
+	}
+}
+
+func (c *L3Client) sendSnapshot(marketID string) {
+	snapshot, ok := c.hub.l3.Snapshot(marketID)
+	if !ok {
+		log.Printf("Rejected L3 subscription to unknown market: %s", marketID)
+		c.sendError(marketID, service.ErrUnknownMarket)
+		return
+	}
+
+	c.marketsLock.Lock()
+	c.marketIDs[marketID] = snapshot.Sequence
+	c.marketsLock.Unlock()
+
+	c.enqueue("", newWSMessage(l3SnapshotMessage{Type: "snapshot", L3Snapshot: snapshot}))
+}
+
+func (c *L3Client) sendError(marketID string, err error) {
+	c.enqueue("", newWSMessage(gin.H{"type": "error", "marketId": marketID, "error": err.Error()}))
+}
+
+// onMessage hands subscription messages to the hub loop
+func (c *L3Client) onMessage(messageType int, message []byte) {
+	var msg OrderbookMessage
+	if err := c.encoding.unmarshal(messageType, message, &msg); err != nil {
+		log.Printf("Error parsing message: %v", err)
+		return
+	}
+
+	c.hub.requests <- l3Request{client: c, msg: msg}
+}
+
+// HandleWebSocket handles new /ws/l3 connections; the route requires authentication
+func (h *L3Hub) HandleWebSocket(c *gin.Context) {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, ok := h.guard.accept(c, user.ID.String())
+	if !ok {
+		return
+	}
+
+	client := &L3Client{
+		wsConn:    conn,
+		hub:       h,
+		userID:    user.ID.String(),
+		marketIDs: make(map[string]int64),
+	}
+
+	client.hub.register <- client
+
+	go client.writePump()
+	go client.readPump(client.onMessage, func() { h.unregister <- client })
+}
+
+// GetSnapshot returns the L3 book of a market (GET /market/l3?marketId=)
+func (h *L3Hub) GetSnapshot(c *gin.Context) {
+	marketID := c.Query("marketId")
+	if marketID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "marketId is required"})
+		return
+	}
+
+	snapshot, ok := h.l3.Snapshot(marketID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown market"})
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
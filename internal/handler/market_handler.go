@@ -1,20 +1,28 @@
 package handler
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
 	"github.com/gin-gonic/gin"
 )
 
 type MarketHandler struct {
 	marketRepo *repo.MarketRepo
+	tradeRepo  *repo.TradeRepo
+	tickers    *service.TickerService
+	books      *service.BookService
+	cache      interface{} // Cache service (optional)
 }
 
-func NewMarketHandler(marketRepo *repo.MarketRepo) *MarketHandler {
-	return &MarketHandler{marketRepo: marketRepo}
+func NewMarketHandler(marketRepo *repo.MarketRepo, tradeRepo *repo.TradeRepo, tickers *service.TickerService, books *service.BookService, cache interface{}) *MarketHandler {
+	return &MarketHandler{marketRepo: marketRepo, tradeRepo: tradeRepo, tickers: tickers, books: books, cache: cache}
 }
 
 // GetMarkets returns all active markets with their IDs and symbols
@@ -60,6 +68,13 @@ func (h *MarketHandler) GetCandles(c *gin.Context) {
 	}
 
 	candles, err := h.marketRepo.GetCandles(c.Request.Context(), symbol, interval, limit, endTime)
+	if err == repo.ErrUnsupportedInterval {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "unsupported interval",
+			"intervals": repo.CandleIntervals(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candles"})
 		return
@@ -67,3 +82,163 @@ func (h *MarketHandler) GetCandles(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"candles": candles})
 }
+
+// resolveSymbol looks up the active market for the required symbol query param.
+// It writes the error response and returns nil if the market cannot be resolved.
+func (h *MarketHandler) resolveSymbol(c *gin.Context) *models.Market {
+	symbol := c.Query("symbol")
+	if symbol == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "symbol is required"})
+		return nil
+	}
+
+	market, err := h.marketRepo.GetBySymbol(c.Request.Context(), symbol)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown symbol"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch market"})
+		return nil
+	}
+	return market
+}
+
+// GetRecentTrades returns the latest public trades of a market, newest first
+func (h *MarketHandler) GetRecentTrades(c *gin.Context) {
+	market := h.resolveSymbol(c)
+	if market == nil {
+		return
+	}
+
+	limit := 100
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > repo.MaxRecentTrades {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", repo.MaxRecentTrades)})
+			return
+		}
+		limit = n
+	}
+
+	trades, err := h.tradeRepo.GetRecentTrades(c.Request.Context(), market.ID, limit, h.cache)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trades"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"symbol": market.Symbol, "trades": trades})
+}
+
+// GetHistoricalTrades returns public trades in ascending ID order, starting
+// from fromId or within [startTime, endTime). Page with fromId = last id + 1.
+func (h *MarketHandler) GetHistoricalTrades(c *gin.Context) {
+	market := h.resolveSymbol(c)
+	if market == nil {
+		return
+	}
+
+	hq := repo.HistoricalTradesQuery{Limit: 100}
+
+	if s := c.Query("fromId"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fromId"})
+			return
+		}
+		hq.FromID = &id
+	}
+	if s := c.Query("startTime"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid startTime format, use RFC3339"})
+			return
+		}
+		hq.StartTime = &t
+	}
+	if s := c.Query("endTime"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid endTime format, use RFC3339"})
+			return
+		}
+		hq.EndTime = &t
+	}
+	if hq.FromID == nil && hq.StartTime == nil && hq.EndTime == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fromId or startTime/endTime is required"})
+		return
+	}
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > repo.MaxRecentTrades {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", repo.MaxRecentTrades)})
+			return
+		}
+		hq.Limit = n
+	}
+
+	trades, err := h.tradeRepo.GetHistoricalTrades(c.Request.Context(), market.ID, hq, h.cache)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trades"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"symbol": market.Symbol, "trades": trades})
+}
+
+// GetTicker returns the 24h ticker of one market, or of all markets without a symbol
+func (h *MarketHandler) GetTicker(c *gin.Context) {
+	symbol := c.Query("symbol")
+	if symbol == "" {
+		c.JSON(http.StatusOK, h.tickers.All())
+		return
+	}
+
+	ticker, ok := h.tickers.Get(symbol)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown symbol"})
+		return
+	}
+	c.JSON(http.StatusOK, ticker)
+}
+
+// GetDepth returns the order book of a market (GET /market/depth?symbol=&limit=&group=).
+// lastUpdateId lets clients continue from this snapshot with /ws/orderbook diffs.
+func (h *MarketHandler) GetDepth(c *gin.Context) {
+	market := h.resolveSymbol(c)
+	if market == nil {
+		return
+	}
+
+	limit := service.DefaultBookDepth
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < service.MinBookDepth || n > service.MaxBookDepth {
+			c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrInvalidDepth.Error()})
+			return
+		}
+		limit = n
+	}
+
+	var group float64
+	if s := c.Query("group"); s != "" {
+		g, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group"})
+			return
+		}
+		group = g
+	}
+
+	depth, err := h.books.View(market.ID, limit, group)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, depth)
+	case service.ErrInvalidDepth, service.ErrInvalidGroup:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case service.ErrUnknownMarket:
+		c.JSON(http.StatusNotFound, gin.H{"error": "order book not available"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch depth"})
+	}
+}
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type OrderHandler struct{ svc *service.OrderService }
@@ -23,15 +27,42 @@ func (h *OrderHandler) Place(c *gin.Context) {
 	}
 
 	order, trades, err := h.svc.PlaceOrder(c.Request.Context(), user.ID.String(), req)
+	if errors.Is(err, service.ErrDuplicateClientOrder) {
+		// Retry of an order already placed: answer with that order so the
+		// client can tell it from bad input
+		existing, fills, getErr := h.svc.GetOrderByClientID(c.Request.Context(), user.ID.String(), req.ClientOrderID)
+		if getErr != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  err.Error(),
+			"order":  existing,
+			"trades": fills,
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Return fills in the same schema as GET /orders/:id/trades. The order is
+	// committed by now: failing the request would make the client place it
+	// again, so a failed read leaves the fills to GET /orders/:id/trades.
+	fills := []repo.TradeWithSymbol{}
+	if len(trades) > 0 {
+		if read, err := h.svc.OrderFills(c.Request.Context(), order.ID); err != nil {
+			log.Printf("Error reading fills of placed order %s: %v", order.ID, err)
+		} else {
+			fills = read
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"user":   user,   // nếu muốn trả user về
 		"order":  order,
-		"trades": trades,
+		"trades": fills,
 	})
 }
 
@@ -42,19 +73,84 @@ func (h *OrderHandler) List(c *gin.Context) {
 		return
 	}
 
-	// Get optional status filter from query param
-	status := c.Query("status")
+	// Optional filters and pagination from query params
+	query, err := ParseHistoryQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	orders, err := h.svc.ListOrders(c.Request.Context(), user.ID.String(), status)
+	orders, next, err := h.svc.ListOrders(c.Request.Context(), user.ID.String(), query.OrderFilter())
+	if errors.Is(err, repo.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Error listing orders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
 
-	// Return array directly for frontend consistency
+	// Return array directly for frontend consistency, next page cursor goes in a header
+	SetNextCursor(c, next)
 	c.JSON(http.StatusOK, orders)
 }
 
+func (h *OrderHandler) Get(c *gin.Context) {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": service.ErrOrderNotFound.Error()})
+		return
+	}
+
+	order, fills, err := h.svc.GetOrder(c.Request.Context(), user.ID.String(), id)
+	if errors.Is(err, service.ErrOrderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order": order,
+		"fills": fills,
+	})
+}
+
+func (h *OrderHandler) Trades(c *gin.Context) {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": service.ErrOrderNotFound.Error()})
+		return
+	}
+
+	fills, err := h.svc.GetOrderTrades(c.Request.Context(), user.ID.String(), id)
+	if errors.Is(err, service.ErrOrderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, fills)
+}
+
 func (h *OrderHandler) Cancel(c *gin.Context) {
 	user, err := GetCurrentUser(c)
 	if err != nil {
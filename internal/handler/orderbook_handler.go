@@ -1,53 +1,85 @@
 package handler
 
 import (
-	"context"
-	"encoding/json"
 	"log"
 	// "net/http"
 	"sync"
-	"time"
 
-	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 )
 
-// OrderbookMessage for client subscribe/unsubscribe
+// OrderbookMessage for client subscribe/unsubscribe/resync
 type OrderbookMessage struct {
-	Type      string   `json:"type"`       // "subscribe", "unsubscribe"
-	MarketIDs []string `json:"market_ids"` // List of market UUIDs
+	Type      string   `json:"type"`            // "subscribe", "unsubscribe", "resync"
+	MarketIDs []string `json:"market_ids"`      // List of market UUIDs
+	Depth     int      `json:"depth,omitempty"` // Levels per side, 5-5000 (default: full book)
+	Group     float64  `json:"group,omitempty"` // Price bucket size, multiple of the tick size
+}
+
+// orderbookSnapshotMessage is sent on subscribe and resync
+type orderbookSnapshotMessage struct {
+	Type string `json:"type"` // "snapshot"
+	*service.BookSnapshot
+}
+
+// orderbookDiffMessage is sent for every book change after the snapshot
+type orderbookDiffMessage struct {
+	Type string `json:"type"` // "diff"
+	*service.BookDiff
 }
 
 // OrderbookClient represents a WebSocket client connection
 type OrderbookClient struct {
-	hub        *OrderbookHub
-	conn       *websocket.Conn
-	send       chan []byte
-	marketIDs  map[string]bool // Subscribed markets
+	*wsConn
+	hub         *OrderbookHub
+	marketIDs   map[string]*orderbookSub // Subscribed markets
 	marketsLock sync.RWMutex
 }
 
-// OrderbookHub manages all orderbook WebSocket connections
+// orderbookSub is one market subscription of a client
+type orderbookSub struct {
+	last int64     // Last update id sent (raw subscriptions)
+	view *bookView // Grouped or depth-limited view, nil for the raw book
+}
+
+// orderbookRequest is a subscription change handled by the hub loop, so that
+// snapshots and diffs reach a client in update id order
+type orderbookRequest struct {
+	client *OrderbookClient
+	msg    OrderbookMessage
+}
+
+// OrderbookHub manages all orderbook WebSocket connections.
+//
+// Protocol: after subscribing, a client receives a "snapshot" with lastUpdateId,
+// then "diff" events with firstUpdateId/lastUpdateId. Diffs with lastUpdateId
+// up to the snapshot's are already included in it. If a diff's firstUpdateId is
+// above the last applied id + 1, updates were missed and the client sends
+// "resync" to get a new snapshot.
 type OrderbookHub struct {
 	clients    map[*OrderbookClient]bool
-	broadcast  chan map[string]*repo.OrderBook // market_id -> orderbook
+	broadcast  chan *service.BookDiff
+	requests   chan orderbookRequest
 	register   chan *OrderbookClient
 	unregister chan *OrderbookClient
 	mu         sync.RWMutex
-	orderRepo  *repo.OrderRepo
-	cache      interface{} // Cache service (optional)
+	books      *service.BookService
+	views      map[bookViewKey]*bookView // Only used by the hub loop
+	guard      *wsGuard
 }
 
 // NewOrderbookHub creates a new orderbook hub
-func NewOrderbookHub(orderRepo *repo.OrderRepo, cache interface{}) *OrderbookHub {
+func NewOrderbookHub(books *service.BookService, guard *wsGuard) *OrderbookHub {
 	return &OrderbookHub{
 		clients:    make(map[*OrderbookClient]bool),
-		broadcast:  make(chan map[string]*repo.OrderBook, 256),
+		broadcast:  make(chan *service.BookDiff, 1024),
+		requests:   make(chan orderbookRequest, 256),
 		register:   make(chan *OrderbookClient),
 		unregister: make(chan *OrderbookClient),
-		orderRepo:  orderRepo,
-		cache:      cache,
+		books:      books,
+		views:      make(map[bookViewKey]*bookView),
+		guard:      guard,
 	}
 }
 
@@ -64,240 +96,175 @@ func (h *OrderbookHub) Run() {
 		case client := <-h.unregister:
 			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
+				h.leaveViews(client)
 				delete(h.clients, client)
-				close(client.send)
+				client.close()
 			}
 			h.mu.Unlock()
 			log.Printf("Orderbook client disconnected. Total clients: %d", len(h.clients))
 
-		case orderbooks := <-h.broadcast:
+		case req := <-h.requests:
 			h.mu.RLock()
-			for client := range h.clients {
-				// Filter orderbooks based on client's subscriptions
-				filteredBooks := client.filterOrderbooks(orderbooks)
-
-				if len(filteredBooks) > 0 {
-					data, err := json.Marshal(filteredBooks)
-					if err != nil {
-						log.Printf("Error marshaling orderbooks: %v", err)
-						continue
-					}
-
-					select {
-					case client.send <- data:
-					default:
-						close(client.send)
-						delete(h.clients, client)
-					}
-				}
+			if h.clients[req.client] {
+				req.client.handleMessage(req.msg)
 			}
 			h.mu.RUnlock()
-		}
-	}
-}
 
-// filterOrderbooks returns only orderbooks for subscribed markets
-func (c *OrderbookClient) filterOrderbooks(orderbooks map[string]*repo.OrderBook) map[string]*repo.OrderBook {
-	c.marketsLock.RLock()
-	defer c.marketsLock.RUnlock()
+		case diff := <-h.broadcast:
+			msg := newWSMessage(orderbookDiffMessage{Type: "diff", BookDiff: diff})
 
-	// If no markets subscribed, return empty (don't send anything)
-	if len(c.marketIDs) == 0 {
-		return nil
-	}
+			h.mu.RLock()
+			for client := range h.clients {
+				if !client.wantsDiff(diff) {
+					continue
+				}
 
-	filtered := make(map[string]*repo.OrderBook)
-	for marketID, orderbook := range orderbooks {
-		if c.marketIDs[marketID] {
-			filtered[marketID] = orderbook
+				// A slow client that misses diffs sees the update id gap and resyncs
+				client.enqueue("", msg)
+			}
+			h.updateViews(diff.MarketID, diff.LastUpdateID)
+			h.mu.RUnlock()
 		}
 	}
-	return filtered
 }
 
-// writePump sends messages to the WebSocket connection
-func (c *OrderbookClient) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
-	defer func() {
-		ticker.Stop()
-		c.conn.Close()
-	}()
-
-	for {
-		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			if err := w.Close(); err != nil {
-				return
-			}
+// wantsDiff reports whether the client subscribed to the raw book of the diff's
+// market and does not have it in its snapshot yet
+func (c *OrderbookClient) wantsDiff(diff *service.BookDiff) bool {
+	c.marketsLock.Lock()
+	defer c.marketsLock.Unlock()
 
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
-		}
+	sub, ok := c.marketIDs[diff.MarketID]
+	if !ok || sub.view != nil || diff.LastUpdateID <= sub.last {
+		return false
 	}
+	sub.last = diff.LastUpdateID
+	return true
 }
 
-// readPump reads messages from the WebSocket connection
-func (c *OrderbookClient) readPump() {
-	defer func() {
-		c.hub.unregister <- c
-		c.conn.Close()
-	}()
-
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		return nil
-	})
-
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
-
-		// Handle subscribe/unsubscribe messages
-		var msg OrderbookMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Error parsing message: %v", err)
-			continue
-		}
-
-		c.handleMessage(msg)
+// onMessage hands subscribe/unsubscribe/resync messages to the hub loop
+func (c *OrderbookClient) onMessage(messageType int, message []byte) {
+	var msg OrderbookMessage
+	if err := c.encoding.unmarshal(messageType, message, &msg); err != nil {
+		log.Printf("Error parsing message: %v", err)
+		return
 	}
+
+	c.hub.requests <- orderbookRequest{client: c, msg: msg}
 }
 
-// handleMessage processes subscribe/unsubscribe requests
+// handleMessage processes subscribe/unsubscribe/resync requests; it runs on the hub loop
 func (c *OrderbookClient) handleMessage(msg OrderbookMessage) {
-	c.marketsLock.Lock()
-	
 	switch msg.Type {
-	case "subscribe":
+	case "subscribe", "resync":
 		for _, marketID := range msg.MarketIDs {
-			c.marketIDs[marketID] = true
+			c.marketsLock.RLock()
+			_, resubscribe := c.marketIDs[marketID]
+			full := c.hub.guard.subscriptionsFull(len(c.marketIDs))
+			c.marketsLock.RUnlock()
+			if !resubscribe && full {
+				c.sendError(marketID, errTooManySubscriptions)
+				continue
+			}
+			c.subscribe(marketID, msg.Depth, msg.Group)
 		}
 		log.Printf("Client subscribed to markets: %v (total: %d)", msg.MarketIDs, len(c.marketIDs))
-		
-		// Immediately fetch and send orderbook for subscribed markets
-		c.marketsLock.Unlock() // Unlock before async operations
-		go c.sendImmediateOrderbook(msg.MarketIDs)
-		return
 
 	case "unsubscribe":
 		for _, marketID := range msg.MarketIDs {
-			delete(c.marketIDs, marketID)
+			c.unsubscribe(marketID)
 		}
 		log.Printf("Client unsubscribed from markets: %v (remaining: %d)", msg.MarketIDs, len(c.marketIDs))
 
 	default:
 		log.Printf("Unknown message type: %s", msg.Type)
 	}
-	
+}
+
+// subscribe (re)sends the snapshot of a market and starts its diffs after the snapshot
+func (c *OrderbookClient) subscribe(marketID string, depth int, group float64) {
+	c.unsubscribe(marketID)
+
+	if depth == 0 && group == 0 {
+		c.subscribeRaw(marketID)
+		return
+	}
+
+	view, snapshot, err := c.hub.joinView(c, bookViewKey{marketID: marketID, depth: depth, group: group})
+	if err != nil {
+		log.Printf("Rejected orderbook subscription to %s: %v", marketID, err)
+		c.sendError(marketID, err)
+		return
+	}
+
+	c.marketsLock.Lock()
+	c.marketIDs[marketID] = &orderbookSub{view: view}
 	c.marketsLock.Unlock()
+	c.sendSnapshot(snapshot)
 }
 
-// sendImmediateOrderbook fetches and sends orderbook immediately for specified markets
-func (c *OrderbookClient) sendImmediateOrderbook(marketIDs []string) {
-	ctx := context.Background()
-	orderbooks := make(map[string]*repo.OrderBook)
-	
-	for _, marketID := range marketIDs {
-		orderbook, err := c.hub.orderRepo.GetOrderBook(ctx, marketID, 20, c.hub.cache)
-		if err != nil {
-			log.Printf("Error fetching immediate orderbook for market %s: %v", marketID, err)
-			continue
-		}
-		orderbooks[marketID] = orderbook
-		log.Printf("Fetched orderbook for market %s: %d bids, %d asks", marketID, len(orderbook.Bids), len(orderbook.Asks))
+func (c *OrderbookClient) subscribeRaw(marketID string) {
+	snapshot, ok := c.hub.books.Snapshot(marketID, 0)
+	if !ok {
+		log.Printf("Rejected orderbook subscription to unknown market: %s", marketID)
+		c.sendError(marketID, service.ErrUnknownMarket)
+		return
 	}
-	
-	if len(orderbooks) > 0 {
-		data, err := json.Marshal(orderbooks)
-		if err != nil {
-			log.Printf("Error marshaling immediate orderbook: %v", err)
-			return
-		}
-		
-		select {
-		case c.send <- data:
-			log.Printf("Sent immediate orderbook to client for %d markets", len(orderbooks))
-		default:
-			log.Printf("Client send channel full, skipping immediate orderbook")
-		}
-	} else {
-		log.Printf("No orderbook data available for markets: %v", marketIDs)
+
+	c.marketsLock.Lock()
+	c.marketIDs[marketID] = &orderbookSub{last: snapshot.LastUpdateID}
+	c.marketsLock.Unlock()
+	c.sendSnapshot(snapshot)
+}
+
+func (c *OrderbookClient) unsubscribe(marketID string) {
+	c.marketsLock.Lock()
+	sub, ok := c.marketIDs[marketID]
+	delete(c.marketIDs, marketID)
+	c.marketsLock.Unlock()
+
+	if ok && sub.view != nil {
+		c.hub.leaveView(c, sub.view)
 	}
 }
 
+func (c *OrderbookClient) sendSnapshot(snapshot *service.BookSnapshot) {
+	c.enqueue("", newWSMessage(orderbookSnapshotMessage{Type: "snapshot", BookSnapshot: snapshot}))
+}
+
+func (c *OrderbookClient) sendError(marketID string, err error) {
+	c.enqueue("", newWSMessage(gin.H{"type": "error", "marketId": marketID, "error": err.Error()}))
+}
+
 // HandleWebSocket handles new WebSocket connections
 func (h *OrderbookHub) HandleWebSocket(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+	conn, ok := h.guard.accept(c, "")
+	if !ok {
 		return
 	}
 
 	client := &OrderbookClient{
+		wsConn:    conn,
 		hub:       h,
-		conn:      conn,
-		send:      make(chan []byte, 256),
-		marketIDs: make(map[string]bool),
+		marketIDs: make(map[string]*orderbookSub),
 	}
 
 	client.hub.register <- client
 
 	go client.writePump()
-	go client.readPump()
+	go client.readPump(client.onMessage, func() { h.unregister <- client })
 }
 
-// StartOrderbookBroadcaster periodically fetches and broadcasts orderbooks
-func (h *OrderbookHub) StartOrderbookBroadcaster(marketRepo *repo.MarketRepo) {
-	ticker := time.NewTicker(1 * time.Second) // Update every second
-	defer ticker.Stop()
+// StartOrderbookBroadcaster forwards book diffs from the bus to subscribed clients
+func (h *OrderbookHub) StartOrderbookBroadcaster(events *service.EventBus) {
+	ch, unsubscribe := events.Subscribe(4096)
+	defer unsubscribe()
 
 	log.Println("Orderbook broadcaster started")
 
-	for range ticker.C {
-		ctx := context.Background()
-
-		// Get all active markets
-		markets, err := marketRepo.GetAllActiveMarkets(ctx)
-		if err != nil {
-			log.Printf("Error fetching markets: %v", err)
-			continue
-		}
-
-		// Fetch orderbook for each market
-		orderbooks := make(map[string]*repo.OrderBook)
-		for _, market := range markets {
-			orderbook, err := h.orderRepo.GetOrderBook(ctx, market.ID, 20, h.cache) // Top 20 levels
-			if err != nil {
-				log.Printf("Error fetching orderbook for market %s: %v", market.Symbol, err)
-				continue
-			}
-			orderbooks[market.ID] = orderbook
-		}
-
-		// Broadcast to clients
-		if len(orderbooks) > 0 {
-			h.broadcast <- orderbooks
+	for ev := range ch {
+		if ev.Type == service.EventBookDiff && ev.BookDiff != nil {
+			h.broadcast <- ev.BookDiff
 		}
 	}
 }
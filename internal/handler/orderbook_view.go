@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+)
+
+// bookViewKey identifies a grouped or depth-limited view of a market's book
+type bookViewKey struct {
+	marketID string
+	depth    int
+	group    float64
+}
+
+// bookView is shared by all clients subscribed with the same options. Its
+// diffs are computed against the previous view state, and its update ids are
+// those of the raw book, so clients use the same gap detection rules.
+type bookView struct {
+	key     bookViewKey
+	symbol  string
+	bids    map[float64]float64
+	asks    map[float64]float64
+	lastID  int64
+	clients map[*OrderbookClient]bool
+}
+
+// joinView adds a client to a view, creating it if needed, and returns the
+// snapshot the client starts from. Must be called from the hub loop.
+func (h *OrderbookHub) joinView(c *OrderbookClient, key bookViewKey) (*bookView, *service.BookSnapshot, error) {
+	view, ok := h.views[key]
+	if ok {
+		// Bring existing subscribers up to date so the new snapshot and
+		// the next diff line up
+		h.refreshView(view)
+	} else {
+		snapshot, err := h.books.View(key.marketID, key.depth, key.group)
+		if err != nil {
+			return nil, nil, err
+		}
+		view = &bookView{
+			key:     key,
+			symbol:  snapshot.Symbol,
+			bids:    levelMap(snapshot.Bids),
+			asks:    levelMap(snapshot.Asks),
+			lastID:  snapshot.LastUpdateID,
+			clients: make(map[*OrderbookClient]bool),
+		}
+		h.views[key] = view
+	}
+	view.clients[c] = true
+
+	snapshot := &service.BookSnapshot{
+		MarketID:     key.marketID,
+		Symbol:       view.symbol,
+		LastUpdateID: view.lastID,
+		Bids:         changedLevels(nil, view.bids, true),
+		Asks:         changedLevels(nil, view.asks, false),
+		Timestamp:    time.Now(),
+	}
+	return view, snapshot, nil
+}
+
+// leaveView removes a client from a view and drops the view when unused
+func (h *OrderbookHub) leaveView(c *OrderbookClient, view *bookView) {
+	delete(view.clients, c)
+	if len(view.clients) == 0 {
+		delete(h.views, view.key)
+	}
+}
+
+// leaveViews removes a disconnecting client from all its views
+func (h *OrderbookHub) leaveViews(c *OrderbookClient) {
+	c.marketsLock.Lock()
+	defer c.marketsLock.Unlock()
+
+	for _, sub := range c.marketIDs {
+		if sub.view != nil {
+			h.leaveView(c, sub.view)
+		}
+	}
+}
+
+// updateViews refreshes the views of a market after a raw diff up to lastUpdateID
+func (h *OrderbookHub) updateViews(marketID string, lastUpdateID int64) {
+	for key, view := range h.views {
+		if key.marketID == marketID && lastUpdateID > view.lastID {
+			h.refreshView(view)
+		}
+	}
+}
+
+// refreshView recomputes a view from the current book and sends what changed
+func (h *OrderbookHub) refreshView(view *bookView) {
+	snapshot, err := h.books.View(view.key.marketID, view.key.depth, view.key.group)
+	if err != nil {
+		log.Printf("Error refreshing orderbook view of %s: %v", view.key.marketID, err)
+		return
+	}
+
+	bids := levelMap(snapshot.Bids)
+	asks := levelMap(snapshot.Asks)
+	diff := &service.BookDiff{
+		MarketID:      snapshot.MarketID,
+		Symbol:        snapshot.Symbol,
+		FirstUpdateID: view.lastID + 1,
+		LastUpdateID:  snapshot.LastUpdateID,
+		Bids:          changedLevels(view.bids, bids, true),
+		Asks:          changedLevels(view.asks, asks, false),
+		Timestamp:     snapshot.Timestamp,
+	}
+	if len(diff.Bids) == 0 && len(diff.Asks) == 0 {
+		return // Nothing visible changed, the next diff covers these ids
+	}
+	view.bids, view.asks, view.lastID = bids, asks, snapshot.LastUpdateID
+
+	msg := newWSMessage(orderbookDiffMessage{Type: "diff", BookDiff: diff})
+	for client := range view.clients {
+		client.enqueue("", msg)
+	}
+}
+
+func levelMap(levels []repo.OrderBookEntry) map[float64]float64 {
+	m := make(map[float64]float64, len(levels))
+	for _, l := range levels {
+		m[l.Price] = l.Amount
+	}
+	return m
+}
+
+// changedLevels lists the levels of next that differ from prev, and the levels
+// of prev missing from next with amount 0. With prev nil it lists all of next.
+func changedLevels(prev, next map[float64]float64, desc bool) []repo.OrderBookEntry {
+	changed := []repo.OrderBookEntry{}
+	for price, amount := range next {
+		if old, ok := prev[price]; !ok || old != amount {
+			changed = append(changed, repo.OrderBookEntry{Price: price, Amount: amount})
+		}
+	}
+	for price := range prev {
+		if _, ok := next[price]; !ok {
+			changed = append(changed, repo.OrderBookEntry{Price: price, Amount: 0})
+		}
+	}
+	return sortLevels(changed, desc)
+}
+
+func sortLevels(levels []repo.OrderBookEntry, desc bool) []repo.OrderBookEntry {
+	sort.Slice(levels, func(i, j int) bool {
+		if desc {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	return levels
+}
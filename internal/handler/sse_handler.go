@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseHeartbeat keeps idle streams open through proxies
+const sseHeartbeat = 15 * time.Second
+
+// sseConn is the sink of an SSE stream, drained by the request's goroutine
+type sseConn struct {
+	queue *sendQueue
+}
+
+func (s *sseConn) enqueue(key string, msg *wsMessage) bool {
+	event := msg.sseEvent()
+	if event == nil {
+		return true
+	}
+	return s.queue.push(queuedMessage{key: key, sse: event})
+}
+
+func (s *sseConn) close() {
+	s.queue.close()
+}
+
+// HandleSSE streams gateway topics as Server-Sent Events, for clients that
+// cannot open a WebSocket (GET /sse?topics=depth.BTCUSDT,ticker.*).
+//
+// Every event's data is a /ws message (ack, error, snapshot, update) as JSON.
+// Updates carry an event id; a client reconnecting with Last-Event-ID (or
+// ?lastEventId=) gets the updates it missed instead of snapshots while they
+// are still buffered.
+func (g *Gateway) HandleSSE(c *gin.Context) {
+	var topics []string
+	for _, t := range strings.Split(c.Query("topics"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+	if len(topics) == 0 {
+		c.JSON(400, gin.H{"error": "topics is required"})
+		return
+	}
+
+	release, ok := g.guard.admit(c, "")
+	if !ok {
+		return
+	}
+	defer release()
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+
+	conn := &sseConn{queue: newSendQueue(g.guard.cfg.SendQueue, g.guard.cfg.SlowConsumer)}
+	client := &gatewayClient{
+		gatewaySink: conn,
+		gateway:     g,
+		topics:      make(map[string]*gatewaySub),
+	}
+
+	g.register <- client
+	defer func() { g.unregister <- client }()
+	g.requests <- gatewayRequest{
+		client:      client,
+		req:         GatewayRequest{Method: "subscribe", Topics: topics},
+		lastEventID: lastEventID,
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	c.Status(200)
+	c.Writer.WriteString("retry: 3000\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+
+		case <-conn.queue.ready:
+			items, closed, reason := conn.queue.take()
+			for _, item := range items {
+				if _, err := c.Writer.Write(item.sse); err != nil {
+					return
+				}
+			}
+			if closed {
+				// The client reconnects with Last-Event-ID and resumes
+				if reason != "" {
+					log.Printf("Closing SSE stream: %s", reason)
+					c.Writer.WriteString(": " + reason + "\n\n")
+				}
+				c.Writer.Flush()
+				return
+			}
+			c.Writer.Flush()
+
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// allTickers is the wildcard symbol subscribing a client to every market
+const allTickers = "*"
+
+// TickerClient represents a /ws/ticker connection
+type TickerClient struct {
+	*wsConn
+	hub         *TickerHub
+	symbols     map[string]bool // Subscribed symbols, "*" for all markets
+	symbolsLock sync.RWMutex
+}
+
+// TickerHub pushes 24h ticker updates to WebSocket subscribers
+type TickerHub struct {
+	clients    map[*TickerClient]bool
+	broadcast  chan []service.Ticker
+	register   chan *TickerClient
+	unregister chan *TickerClient
+	mu         sync.RWMutex
+	tickers    *service.TickerService
+	guard      *wsGuard
+}
+
+// NewTickerHub creates a ticker hub
+func NewTickerHub(tickers *service.TickerService, guard *wsGuard) *TickerHub {
+	return &TickerHub{
+		clients:    make(map[*TickerClient]bool),
+		broadcast:  make(chan []service.Ticker, 256),
+		register:   make(chan *TickerClient),
+		unregister: make(chan *TickerClient),
+		tickers:    tickers,
+		guard:      guard,
+	}
+}
+
+// Run starts the hub's main loop
+func (h *TickerHub) Run() {
+	for {
+		select {
+		case client := <-h.register:
+			h.mu.Lock()
+			h.clients[client] = true
+			h.mu.Unlock()
+			log.Printf("Ticker client connected. Total clients: %d", len(h.clients))
+
+		case client := <-h.unregister:
+			h.mu.Lock()
+			if _, ok := h.clients[client]; ok {
+				delete(h.clients, client)
+				client.close()
+			}
+			h.mu.Unlock()
+			log.Printf("Ticker client disconnected. Total clients: %d", len(h.clients))
+
+		case tickers := <-h.broadcast:
+			// Clients getting the same tickers share one message
+			messages := make(map[string]*wsMessage)
+			h.mu.RLock()
+			for client := range h.clients {
+				filtered, key := client.filterTickers(tickers)
+				if len(filtered) == 0 {
+					continue
+				}
+
+				msg, ok := messages[key]
+				if !ok {
+					msg = newWSMessage(filtered)
+					messages[key] = msg
+				}
+				// A slow client is handled by the slow-consumer policy; every
+				// update carries full stats of its symbols
+				client.enqueue("", msg)
+			}
+			h.mu.RUnlock()
+		}
+	}
+}
+
+// StartTickerBroadcaster forwards ticker events from the bus to clients
+func (h *TickerHub) StartTickerBroadcaster(events *service.EventBus) {
+	ch, unsubscribe := events.Subscribe(256)
+	defer unsubscribe()
+
+	log.Println("Ticker broadcaster started")
+
+	for ev := range ch {
+		if ev.Type == service.EventTicker && len(ev.Tickers) > 0 {
+			h.broadcast <- ev.Tickers
+		}
+	}
+}
+
+// filterTickers returns only tickers for subscribed symbols, and a key that is
+// the same for every client getting the same tickers
+func (c *TickerClient) filterTickers(tickers []service.Ticker) ([]service.Ticker, string) {
+	c.symbolsLock.RLock()
+	defer c.symbolsLock.RUnlock()
+
+	// If no symbols subscribed, return empty (don't send anything)
+	if len(c.symbols) == 0 {
+		return nil, ""
+	}
+	if c.symbols[allTickers] {
+		return tickers, allTickers
+	}
+
+	var filtered []service.Ticker
+	var key strings.Builder
+	for _, t := range tickers {
+		if c.symbols[t.Symbol] {
+			filtered = append(filtered, t)
+			key.WriteString(t.Symbol)
+			key.WriteByte(',')
+		}
+	}
+	return filtered, key.String()
+}
+
+// onMessage handles subscribe/unsubscribe messages, same shape as
+// /ws/market-prices: {"type": "subscribe", "symbols": ["BTCUSDT"]}
+func (c *TickerClient) onMessage(messageType int, message []byte) {
+	var msg WSMessage
+	if err := c.encoding.unmarshal(messageType, message, &msg); err != nil {
+		log.Printf("Error parsing message: %v", err)
+		return
+	}
+
+	c.handleMessage(msg)
+}
+
+// handleMessage processes subscribe/unsubscribe requests
+func (c *TickerClient) handleMessage(msg WSMessage) {
+	switch msg.Type {
+	case "subscribe":
+		c.symbolsLock.Lock()
+		for _, symbol := range msg.Symbols {
+			if !c.symbols[symbol] && c.hub.guard.subscriptionsFull(len(c.symbols)) {
+				log.Printf("Rejected ticker subscription to %s: subscription limit reached", symbol)
+				continue
+			}
+			if symbol == allTickers {
+				c.symbols[symbol] = true
+				continue
+			}
+			if _, ok := c.hub.tickers.Get(symbol); !ok {
+				log.Printf("Rejected ticker subscription to unknown symbol: %s", symbol)
+				continue
+			}
+			c.symbols[symbol] = true
+		}
+		c.symbolsLock.Unlock()
+
+		// Send current stats right away instead of waiting for the next change
+		c.sendImmediateTickers()
+
+	case "unsubscribe":
+		c.symbolsLock.Lock()
+		for _, symbol := range msg.Symbols {
+			delete(c.symbols, symbol)
+		}
+		c.symbolsLock.Unlock()
+
+	default:
+		log.Printf("Unknown message type: %s", msg.Type)
+	}
+}
+
+func (c *TickerClient) sendImmediateTickers() {
+	tickers, _ := c.filterTickers(c.hub.tickers.All())
+	if len(tickers) == 0 {
+		return
+	}
+
+	c.enqueue("", newWSMessage(tickers))
+}
+
+// HandleWebSocket handles new /ws/ticker connections
+func (h *TickerHub) HandleWebSocket(c *gin.Context) {
+	conn, ok := h.guard.accept(c, "")
+	if !ok {
+		return
+	}
+
+	client := &TickerClient{
+		wsConn:  conn,
+		hub:     h,
+		symbols: make(map[string]bool),
+	}
+
+	client.hub.register <- client
+
+	go client.writePump()
+	go client.readPump(client.onMessage, func() { h.unregister <- client })
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"log"
+	"sync"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// UserStreamMessage is pushed to the owner of the data.
+//
+// Types: "order" (data: order with "event" accepted/updated/filled/canceled/
+// rejected/expired), "trade" (data: the user's fill with fee), "balance"
+// (data: balances of the changed assets), "security" (data: login/logout).
+type UserStreamMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// userOrderUpdate is an order as pushed on the user stream
+type userOrderUpdate struct {
+	Event string `json:"event"`
+	*models.Order
+}
+
+// UserClient is one /ws/user connection
+type UserClient struct {
+	*wsConn
+	hub    *UserHub
+	userID string
+}
+
+// UserHub pushes private account events to the connections of each user
+type UserHub struct {
+	clients    map[string]map[*UserClient]bool // user id -> connections
+	register   chan *UserClient
+	unregister chan *UserClient
+	mu         sync.RWMutex
+	guard      *wsGuard
+}
+
+// NewUserHub creates a user stream hub
+func NewUserHub(guard *wsGuard) *UserHub {
+	return &UserHub{
+		clients:    make(map[string]map[*UserClient]bool),
+		register:   make(chan *UserClient),
+		unregister: make(chan *UserClient),
+		guard:      guard,
+	}
+}
+
+// Run starts the hub's main loop
+func (h *UserHub) Run() {
+	for {
+		select {
+		case client := <-h.register:
+			h.mu.Lock()
+			if h.clients[client.userID] == nil {
+				h.clients[client.userID] = make(map[*UserClient]bool)
+			}
+			h.clients[client.userID][client] = true
+			h.mu.Unlock()
+			log.Printf("User stream client connected (user %s)", client.userID)
+
+		case client := <-h.unregister:
+			h.mu.Lock()
+			if conns, ok := h.clients[client.userID]; ok && conns[client] {
+				delete(conns, client)
+				if len(conns) == 0 {
+					delete(h.clients, client.userID)
+				}
+				client.close()
+			}
+			h.mu.Unlock()
+			log.Printf("User stream client disconnected (user %s)", client.userID)
+		}
+	}
+}
+
+// StartUserBroadcaster forwards private events from the bus to the user's connections
+func (h *UserHub) StartUserBroadcaster(events *service.EventBus) {
+	ch, unsubscribe := events.Subscribe(4096)
+	defer unsubscribe()
+
+	log.Println("User stream broadcaster started")
+
+	for ev := range ch {
+		if ev.UserID == "" {
+			continue
+		}
+		for _, msg := range userStreamMessages(ev) {
+			h.sendToUser(ev.UserID, msg)
+		}
+	}
+}
+
+// userStreamMessages converts a private event into the messages sent to the user
+func userStreamMessages(ev service.Event) []UserStreamMessage {
+	var msgs []UserStreamMessage
+	switch ev.Type {
+	case service.EventUserOrders:
+		for _, o := range ev.Orders {
+			msgs = append(msgs, UserStreamMessage{Type: "order", Data: userOrderUpdate{
+				Event: service.OrderUpdateKind(o),
+				Order: o,
+			}})
+		}
+	case service.EventUserFills:
+		for _, f := range ev.Fills {
+			msgs = append(msgs, UserStreamMessage{Type: "trade", Data: f.TradeWithSymbol})
+		}
+	case service.EventUserBalances:
+		msgs = append(msgs, UserStreamMessage{Type: "balance", Data: ev.Balances})
+	case service.EventSecurity:
+		msgs = append(msgs, UserStreamMessage{Type: "security", Data: ev.Security})
+	}
+	return msgs
+}
+
+func (h *UserHub) sendToUser(userID string, msg UserStreamMessage) {
+	h.mu.RLock()
+	conns := h.clients[userID]
+	if len(conns) == 0 {
+		h.mu.RUnlock()
+		return
+	}
+
+	data := newWSMessage(msg)
+	for client := range conns {
+		client.enqueue("", data)
+	}
+	h.mu.RUnlock()
+}
+
+// HandleWebSocket handles new /ws/user connections; the route requires authentication
+func (h *UserHub) HandleWebSocket(c *gin.Context) {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, ok := h.guard.accept(c, user.ID.String())
+	if !ok {
+		return
+	}
+
+	client := &UserClient{
+		wsConn: conn,
+		hub:    h,
+		userID: user.ID.String(),
+	}
+
+	client.hub.register <- client
+
+	go client.writePump()
+	// The stream takes no client messages
+	go client.readPump(func(int, []byte) {}, func() { h.unregister <- client })
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = 54 * time.Second
+)
+
+// SlowConsumerPolicy decides what happens when a connection's send queue is full
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest discards the oldest queued message to make room
+	DropOldest SlowConsumerPolicy = "drop-oldest"
+	// ConflateLatest replaces the queued message of the same topic with the new
+	// one; messages without a topic (diffs, trades, private events) fall back
+	// to drop-oldest
+	ConflateLatest SlowConsumerPolicy = "conflate-latest"
+	// Disconnect closes the connection with a close reason
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+// slowConsumerReason is the close reason sent by the disconnect policy
+const slowConsumerReason = "slow consumer"
+
+type queuedMessage struct {
+	key string // Conflation key, "" if the message cannot be replaced
+	ws  *websocket.PreparedMessage
+	sse []byte // Event of an SSE stream, instead of ws
+}
+
+// sendQueue is the bounded outgoing queue of a connection. Hubs push without
+// blocking; when it is full the slow-consumer policy applies. Closing it is
+// safe from any goroutine and more than once.
+type sendQueue struct {
+	mu      sync.Mutex
+	items   []queuedMessage
+	limit   int
+	policy  SlowConsumerPolicy
+	ready   chan struct{} // Signaled when items are added or the queue closes
+	closed  bool
+	reason  string // Close reason for the client, set when the policy disconnects
+	dropped int
+}
+
+func newSendQueue(limit int, policy SlowConsumerPolicy) *sendQueue {
+	return &sendQueue{limit: limit, policy: policy, ready: make(chan struct{}, 1)}
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push queues a message and reports whether it was queued
+func (q *sendQueue) push(item queuedMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+	if len(q.items) >= q.limit {
+		switch q.policy {
+		case Disconnect:
+			q.closed = true
+			q.reason = slowConsumerReason
+			q.items = nil
+			q.signal()
+			return false
+		case ConflateLatest:
+			if item.key != "" {
+				for i := range q.items {
+					if q.items[i].key == item.key {
+						q.items[i] = item
+						return true
+					}
+				}
+			}
+		}
+		q.items = q.items[1:]
+		if q.dropped++; q.dropped == 1 {
+			log.Printf("Client send queue full, dropping oldest messages")
+		}
+	}
+	q.items = append(q.items, item)
+	q.signal()
+	return true
+}
+
+// take removes the queued messages. closed is set once the queue is closed;
+// the messages queued before a normal close are still returned.
+func (q *sendQueue) take() (items []queuedMessage, closed bool, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items, q.items = q.items, nil
+	return items, q.closed, q.reason
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.signal()
+	}
+}
+
+// closeWith closes the queue with a close reason for the client, dropping the
+// messages not sent yet
+func (q *sendQueue) closeWith(reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.reason = reason
+		q.items = nil
+		q.signal()
+	}
+}
+
+// wsConn is the connection core shared by every hub: a bounded send queue of
+// messages framed in the connection's encoding, drained by writePump, and a
+// readPump handing each message to a callback. The owning hub closes the
+// queue once the client is unregistered.
+type wsConn struct {
+	conn     *websocket.Conn
+	encoding wsEncoding
+	queue    *sendQueue
+	release  func() // Frees the connection's slot in the limits
+}
+
+// enqueue serializes a message for the connection's encoding and queues it
+// without blocking the hub. key names the topic for conflation, "" if the
+// message must not be replaced by a later one. A message that cannot be
+// serialized counts as queued.
+func (c *wsConn) enqueue(key string, msg *wsMessage) bool {
+	prepared := msg.prepare(c.encoding)
+	if prepared == nil {
+		return true
+	}
+	return c.queue.push(queuedMessage{key: key, ws: prepared})
+}
+
+// close makes writePump send the queued messages and a close frame
+func (c *wsConn) close() {
+	c.queue.close()
+}
+
+// writePump sends queued messages and keepalive pings until the queue is closed
+func (c *wsConn) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.queue.ready:
+			items, closed, reason := c.queue.take()
+			for _, item := range items {
+				c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+				if err := c.conn.WritePreparedMessage(item.ws); err != nil {
+					return
+				}
+			}
+			if closed {
+				c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+				if reason != "" {
+					log.Printf("Closing WebSocket connection: %s", reason)
+					c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason))
+				} else {
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				}
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPump passes every message and its frame type to onMessage until the
+// connection fails, then calls onClose and frees the connection's slot
+func (c *wsConn) readPump(onMessage func(int, []byte), onClose func()) {
+	defer func() {
+		onClose()
+		c.conn.Close()
+		c.release()
+	}()
+
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	for {
+		messageType, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			return
+		}
+		onMessage(messageType, message)
+	}
+}
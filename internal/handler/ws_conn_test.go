@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+// queued builds a message identified by its SSE payload
+func queued(key, payload string) queuedMessage {
+	return queuedMessage{key: key, sse: []byte(payload)}
+}
+
+func payloads(items []queuedMessage) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = string(item.sse)
+	}
+	return out
+}
+
+func TestSendQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     SlowConsumerPolicy
+		limit      int
+		push       []queuedMessage
+		wantQueued []bool
+		want       []string
+		wantClosed bool
+		wantReason string
+	}{
+		{
+			name:       "below limit",
+			policy:     DropOldest,
+			limit:      3,
+			push:       []queuedMessage{queued("", "a"), queued("", "b")},
+			wantQueued: []bool{true, true},
+			want:       []string{"a", "b"},
+		},
+		{
+			name:       "drop oldest",
+			policy:     DropOldest,
+			limit:      2,
+			push:       []queuedMessage{queued("", "a"), queued("", "b"), queued("", "c"), queued("", "d")},
+			wantQueued: []bool{true, true, true, true},
+			want:       []string{"c", "d"},
+		},
+		{
+			name:       "drop oldest ignores keys",
+			policy:     DropOldest,
+			limit:      2,
+			push:       []queuedMessage{queued("t", "a1"), queued("u", "b1"), queued("t", "a2")},
+			wantQueued: []bool{true, true, true},
+			want:       []string{"b1", "a2"},
+		},
+		{
+			name:       "conflate replaces the same topic in place",
+			policy:     ConflateLatest,
+			limit:      2,
+			push:       []queuedMessage{queued("t", "a1"), queued("u", "b1"), queued("t", "a2")},
+			wantQueued: []bool{true, true, true},
+			want:       []string{"a2", "b1"},
+		},
+		{
+			name:       "conflate only when full",
+			policy:     ConflateLatest,
+			limit:      3,
+			push:       []queuedMessage{queued("t", "a1"), queued("t", "a2")},
+			wantQueued: []bool{true, true},
+			want:       []string{"a1", "a2"},
+		},
+		{
+			name:       "conflate without key drops oldest",
+			policy:     ConflateLatest,
+			limit:      2,
+			push:       []queuedMessage{queued("t", "a1"), queued("", "diff1"), queued("", "diff2")},
+			wantQueued: []bool{true, true, true},
+			want:       []string{"diff1", "diff2"},
+		},
+		{
+			name:       "conflate new topic drops oldest",
+			policy:     ConflateLatest,
+			limit:      2,
+			push:       []queuedMessage{queued("t", "a1"), queued("u", "b1"), queued("v", "c1")},
+			wantQueued: []bool{true, true, true},
+			want:       []string{"b1", "c1"},
+		},
+		{
+			name:       "disconnect",
+			policy:     Disconnect,
+			limit:      2,
+			push:       []queuedMessage{queued("", "a"), queued("", "b"), queued("", "c"), queued("", "d")},
+			wantQueued: []bool{true, true, false, false},
+			want:       []string{},
+			wantClosed: true,
+			wantReason: slowConsumerReason,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(tt.limit, tt.policy)
+			for i, item := range tt.push {
+				if got := q.push(item); got != tt.wantQueued[i] {
+					t.Errorf("push %d (%s) = %v, want %v", i, item.sse, got, tt.wantQueued[i])
+				}
+			}
+			items, closed, reason := q.take()
+			if got := payloads(items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("take = %v, want %v", got, tt.want)
+			}
+			if closed != tt.wantClosed || reason != tt.wantReason {
+				t.Errorf("take closed = %v %q, want %v %q", closed, reason, tt.wantClosed, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestSendQueueSignal(t *testing.T) {
+	q := newSendQueue(4, DropOldest)
+	q.push(queued("", "a"))
+	q.push(queued("", "b"))
+
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("push did not signal")
+	}
+	select {
+	case <-q.ready:
+		t.Fatal("pushes signaled more than once")
+	default:
+	}
+	if items, _, _ := q.take(); len(items) != 2 {
+		t.Errorf("take returned %d messages, want 2", len(items))
+	}
+	if items, _, _ := q.take(); len(items) != 0 {
+		t.Errorf("second take returned %d messages, want 0", len(items))
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	t.Run("close keeps queued messages", func(t *testing.T) {
+		q := newSendQueue(4, DropOldest)
+		q.push(queued("", "a"))
+		q.close()
+		q.close()
+
+		if q.push(queued("", "b")) {
+			t.Error("push after close was queued")
+		}
+		items, closed, reason := q.take()
+		if got := payloads(items); !reflect.DeepEqual(got, []string{"a"}) || !closed || reason != "" {
+			t.Errorf("take = %v %v %q, want [a] true \"\"", got, closed, reason)
+		}
+	})
+
+	t.Run("closeWith drops queued messages", func(t *testing.T) {
+		q := newSendQueue(4, DropOldest)
+		q.push(queued("", "a"))
+		q.closeWith(sessionRevokedReason)
+		q.closeWith("other")
+
+		items, closed, reason := q.take()
+		if len(items) != 0 || !closed || reason != sessionRevokedReason {
+			t.Errorf("take = %v %v %q, want [] true %q", payloads(items), closed, reason, sessionRevokedReason)
+		}
+	})
+
+	t.Run("closeWith after close keeps the normal close", func(t *testing.T) {
+		q := newSendQueue(4, DropOldest)
+		q.close()
+		q.closeWith(sessionRevokedReason)
+
+		if _, closed, reason := q.take(); !closed || reason != "" {
+			t.Errorf("take closed = %v %q, want true \"\"", closed, reason)
+		}
+	})
+}
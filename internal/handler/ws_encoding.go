@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// wsEncoding is the serialization negotiated for a connection
+type wsEncoding int
+
+const (
+	encodingJSON    wsEncoding = iota // Text frames
+	encodingMsgpack                   // Binary frames
+	numEncodings
+)
+
+// encodingNames are also the Sec-WebSocket-Protocol values selecting each encoding
+var encodingNames = [numEncodings]string{"json", "msgpack"}
+
+// msgpackHandle uses the json struct tags, so both encodings carry the same
+// field names; times use the msgpack timestamp extension
+var msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+// negotiateEncoding picks the encoding of a new connection from the offered
+// subprotocols ("msgpack" or "json", first match wins) or else the encoding
+// query parameter. It returns the response header accepting the chosen
+// subprotocol, if any, to pass to Upgrade.
+func negotiateEncoding(r *http.Request) (wsEncoding, http.Header) {
+	for _, proto := range websocket.Subprotocols(r) {
+		for enc, name := range encodingNames {
+			if proto == name {
+				return wsEncoding(enc), http.Header{"Sec-Websocket-Protocol": {name}}
+			}
+		}
+	}
+	if r.URL.Query().Get("encoding") == encodingNames[encodingMsgpack] {
+		return encodingMsgpack, nil
+	}
+	return encodingJSON, nil
+}
+
+func (e wsEncoding) marshal(v interface{}) ([]byte, error) {
+	if e == encodingMsgpack {
+		var data []byte
+		err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+		return data, err
+	}
+	return json.Marshal(v)
+}
+
+// unmarshal decodes a client message; msgpack connections may still send JSON text frames
+func (e wsEncoding) unmarshal(messageType int, data []byte, v interface{}) error {
+	if e == encodingMsgpack && messageType == websocket.BinaryMessage {
+		return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (e wsEncoding) messageType() int {
+	if e == encodingMsgpack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// wsMessage is one outgoing message. It is serialized and framed, compressed
+// frames included, at most once per encoding however many connections it is
+// sent to. It is not safe for concurrent use; a hub builds and sends it from
+// its loop.
+type wsMessage struct {
+	value    interface{}
+	eventID  string // SSE event id, "" if the message cannot be resumed from
+	prepared [numEncodings]*websocket.PreparedMessage
+	failed   [numEncodings]bool
+	sse      []byte
+}
+
+func newWSMessage(v interface{}) *wsMessage {
+	return &wsMessage{value: v}
+}
+
+// prepare returns the message framed for an encoding, nil if it cannot be serialized
+func (m *wsMessage) prepare(enc wsEncoding) *websocket.PreparedMessage {
+	if m.prepared[enc] == nil && !m.failed[enc] {
+		data, err := enc.marshal(m.value)
+		if err == nil {
+			m.prepared[enc], err = websocket.NewPreparedMessage(enc.messageType(), data)
+		}
+		if err != nil {
+			log.Printf("Error encoding %s WebSocket message: %v", encodingNames[enc], err)
+			m.failed[enc] = true
+		}
+	}
+	return m.prepared[enc]
+}
+
+// sseEvent returns the message as a Server-Sent Event with JSON data, nil if
+// it cannot be serialized
+func (m *wsMessage) sseEvent() []byte {
+	if m.sse == nil && !m.failed[encodingJSON] {
+		data, err := encodingJSON.marshal(m.value)
+		if err != nil {
+			log.Printf("Error encoding SSE message: %v", err)
+			m.failed[encodingJSON] = true
+			return nil
+		}
+
+		var b bytes.Buffer
+		if m.eventID != "" {
+			b.WriteString("id: " + m.eventID + "\n")
+		}
+		b.WriteString("data: ")
+		b.Write(data)
+		b.WriteString("\n\n")
+		m.sse = b.Bytes()
+	}
+	return m.sse
+}
@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+)
+
+// errTooManySubscriptions rejects a subscription above WSConfig.MaxSubscriptions
+var errTooManySubscriptions = errors.New("too many subscriptions")
+
+// sessionRevokedReason is the close reason of connections whose login session was revoked
+const sessionRevokedReason = "session revoked"
+
+// WSConfig holds the limits shared by every WebSocket endpoint. A limit of 0
+// means unlimited.
+type WSConfig struct {
+	MaxConnsPerIP    int
+	MaxConnsPerUser  int // Authenticated endpoints only
+	MaxSubscriptions int // Topics, symbols or markets per connection
+	SendQueue        int // Messages queued per connection before the slow-consumer policy applies
+	SlowConsumer     SlowConsumerPolicy
+	AllowedOrigins   []string // Browser origins allowed to connect, same as CORS; "*" allows any
+}
+
+// WSConfigFromEnv reads the limits from WS_MAX_CONNS_PER_IP,
+// WS_MAX_CONNS_PER_USER, WS_MAX_SUBSCRIPTIONS, WS_SEND_QUEUE and
+// WS_SLOW_CONSUMER, with defaults for unset variables
+func WSConfigFromEnv(allowedOrigins []string) (WSConfig, error) {
+	cfg := WSConfig{
+		MaxConnsPerIP:    20,
+		MaxConnsPerUser:  10,
+		MaxSubscriptions: 100,
+		SendQueue:        256,
+		SlowConsumer:     DropOldest,
+		AllowedOrigins:   allowedOrigins,
+	}
+
+	for name, v := range map[string]*int{
+		"WS_MAX_CONNS_PER_IP":   &cfg.MaxConnsPerIP,
+		"WS_MAX_CONNS_PER_USER": &cfg.MaxConnsPerUser,
+		"WS_MAX_SUBSCRIPTIONS":  &cfg.MaxSubscriptions,
+		"WS_SEND_QUEUE":         &cfg.SendQueue,
+	} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid %s: %q", name, s)
+		}
+		*v = n
+	}
+	if cfg.SendQueue == 0 {
+		return cfg, fmt.Errorf("invalid WS_SEND_QUEUE: must be positive")
+	}
+
+	if s := os.Getenv("WS_SLOW_CONSUMER"); s != "" {
+		switch policy := SlowConsumerPolicy(s); policy {
+		case DropOldest, ConflateLatest, Disconnect:
+			cfg.SlowConsumer = policy
+		default:
+			return cfg, fmt.Errorf("invalid WS_SLOW_CONSUMER: %q (drop-oldest, conflate-latest or disconnect)", s)
+		}
+	}
+	return cfg, nil
+}
+
+// wsGuard admits WebSocket connections: it checks the origin and the
+// connection limits, upgrades the request and sets up the connection core.
+// It also tracks the connections of each login session to close them when
+// the session is revoked.
+type wsGuard struct {
+	cfg      WSConfig
+	upgrader websocket.Upgrader
+
+	mu        sync.Mutex
+	byIP      map[string]int
+	byUser    map[string]int
+	bySession map[string]map[*sendQueue]struct{}
+}
+
+func newWSGuard(cfg WSConfig) *wsGuard {
+	g := &wsGuard{
+		cfg:       cfg,
+		byIP:      make(map[string]int),
+		byUser:    make(map[string]int),
+		bySession: make(map[string]map[*sendQueue]struct{}),
+	}
+	g.upgrader = websocket.Upgrader{
+		CheckOrigin:     g.checkOrigin,
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		// permessage-deflate when the client offers it; prepared messages are
+		// compressed once per broadcast
+		EnableCompression: true,
+	}
+	return g
+}
+
+// checkOrigin allows clients without an Origin header (not browsers), the
+// API's own host and the configured origins
+func (g *wsGuard) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range g.cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	log.Printf("Rejected WebSocket connection from origin %s", origin)
+	return false
+}
+
+// acquire takes a connection slot for the IP and, when authenticated, the user
+func (g *wsGuard) acquire(ip, userID string) (release func(), err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.cfg.MaxConnsPerIP > 0 && g.byIP[ip] >= g.cfg.MaxConnsPerIP {
+		return nil, fmt.Errorf("too many WebSocket connections from this IP (max %d)", g.cfg.MaxConnsPerIP)
+	}
+	if userID != "" && g.cfg.MaxConnsPerUser > 0 && g.byUser[userID] >= g.cfg.MaxConnsPerUser {
+		return nil, fmt.Errorf("too many WebSocket connections for this user (max %d)", g.cfg.MaxConnsPerUser)
+	}
+	g.byIP[ip]++
+	if userID != "" {
+		g.byUser[userID]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.byIP[ip]--; g.byIP[ip] <= 0 {
+				delete(g.byIP, ip)
+			}
+			if userID != "" {
+				if g.byUser[userID]--; g.byUser[userID] <= 0 {
+					delete(g.byUser, userID)
+				}
+			}
+		})
+	}, nil
+}
+
+// admit takes a connection slot for the request's IP and the user ("" on
+// public endpoints), or writes 429 and returns ok false
+func (g *wsGuard) admit(c *gin.Context, userID string) (release func(), ok bool) {
+	release, err := g.acquire(c.ClientIP(), userID)
+	if err != nil {
+		c.JSON(429, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return release, true
+}
+
+// accept admits a connection of the user and upgrades it with the negotiated
+// encoding. On failure the response is already written and ok is false.
+func (g *wsGuard) accept(c *gin.Context, userID string) (conn *wsConn, ok bool) {
+	release, ok := g.admit(c, userID)
+	if !ok {
+		return nil, false
+	}
+
+	encoding, header := negotiateEncoding(c.Request)
+	ws, err := g.upgrader.Upgrade(c.Writer, c.Request, header)
+	if err != nil {
+		release()
+		log.Printf("Failed to upgrade connection: %v", err)
+		return nil, false
+	}
+
+	queue := newSendQueue(g.cfg.SendQueue, g.cfg.SlowConsumer)
+	// Authenticated connections of a login session (not API keys) end with it
+	if sessionID := c.GetString("sessionId"); userID != "" && sessionID != "" {
+		untrack := g.track(sessionID, queue)
+		free := release
+		release = func() {
+			untrack()
+			free()
+		}
+	}
+
+	return &wsConn{
+		conn:     ws,
+		encoding: encoding,
+		queue:    queue,
+		release:  release,
+	}, true
+}
+
+// track registers the send queue of a connection of a session and returns
+// the function unregistering it
+func (g *wsGuard) track(sessionID string, queue *sendQueue) func() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	queues := g.bySession[sessionID]
+	if queues == nil {
+		queues = make(map[*sendQueue]struct{})
+		g.bySession[sessionID] = queues
+	}
+	queues[queue] = struct{}{}
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		delete(queues, queue)
+		if len(queues) == 0 {
+			delete(g.bySession, sessionID)
+		}
+	}
+}
+
+// closeSessions closes the connections of revoked sessions
+func (g *wsGuard) closeSessions(sessionIDs []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, id := range sessionIDs {
+		for queue := range g.bySession[id] {
+			queue.closeWith(sessionRevokedReason)
+		}
+	}
+}
+
+// startSessionRevoker closes the connections of sessions revoked on any instance
+func (g *wsGuard) startSessionRevoker(events *service.EventBus) {
+	ch, unsubscribe := events.Subscribe(256)
+	defer unsubscribe()
+
+	for ev := range ch {
+		if ev.Type == service.EventSessionsRevoked {
+			g.closeSessions(ev.SessionIDs)
+		}
+	}
+}
+
+// subscriptionsFull reports whether a connection holding n subscriptions may not add more
+func (g *wsGuard) subscriptionsFull(n int) bool {
+	return g.cfg.MaxSubscriptions > 0 && n >= g.cfg.MaxSubscriptions
+}
@@ -0,0 +1,58 @@
+// Package mailer sends account emails: verification, password reset and
+// unlock links. The transport is chosen at startup: files for local
+// development, or SMTP.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv picks the mailer from MAIL_DRIVER: "file" (default) writes messages
+// to MAIL_DIR, "smtp" sends them through SMTP_HOST and SMTP_PORT with
+// SMTP_USERNAME and SMTP_PASSWORD. MAIL_FROM is the sender address.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir, from)
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required with MAIL_DRIVER=smtp")
+		}
+		port := 587
+		if s := os.Getenv("SMTP_PORT"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid SMTP_PORT: %q", s)
+			}
+			port = n
+		}
+		return NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	default:
+		return nil, fmt.Errorf("invalid MAIL_DRIVER: %q (file or smtp)", driver)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+)
+
+// These cases are all rejected before the key is looked up, so the service
+// needs no repository
+func TestAuthenticateAPIKeyHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	apiKeys, err := service.NewAPIKeyService(nil, "test-encryption-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10)
+
+	tests := []struct {
+		name       string
+		apiKeys    *service.APIKeyService
+		headers    map[string]string
+		body       string
+		wantStatus int
+		wantIn     string
+	}{
+		{"API keys disabled", nil, map[string]string{APITimestampHeader: now, APISignatureHeader: "00"}, "", http.StatusUnauthorized, "not enabled"},
+		{"missing timestamp", apiKeys, map[string]string{APISignatureHeader: "00"}, "", http.StatusUnauthorized, APITimestampHeader},
+		{"timestamp not a number", apiKeys, map[string]string{APITimestampHeader: "2024-01-01", APISignatureHeader: "00"}, "", http.StatusUnauthorized, APITimestampHeader},
+		{"missing signature", apiKeys, map[string]string{APITimestampHeader: now}, "", http.StatusUnauthorized, APISignatureHeader},
+		{"zero recvWindow", apiKeys, map[string]string{APITimestampHeader: now, APISignatureHeader: "00", APIRecvWindowHeader: "0"}, "", http.StatusBadRequest, APIRecvWindowHeader},
+		{"recvWindow above max", apiKeys, map[string]string{APITimestampHeader: now, APISignatureHeader: "00", APIRecvWindowHeader: "60001"}, "", http.StatusBadRequest, APIRecvWindowHeader},
+		{"body too large", apiKeys, map[string]string{APITimestampHeader: now, APISignatureHeader: "00"}, strings.Repeat("x", maxSignedBody+1), http.StatusRequestEntityTooLarge, "too large"},
+		{"outside default recvWindow", apiKeys, map[string]string{APITimestampHeader: stale, APISignatureHeader: "00"}, "", http.StatusUnauthorized, "recvWindow"},
+		{"outside custom recvWindow", apiKeys, map[string]string{APITimestampHeader: stale, APISignatureHeader: "00", APIRecvWindowHeader: "30000"}, "", http.StatusUnauthorized, "recvWindow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/order", strings.NewReader(tt.body))
+			c.Request.Header.Set(APIKeyHeader, "key")
+			for name, value := range tt.headers {
+				c.Request.Header.Set(name, value)
+			}
+
+			if _, ok := authenticateAPIKey(c, tt.apiKeys); ok {
+				t.Fatal("authenticateAPIKey succeeded")
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantIn) {
+				t.Errorf("body %s does not mention %q", w.Body.String(), tt.wantIn)
+			}
+			if !c.IsAborted() {
+				t.Error("request not aborted")
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
 )
 
 type UserContext struct {
@@ -29,56 +30,38 @@ type UserContext struct {
 
 }
 
-func RequireAuth(db *sql.DB) gin.HandlerFunc {
+// RequireAuth authenticates a request with either a JWT access token or a
+// signed API key (see apiKeyAuth.go) and sets "user" and "scopes"; API key
+// requests also get "apiKey", JWT requests "sessionId". Access tokens of a
+// revoked session are refused even before they expire.
+func RequireAuth(db *sql.DB, apiKeys *service.APIKeyService, sessions *service.RefreshTokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		jwtSecret := os.Getenv("ACCESS_TOKEN_SECRET")
-
-		// 1. Lấy Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Access token not found"})
-			c.Abort()
-			return
-		}
-
-		// 2. Cắt "Bearer " lấy token
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// 3. Parse + verify token
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			// Optional: kiểm tra signing method
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrTokenSignatureInvalid
+		var userID uuid.UUID
+		if c.GetHeader(APIKeyHeader) != "" {
+			key, ok := authenticateAPIKey(c, apiKeys)
+			if !ok {
+				return
 			}
-			return []byte(jwtSecret), nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusForbidden, gin.H{"message": "Access token expired or incorrect"})
-			c.Abort()
-			return
-		}
-
-		// 4. Lấy claims (userId) từ token
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		userIDStr, ok := claims["userId"].(string)
-		if !ok || userIDStr == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "userId not found in token"})
-			c.Abort()
-			return
-		}
-
-		// 5. Parse UUID
-		userID, err := uuid.Parse(userIDStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
-			c.Abort()
-			return
+			id, err := uuid.Parse(key.UserID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "invalid user id"})
+				c.Abort()
+				return
+			}
+			userID = id
+			c.Set("apiKey", key)
+			c.Set("scopes", key.Scopes)
+		} else {
+			id, sessionID, ok := jwtUserID(c)
+			if !ok {
+				return
+			}
+			if sessionID != "" && !sessionActive(c, sessions, sessionID) {
+				return
+			}
+			userID = id
+			c.Set("scopes", sessionScopes)
+			c.Set("sessionId", sessionID)
 		}
 
 		// 6. Query user từ Postgres
@@ -100,7 +83,7 @@ func RequireAuth(db *sql.DB) gin.HandlerFunc {
 			updated_at	   time.Time
 		)
 
-		err = db.QueryRowContext(ctx, `
+		err := db.QueryRowContext(ctx, `
 			SELECT *
 			FROM users
 			WHERE id = $1
@@ -136,4 +119,97 @@ func RequireAuth(db *sql.DB) gin.HandlerFunc {
 		c.Set("user", userCtx)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// jwtUserID verifies the bearer access token and returns its user and session ids
+func jwtUserID(c *gin.Context) (uuid.UUID, string, bool) {
+	jwtSecret := os.Getenv("ACCESS_TOKEN_SECRET")
+
+	// 1-2. Lấy token từ Authorization header ("Bearer ...")
+	tokenString, ok := accessToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Access token not found"})
+		c.Abort()
+		return uuid.Nil, "", false
+	}
+
+	// 3. Parse + verify token
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		// Optional: kiểm tra signing method
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"message": "Access token expired or incorrect"})
+		c.Abort()
+		return uuid.Nil, "", false
+	}
+
+	// 4. Lấy claims (userId) từ token
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token"})
+		c.Abort()
+		return uuid.Nil, "", false
+	}
+
+	userIDStr, ok := claims["userId"].(string)
+	if !ok || userIDStr == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "userId not found in token"})
+		c.Abort()
+		return uuid.Nil, "", false
+	}
+
+	// 5. Parse UUID
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		c.Abort()
+		return uuid.Nil, "", false
+	}
+	// The session is looked up by its uuid family id
+	sessionID, _ := claims["sid"].(string)
+	if sessionID != "" {
+		if _, err := uuid.Parse(sessionID); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid session in token"})
+			c.Abort()
+			return uuid.Nil, "", false
+		}
+	}
+	return userID, sessionID, true
+}
+
+// sessionActive refuses the request when the session of its access token was revoked
+func sessionActive(c *gin.Context, sessions *service.RefreshTokenService, sessionID string) bool {
+	active, err := sessions.SessionActive(c.Request.Context(), sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+		c.Abort()
+		return false
+	}
+	if !active {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Session has been revoked"})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// accessToken reads the bearer token from the Authorization header. Browsers
+// cannot set headers on WebSocket handshakes, so those may pass it as the
+// access_token query parameter instead.
+func accessToken(c *gin.Context) (string, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer "), true
+	}
+
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		if token := c.Query("access_token"); token != "" {
+			return token, true
+		}
+	}
+	return "", false
+}
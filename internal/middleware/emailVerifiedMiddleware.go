@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireVerifiedEmail lets only users with a verified email through, for
+// trading. Register after RequireAuth.
+func RequireVerifiedEmail(accounts *service.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userVal, _ := c.Get("user")
+		user, ok := userVal.(UserContext)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		verified, err := accounts.EmailVerified(c.Request.Context(), user.ID.String())
+		if err != nil {
+			log.Printf("Error checking email verification: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			c.Abort()
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Verify your email before trading", "emailVerificationRequired": true})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
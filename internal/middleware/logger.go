@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedQueryParams are query parameters whose values never reach the access log
+var redactedQueryParams = []string{"access_token"}
+
+// Logger is gin's request logger with credentials passed in the query
+// string, such as WebSocket access tokens, masked
+func Logger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(p gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			p.StatusCode,
+			p.Latency,
+			p.ClientIP,
+			p.Method,
+			redactQuery(p.Path),
+			p.ErrorMessage,
+		)
+	})
+}
+
+// redactQuery masks the values of redactedQueryParams in a path with query
+func redactQuery(path string) string {
+	base, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		for _, redacted := range redactedQueryParams {
+			if strings.EqualFold(name, redacted) {
+				params[i] = name + "=REDACTED"
+			}
+		}
+	}
+	return base + "?" + strings.Join(params, "&")
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import "testing"
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/ws/user", "/ws/user"},
+		{"/ws/user?", "/ws/user?"},
+		{"/ws/user?access_token=eyJ.abc.def", "/ws/user?access_token=REDACTED"},
+		{"/ws/l3?symbol=BTCUSDT&access_token=eyJ.abc.def&format=cbor", "/ws/l3?symbol=BTCUSDT&access_token=REDACTED&format=cbor"},
+		{"/ws/user?access_token=a&access_token=b", "/ws/user?access_token=REDACTED&access_token=REDACTED"},
+		{"/ws/user?ACCESS_TOKEN=eyJ", "/ws/user?ACCESS_TOKEN=REDACTED"},
+		{"/ws/user?access_token", "/ws/user?access_token=REDACTED"},
+		{"/market/trades?symbol=BTCUSDT&limit=5", "/market/trades?symbol=BTCUSDT&limit=5"},
+		{"/market/trades?my_access_token=x", "/market/trades?my_access_token=x"},
+	}
+	for _, tt := range tests {
+		if got := redactQuery(tt.path); got != tt.want {
+			t.Errorf("redactQuery(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// TwoFACodeHeader carries the TOTP or recovery code of a sensitive request
+const TwoFACodeHeader = "X-2FA-CODE"
+
+// RequireTwoFA guards sensitive actions such as withdrawals, API key creation
+// and password changes: the user must have 2FA enabled and send a valid code
+// in the X-2FA-CODE header. Register after RequireAuth.
+func RequireTwoFA(twofa *service.TwoFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userVal, _ := c.Get("user")
+		user, ok := userVal.(UserContext)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		// Without a code, Verify still tells a missing code from 2FA being off
+		code := c.GetHeader(TwoFACodeHeader)
+		err := twofa.Verify(c.Request.Context(), user.ID.String(), code)
+		if code == "" && errors.Is(err, service.ErrInvalidTwoFACode) {
+			c.JSON(http.StatusForbidden, gin.H{"message": "2FA code required in " + TwoFACodeHeader, "twoFactorRequired": true})
+			c.Abort()
+			return
+		}
+		switch {
+		case err == nil:
+			c.Next()
+		case errors.Is(err, service.ErrTwoFANotEnabled):
+			c.JSON(http.StatusForbidden, gin.H{"message": "Two-factor authentication must be enabled for this action", "twoFactorRequired": true})
+			c.Abort()
+		case errors.Is(err, service.ErrInvalidTwoFACode):
+			c.JSON(http.StatusForbidden, gin.H{"message": err.Error(), "twoFactorRequired": true})
+			c.Abort()
+		case errors.Is(err, service.ErrTwoFALocked):
+			var locked *service.TwoFALockedError
+			if errors.As(err, &locked) {
+				c.Header("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+			}
+			c.JSON(http.StatusTooManyRequests, gin.H{"message": err.Error()})
+			c.Abort()
+		default:
+			log.Printf("Error verifying 2FA code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			c.Abort()
+		}
+	}
+}
+
+// CurrentPasswordHeader carries the password of a user without 2FA for
+// actions guarded by RequireTwoFAOrPassword
+const CurrentPasswordHeader = "X-CURRENT-PASSWORD"
+
+// RequireTwoFAOrPassword guards actions that add a way to sign in, such as
+// registering a passkey: users with 2FA send a code as for RequireTwoFA, others
+// their current password in X-CURRENT-PASSWORD. Wrong passwords count as
+// failed logins. Register after RequireAuth.
+func RequireTwoFAOrPassword(twofa *service.TwoFAService, accounts *service.AccountService, throttle *service.LoginThrottle) gin.HandlerFunc {
+	requireTwoFA := RequireTwoFA(twofa)
+	return func(c *gin.Context) {
+		userVal, _ := c.Get("user")
+		user, ok := userVal.(UserContext)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		ctx := c.Request.Context()
+		userID, ip := user.ID.String(), c.ClientIP()
+
+		enabled, err := twofa.Enabled(ctx, userID)
+		if err != nil {
+			log.Printf("Error reading 2FA status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			c.Abort()
+			return
+		}
+		if enabled {
+			requireTwoFA(c)
+			return
+		}
+
+		password := c.GetHeader(CurrentPasswordHeader)
+		if password == "" {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Current password required in " + CurrentPasswordHeader, "passwordRequired": true})
+			c.Abort()
+			return
+		}
+		block, err := throttle.Check(ctx, userID, ip)
+		if err != nil {
+			log.Printf("Login throttle check error: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Temporarily unavailable, try again later"})
+			c.Abort()
+			return
+		}
+		if block != nil {
+			c.Header("Retry-After", strconv.Itoa(int(block.RetryAfter().Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"message": "Too many failed attempts, try again later"})
+			c.Abort()
+			return
+		}
+
+		err = accounts.CheckPassword(ctx, userID, password)
+		switch {
+		case err == nil:
+			c.Next()
+		case errors.Is(err, service.ErrIncorrectPassword):
+			if _, err := throttle.Failure(ctx, userID, ip); err != nil {
+				log.Printf("Login throttle error: %v", err)
+			}
+			c.JSON(http.StatusForbidden, gin.H{"message": "Current password is incorrect", "passwordRequired": true})
+			c.Abort()
+		default:
+			log.Printf("Error checking password: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "System error (db)"})
+			c.Abort()
+		}
+	}
+}
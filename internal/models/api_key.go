@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// API key scopes
+const (
+	ScopeRead     = "read"     // Account, orders and trades
+	ScopeTrade    = "trade"    // Place, amend and cancel orders
+	ScopeWithdraw = "withdraw" // Withdrawals
+	ScopeTransfer = "transfer" // Transfers between accounts
+)
+
+// APIKey is a user's key for signed programmatic requests. The secret is
+// only returned when the key is created.
+type APIKey struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"-"`
+	Key         string     `json:"key"`
+	Label       string     `json:"label"`
+	Scopes      []string   `json:"scopes"`
+	IPAllowlist []string   `json:"ipAllowlist"` // IPs or CIDRs, empty for any
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+
+	SecretEnc []byte `json:"-"`
+}
@@ -12,4 +12,6 @@ type OHLCV struct {
 	Low       float64   `json:"low"`
 	Close     float64   `json:"close"` // Current price (live updates)
 	Volume    float64   `json:"volume"`
+
+	LastTradeSeq int64 `json:"lastTradeSeq,omitempty"` // Sequence of the trade that set Close
 }
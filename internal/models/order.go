@@ -46,6 +46,7 @@ type Order struct {
 	Status         OrderStatus `json:"status"`
 	Fee            float64     `json:"fee"`
 	TIF            TimeInForce `json:"tif"`
+	ClientOrderID  *string     `json:"clientOrderId,omitempty"`
 
 	CreatedAt  time.Time  `json:"createdAt"`
 	UpdatedAt  time.Time  `json:"updatedAt"`
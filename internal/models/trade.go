@@ -2,16 +2,23 @@ package models
 
 import "time"
 
+type TradeRole string
+const (
+	Maker TradeRole = "maker"
+	Taker TradeRole = "taker"
+)
+
 type Trade struct {
-	ID           string
-	MarketID     string
-	MakerOrderID string
-	TakerOrderID string
-	TakerSide    OrderSide
-	Price        float64
-	Amount       float64
-	QuoteAmount  float64
-	FeeMaker     float64
-	FeeTaker     float64
-	TradeTime    time.Time
+	ID           string    `json:"id"`
+	Seq          int64     `json:"seq"`
+	MarketID     string    `json:"marketId"`
+	MakerOrderID string    `json:"makerOrderId"`
+	TakerOrderID string    `json:"takerOrderId"`
+	TakerSide    OrderSide `json:"takerSide"`
+	Price        float64   `json:"price"`
+	Amount       float64   `json:"amount"`
+	QuoteAmount  float64   `json:"quoteAmount"`
+	FeeMaker     float64   `json:"feeMaker"`
+	FeeTaker     float64   `json:"feeTaker"`
+	TradeTime    time.Time `json:"tradeTime"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// WebAuthnCredential is a passkey registered by a user
+type WebAuthnCredential struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"-"`
+	CredentialID []byte     `json:"credentialId"` // Raw id, base64 in JSON
+	PublicKey    []byte     `json:"-"`            // COSE_Key
+	SignCount    int64      `json:"signCount"`
+	Name         string     `json:"name"`
+	Transports   []string   `json:"transports"`
+	AAGUID       *string    `json:"aaguid"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+}
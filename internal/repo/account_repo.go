@@ -0,0 +1,140 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Account token purposes
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+)
+
+// AccountRepo stores passwords, email verification and the single-use
+// tokens mailed to users
+type AccountRepo struct{ db *sql.DB }
+
+func NewAccountRepo(db *sql.DB) *AccountRepo { return &AccountRepo{db: db} }
+
+// AccountEmail is the address and verification state of a user
+type AccountEmail struct {
+	Email      string
+	FirstName  string
+	VerifiedAt *time.Time
+}
+
+// Email returns a user's email; sql.ErrNoRows for unknown users
+func (r *AccountRepo) Email(ctx context.Context, userID string) (*AccountEmail, error) {
+	var e AccountEmail
+	err := r.db.QueryRowContext(ctx, `
+SELECT u.email, u.first_name, ua.email_verified_at
+FROM users u
+JOIN user_auth ua ON ua.user_id = u.id
+WHERE u.id = $1`, userID).Scan(&e.Email, &e.FirstName, &e.VerifiedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// UserIDByEmail finds a user by email, case-insensitively; sql.ErrNoRows when none
+func (r *AccountRepo) UserIDByEmail(ctx context.Context, email string) (string, error) {
+	var userID string
+	err := r.db.QueryRowContext(ctx, `
+SELECT id::text FROM users WHERE lower(email) = lower($1)`, email).Scan(&userID)
+	return userID, err
+}
+
+// PasswordHash returns a user's bcrypt hash; sql.ErrNoRows for unknown users
+func (r *AccountRepo) PasswordHash(ctx context.Context, userID string) (string, error) {
+	var hash string
+	err := r.db.QueryRowContext(ctx, `
+SELECT password_hash FROM user_auth WHERE user_id = $1`, userID).Scan(&hash)
+	return hash, err
+}
+
+// SetPassword replaces a user's password and invalidates pending reset tokens
+func (r *AccountRepo) SetPassword(ctx context.Context, userID, hash string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := expectRow(tx.ExecContext(ctx, `
+UPDATE user_auth
+SET password_hash = $2, last_password_change = NOW()
+WHERE user_id = $1`, userID, hash)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE account_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, TokenPasswordReset); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetEmailVerified marks a user's email as verified and reports false when it already was
+func (r *AccountRepo) SetEmailVerified(ctx context.Context, userID string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE user_auth
+SET email_verified_at = NOW()
+WHERE user_id = $1 AND email_verified_at IS NULL`, userID)
+	return rowAffected(res, err)
+}
+
+// EmailVerified reports whether a user's email is verified
+func (r *AccountRepo) EmailVerified(ctx context.Context, userID string) (bool, error) {
+	var verified bool
+	err := r.db.QueryRowContext(ctx, `
+SELECT email_verified_at IS NOT NULL FROM user_auth WHERE user_id = $1`, userID).Scan(&verified)
+	return verified, err
+}
+
+// InsertToken stores a token and invalidates the user's earlier ones of the
+// same purpose, so only the latest link works
+func (r *AccountRepo) InsertToken(ctx context.Context, userID, purpose, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE account_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO account_tokens(token_hash, user_id, purpose, expires_at)
+VALUES($1, $2, $3, $4)`, tokenHash, userID, purpose, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LastTokenAt returns when the user's latest token of a purpose was created,
+// a zero time when there is none
+func (r *AccountRepo) LastTokenAt(ctx context.Context, userID, purpose string) (time.Time, error) {
+	var at sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+SELECT MAX(created_at) FROM account_tokens WHERE user_id = $1 AND purpose = $2`, userID, purpose).Scan(&at)
+	return at.Time, err
+}
+
+// UseToken marks a valid token as used and returns its user; sql.ErrNoRows
+// when unknown, used or expired
+func (r *AccountRepo) UseToken(ctx context.Context, purpose, tokenHash string) (string, error) {
+	var userID string
+	err := r.db.QueryRowContext(ctx, `
+UPDATE account_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id::text`, tokenHash, purpose).Scan(&userID)
+	return userID, err
+}
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+)
+
+type APIKeyRepo struct{ db *sql.DB }
+
+func NewAPIKeyRepo(db *sql.DB) *APIKeyRepo { return &APIKeyRepo{db: db} }
+
+// Arrays are read as comma-joined text: scopes and IPs never contain commas
+const apiKeyColumns = `
+	id, user_id, key, secret_enc, label,
+	array_to_string(scopes, ','), array_to_string(ip_allowlist, ','),
+	expires_at, last_used_at, created_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*models.APIKey, error) {
+	var (
+		k           models.APIKey
+		scopes, ips string
+	)
+	if err := row.Scan(&k.ID, &k.UserID, &k.Key, &k.SecretEnc, &k.Label, &scopes, &ips,
+		&k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = splitList(scopes)
+	k.IPAllowlist = splitList(ips)
+	return &k, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// Insert stores a new key and sets its id and creation time
+func (r *APIKeyRepo) Insert(ctx context.Context, k *models.APIKey) error {
+	q := `
+INSERT INTO api_keys(user_id, key, secret_enc, label, scopes, ip_allowlist, expires_at)
+VALUES($1, $2, $3, $4, $5::text[], $6::text[], $7)
+RETURNING id, created_at`
+	return r.db.QueryRowContext(ctx, q,
+		k.UserID, k.Key, k.SecretEnc, k.Label, k.Scopes, k.IPAllowlist, k.ExpiresAt,
+	).Scan(&k.ID, &k.CreatedAt)
+}
+
+// GetActiveByKey returns a key that is not revoked; expiry is checked by the caller
+func (r *APIKeyRepo) GetActiveByKey(ctx context.Context, key string) (*models.APIKey, error) {
+	q := `SELECT` + apiKeyColumns + `
+FROM api_keys
+WHERE key = $1 AND revoked_at IS NULL`
+	return scanAPIKey(r.db.QueryRowContext(ctx, q, key))
+}
+
+// ListByUser returns the user's keys that are not revoked, newest first
+func (r *APIKeyRepo) ListByUser(ctx context.Context, userID string) ([]*models.APIKey, error) {
+	q := `SELECT` + apiKeyColumns + `
+FROM api_keys
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC`
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke revokes one of the user's keys and reports whether it existed
+func (r *APIKeyRepo) Revoke(ctx context.Context, userID, id string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE api_keys SET revoked_at = NOW()
+WHERE id::text = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TouchLastUsed records that a key authenticated a request
+func (r *APIKeyRepo) TouchLastUsed(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, id)
+	return err
+}
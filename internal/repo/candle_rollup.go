@@ -0,0 +1,274 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+)
+
+// ErrUnsupportedInterval is returned for a candle interval that is not served
+var ErrUnsupportedInterval = errors.New("unsupported interval")
+
+// candleInterval describes one materialized rollup level. Each level is
+// aggregated from a smaller one whose buckets nest exactly inside its own.
+type candleInterval struct {
+	name   string
+	source string        // Level aggregated from ("1m" is the ohlcv_1m table)
+	width  time.Duration // Fixed-width intervals, aligned to UTC
+	unit   string        // Calendar intervals (day, week, month), aligned to the candle timezone
+}
+
+// candleIntervals lists the rollups in build order, every source comes first.
+// 1D is built from 15m so it nests for timezones with 30 or 45 minute offsets.
+var candleIntervals = []candleInterval{
+	{name: "5m", source: "1m", width: 5 * time.Minute},
+	{name: "15m", source: "5m", width: 15 * time.Minute},
+	{name: "1h", source: "15m", width: time.Hour},
+	{name: "4h", source: "1h", width: 4 * time.Hour},
+	{name: "1D", source: "15m", unit: "day"},
+	{name: "1W", source: "1D", unit: "week"},
+	{name: "1M", source: "1D", unit: "month"},
+}
+
+// CandleIntervals returns the names of all supported intervals, smallest first
+func CandleIntervals() []string {
+	names := []string{"1m"}
+	for _, iv := range candleIntervals {
+		names = append(names, iv.name)
+	}
+	return names
+}
+
+func findCandleInterval(name string) (candleInterval, bool) {
+	for _, iv := range candleIntervals {
+		if iv.name == name {
+			return iv, true
+		}
+	}
+	return candleInterval{}, false
+}
+
+// bucket returns the bounds of the interval bucket containing t
+func (iv candleInterval) bucket(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	if iv.width > 0 {
+		start := t.UTC().Truncate(iv.width)
+		return start, start.Add(iv.width)
+	}
+
+	lt := t.In(loc)
+	day := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, loc)
+	switch iv.unit {
+	case "week":
+		// Weeks start on Monday, like date_trunc('week')
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case "month":
+		start := time.Date(lt.Year(), lt.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// bucketSQL returns the SQL expressions of the bucket open and close times of
+// the source row's open_time. tzParam is the placeholder of the timezone name.
+func (iv candleInterval) bucketSQL(tzParam string) (string, string) {
+	if iv.width > 0 {
+		secs := int64(iv.width / time.Second)
+		open := fmt.Sprintf("to_timestamp(floor(extract(epoch FROM open_time) / %d) * %d)", secs, secs)
+		return open, fmt.Sprintf("%s + interval '%d seconds'", open, secs)
+	}
+
+	local := fmt.Sprintf("date_trunc('%s', open_time AT TIME ZONE %s)", iv.unit, tzParam)
+	return fmt.Sprintf("(%s AT TIME ZONE %s)", local, tzParam),
+		fmt.Sprintf("((%s + interval '1 %s') AT TIME ZONE %s)", local, iv.unit, tzParam)
+}
+
+// SetCandleLocation sets the timezone that 1D, 1W and 1M candles are aligned to.
+// Changing it requires rebuilding those rollups.
+func (r *MarketRepo) SetCandleLocation(loc *time.Location) {
+	r.candleLoc = loc
+}
+
+// RollupCandles recomputes every rollup bucket that overlaps [from, to) from
+// the saved 1m candles. An empty symbol rolls up all symbols. Recomputing is
+// idempotent, so late or corrected 1m candles just roll up again.
+func (r *MarketRepo) RollupCandles(ctx context.Context, symbol string, from, to time.Time) error {
+	if !from.Before(to) {
+		return nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, iv := range candleIntervals {
+		start, _ := iv.bucket(from, r.candleLoc)
+		_, end := iv.bucket(to.Add(-time.Nanosecond), r.candleLoc)
+
+		q, args := r.rollupQuery(iv, symbol, start, end)
+		if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+			return fmt.Errorf("rollup %s: %w", iv.name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *MarketRepo) rollupQuery(iv candleInterval, symbol string, from, to time.Time) (string, []interface{}) {
+	args := []interface{}{iv.name, symbol, from, to}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	source := "ohlcv_1m WHERE true"
+	if iv.source != "1m" {
+		source = "ohlcv_rollup WHERE timeframe = " + arg(iv.source)
+	}
+	var openExpr, closeExpr string
+	if iv.width > 0 {
+		openExpr, closeExpr = iv.bucketSQL("")
+	} else {
+		openExpr, closeExpr = iv.bucketSQL(arg(r.candleLoc.String()) + "::text")
+	}
+
+	q := fmt.Sprintf(`
+		INSERT INTO ohlcv_rollup (symbol, timeframe, open_time, close_time, open, high, low, close, volume)
+		SELECT
+			symbol,
+			$1::text,
+			bucket_open,
+			bucket_close,
+			(array_agg(open ORDER BY src_time ASC))[1],
+			MAX(high),
+			MIN(low),
+			(array_agg(close ORDER BY src_time DESC))[1],
+			SUM(volume)
+		FROM (
+			SELECT symbol, open_time AS src_time, open, high, low, close, volume,
+				%s AS bucket_open,
+				%s AS bucket_close
+			FROM %s
+			AND ($2::text = '' OR symbol = $2)
+			AND open_time >= $3 AND open_time < $4
+		) src
+		GROUP BY symbol, bucket_open, bucket_close
+		ON CONFLICT (symbol, timeframe, open_time) DO UPDATE SET
+			close_time = EXCLUDED.close_time,
+			open = EXCLUDED.open,
+			high = EXCLUDED.high,
+			low = EXCLUDED.low,
+			close = EXCLUDED.close,
+			volume = EXCLUDED.volume
+	`, openExpr, closeExpr, source)
+	return q, args
+}
+
+// GetLastRollupTime returns the open time of the latest 5m rollup of a symbol (nil if none)
+func (r *MarketRepo) GetLastRollupTime(ctx context.Context, symbol string) (*time.Time, error) {
+	q := `SELECT MAX(open_time) FROM ohlcv_rollup WHERE symbol = $1 AND timeframe = '5m'`
+	return r.queryTime(ctx, q, symbol)
+}
+
+// GetFirstCandleTime returns the open time of the oldest saved 1m candle of a symbol (nil if none)
+func (r *MarketRepo) GetFirstCandleTime(ctx context.Context, symbol string) (*time.Time, error) {
+	q := `SELECT MIN(open_time) FROM ohlcv_1m WHERE symbol = $1`
+	return r.queryTime(ctx, q, symbol)
+}
+
+func (r *MarketRepo) queryTime(ctx context.Context, q string, args ...interface{}) (*time.Time, error) {
+	var t sql.NullTime
+	if err := r.db.QueryRowContext(ctx, q, args...).Scan(&t); err != nil {
+		return nil, err
+	}
+	if !t.Valid {
+		return nil, nil
+	}
+	return &t.Time, nil
+}
+
+// getRollupCandles fetches materialized candles of one interval, newest first
+func (r *MarketRepo) getRollupCandles(ctx context.Context, symbol string, iv candleInterval, limit int, endTime *time.Time) ([]models.OHLCV, error) {
+	args := []interface{}{symbol, iv.name, limit}
+	where := "symbol = $1 AND timeframe = $2"
+	if endTime != nil {
+		args = append(args, *endTime)
+		where += " AND open_time < $4"
+	}
+
+	q := `
+		SELECT symbol, open_time, close_time, open, high, low, close, volume
+		FROM ohlcv_rollup
+		WHERE ` + where + `
+		ORDER BY open_time DESC
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candles := []models.OHLCV{}
+	for rows.Next() {
+		var c models.OHLCV
+		if err := rows.Scan(&c.Symbol, &c.OpenTime, &c.CloseTime, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume); err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	return candles, rows.Err()
+}
+
+// CandleBucket returns the bounds of the interval bucket containing t, in the
+// candle timezone for calendar intervals
+func (r *MarketRepo) CandleBucket(interval string, t time.Time) (time.Time, time.Time, error) {
+	if interval == "1m" {
+		start := t.Truncate(time.Minute)
+		return start, start.Add(time.Minute), nil
+	}
+	iv, ok := findCandleInterval(interval)
+	if !ok {
+		return time.Time{}, time.Time{}, ErrUnsupportedInterval
+	}
+	start, end := iv.bucket(t, r.candleLoc)
+	return start, end, nil
+}
+
+// GetRollupBucket returns the materialized candles of every symbol for the
+// bucket of interval containing t. For the bucket in progress they cover the
+// 1m candles saved so far.
+func (r *MarketRepo) GetRollupBucket(ctx context.Context, interval string, t time.Time) ([]models.OHLCV, error) {
+	start, _, err := r.CandleBucket(interval, t)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+		SELECT symbol, open_time, close_time, open, high, low, close, volume
+		FROM ohlcv_rollup
+		WHERE timeframe = $1 AND open_time = $2
+	`
+	rows, err := r.db.QueryContext(ctx, q, interval, start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candles []models.OHLCV
+	for rows.Next() {
+		var c models.OHLCV
+		if err := rows.Scan(&c.Symbol, &c.OpenTime, &c.CloseTime, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume); err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	return candles, rows.Err()
+}
@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last row of a page for keyset pagination.
+// ID breaks ties on Time; Tiebreak is only needed where the same ID can
+// appear twice in one listing (a self-trade shows up as maker and taker fill).
+type Cursor struct {
+	Time     time.Time
+	ID       string
+	Tiebreak string
+}
+
+// Encode returns an opaque, URL-safe representation of the cursor
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + "|" + c.ID + "|" + c.Tiebreak
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by Cursor.Encode. ID and Tiebreak,
+// when set, must be UUIDs since they are compared with uuid columns.
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || !isUUID(parts[1]) || (parts[2] != "" && !isUUID(parts[2])) {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{Time: time.Unix(0, nanos), ID: parts[1], Tiebreak: parts[2]}, nil
+}
+
+func isUUID(s string) bool {
+	_, err := uuid.Parse(s)
+	return err == nil
+}
@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+const (
+	orderID = "3f9c2a8e-6b1d-4f4e-9a57-0d2c1e5b7a10"
+	tradeID = "9b1e4c7d-2a3f-4d5e-8f60-718293a4b5c6"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"order", Cursor{Time: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC), ID: orderID}},
+		{"fill with tiebreak", Cursor{Time: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), ID: tradeID, Tiebreak: orderID}},
+		{"unix epoch", Cursor{Time: time.Unix(0, 0), ID: orderID}},
+		{"before epoch", Cursor{Time: time.Unix(-3600, 500), ID: orderID}},
+		{"non-UTC zone", Cursor{Time: time.Date(2024, 1, 1, 7, 0, 0, 0, time.FixedZone("ICT", 7*3600)), ID: tradeID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := tt.cursor.Encode()
+			if _, err := base64.RawURLEncoding.DecodeString(encoded); err != nil {
+				t.Fatalf("Encode = %q, not URL-safe base64: %v", encoded, err)
+			}
+			got, err := DecodeCursor(encoded)
+			if err != nil {
+				t.Fatalf("DecodeCursor(%q): %v", encoded, err)
+			}
+			if !got.Time.Equal(tt.cursor.Time) || got.ID != tt.cursor.ID || got.Tiebreak != tt.cursor.Tiebreak {
+				t.Errorf("DecodeCursor = %+v, want %+v", *got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("12|" + orderID + "|"))},
+		{"two parts", raw("1|" + orderID)},
+		{"four parts", raw("1|" + tradeID + "|" + orderID + "|" + orderID)},
+		{"empty id", raw("1||")},
+		{"id not a UUID", raw("1|42|")},
+		{"id injection", raw("1|" + orderID + "'::uuid OR true --|")},
+		{"tiebreak not a UUID", raw("1|" + tradeID + "|maker")},
+		{"time not a number", raw("yesterday|" + orderID + "|")},
+		{"time overflows", raw("99999999999999999999|" + orderID + "|")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := DecodeCursor(tt.cursor); err != ErrInvalidCursor {
+				t.Errorf("DecodeCursor(%q) = (%+v, %v), want ErrInvalidCursor", tt.cursor, c, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+)
+
+type DeviceRepo struct{ db *sql.DB }
+
+func NewDeviceRepo(db *sql.DB) *DeviceRepo { return &DeviceRepo{db: db} }
+
+// Seen records a login from a device and reports whether the device was new
+// to the user and whether the user had other devices before
+func (r *DeviceRepo) Seen(ctx context.Context, userID, deviceHash, ip, userAgent string) (isNew, hadOthers bool, err error) {
+	err = r.db.QueryRowContext(ctx, `
+WITH upsert AS (
+	INSERT INTO user_devices(user_id, device_hash, user_agent, ip_address)
+	VALUES($1, $2, $3, NULLIF($4, '')::inet)
+	ON CONFLICT (user_id, device_hash) DO UPDATE
+	SET last_seen_at = NOW(), user_agent = EXCLUDED.user_agent, ip_address = EXCLUDED.ip_address
+	RETURNING (xmax = 0) AS inserted
+)
+SELECT (SELECT inserted FROM upsert),
+       EXISTS (SELECT 1 FROM user_devices WHERE user_id = $1 AND device_hash <> $2)`,
+		userID, deviceHash, userAgent, ip).Scan(&isNew, &hadOthers)
+	return isNew, hadOthers, err
+}
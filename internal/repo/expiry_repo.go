@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ExpiryRepo deletes expired short-lived rows: login and passkey challenges,
+// mailed tokens, refresh tokens and stale login throttle entries
+type ExpiryRepo struct{ db *sql.DB }
+
+func NewExpiryRepo(db *sql.DB) *ExpiryRepo { return &ExpiryRepo{db: db} }
+
+// expirySweeps are the tables swept and the condition of their expired rows;
+// $1 is the retention cutoff
+var expirySweeps = []struct {
+	table string
+	where string
+}{
+	{"login_challenges", `expires_at < $1`},
+	{"webauthn_challenges", `expires_at < $1`},
+	{"account_tokens", `expires_at < $1`},
+	{"account_unlock_tokens", `expires_at < $1`},
+	{"refresh_tokens", `expires_at < $1`},
+	{"login_throttle", `window_started_at < $1 AND (blocked_until IS NULL OR blocked_until < NOW())`},
+}
+
+// DeleteExpired deletes rows that expired before cutoff and returns the
+// count per table
+func (r *ExpiryRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (map[string]int64, error) {
+	deleted := make(map[string]int64, len(expirySweeps))
+	for _, sweep := range expirySweeps {
+		res, err := r.db.ExecContext(ctx, `DELETE FROM `+sweep.table+` WHERE `+sweep.where, cutoff)
+		if err != nil {
+			return deleted, fmt.Errorf("sweeping %s: %w", sweep.table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted[sweep.table] = n
+	}
+	return deleted, nil
+}
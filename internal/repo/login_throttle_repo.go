@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LoginThrottleRepo stores login failures and blocks in Postgres, for
+// deployments without Redis, and the account unlock tokens
+type LoginThrottleRepo struct{ db *sql.DB }
+
+func NewLoginThrottleRepo(db *sql.DB) *LoginThrottleRepo { return &LoginThrottleRepo{db: db} }
+
+// AddFailure counts a failure of a key and returns the failures within the
+// window; a window that has passed starts over
+func (r *LoginThrottleRepo) AddFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+INSERT INTO login_throttle(key, failures, window_started_at)
+VALUES($1, 1, NOW())
+ON CONFLICT (key) DO UPDATE SET
+	failures = CASE WHEN login_throttle.window_started_at < NOW() - make_interval(secs => $2)
+		THEN 1 ELSE login_throttle.failures + 1 END,
+	window_started_at = CASE WHEN login_throttle.window_started_at < NOW() - make_interval(secs => $2)
+		THEN NOW() ELSE login_throttle.window_started_at END
+RETURNING failures`, key, window.Seconds()).Scan(&n)
+	return n, err
+}
+
+// Block blocks a key until a time; locked marks a lockout rather than a delay
+func (r *LoginThrottleRepo) Block(ctx context.Context, key string, until time.Time, locked bool) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO login_throttle(key, blocked_until, locked)
+VALUES($1, $2, $3)
+ON CONFLICT (key) DO UPDATE SET blocked_until = $2, locked = $3`, key, until, locked)
+	return err
+}
+
+// Blocked returns the block of a key, a zero time when there is none
+func (r *LoginThrottleRepo) Blocked(ctx context.Context, key string) (time.Time, bool, error) {
+	var (
+		until  time.Time
+		locked bool
+	)
+	err := r.db.QueryRowContext(ctx, `
+SELECT blocked_until, locked
+FROM login_throttle
+WHERE key = $1 AND blocked_until > NOW()`, key).Scan(&until, &locked)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	return until, locked, err
+}
+
+// Reset clears the failures and block of a key
+func (r *LoginThrottleRepo) Reset(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_throttle WHERE key = $1`, key)
+	return err
+}
+
+// InsertUnlockToken stores an unlock token of a locked account
+func (r *LoginThrottleRepo) InsertUnlockToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO account_unlock_tokens(token_hash, user_id, expires_at)
+VALUES($1, $2, $3)`, tokenHash, userID, expiresAt)
+	return err
+}
+
+// UseUnlockToken marks a valid unlock token as used and returns its user;
+// sql.ErrNoRows when unknown, used or expired
+func (r *LoginThrottleRepo) UseUnlockToken(ctx context.Context, tokenHash string) (string, error) {
+	var userID string
+	err := r.db.QueryRowContext(ctx, `
+UPDATE account_unlock_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id::text`, tokenHash).Scan(&userID)
+	return userID, err
+}
@@ -7,8 +7,12 @@ import (
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
 )
 
-type MarketRepo struct{ db *sql.DB }
-func NewMarketRepo(db *sql.DB) *MarketRepo { return &MarketRepo{db: db} }
+type MarketRepo struct {
+	db        *sql.DB
+	candleLoc *time.Location // Day boundary of 1D, 1W and 1M candles
+}
+
+func NewMarketRepo(db *sql.DB) *MarketRepo { return &MarketRepo{db: db, candleLoc: time.UTC} }
 
 func (r *MarketRepo) GetByID(ctx context.Context, tx *sql.Tx, id string) (*models.Market, error) {
 	q := `SELECT id, symbol, base_asset_id, quote_asset_id FROM markets WHERE id=$1`
@@ -21,6 +25,19 @@ func (r *MarketRepo) GetByID(ctx context.Context, tx *sql.Tx, id string) (*model
 	return &m, nil
 }
 
+// GetBySymbol returns an active market by its symbol
+func (r *MarketRepo) GetBySymbol(ctx context.Context, symbol string) (*models.Market, error) {
+	q := `SELECT id, symbol, base_asset_id, quote_asset_id, min_price, max_price, tick_size, min_notional FROM markets WHERE symbol = $1 AND is_active = true`
+	row := r.db.QueryRowContext(ctx, q, symbol)
+
+	var m models.Market
+	if err := row.Scan(&m.ID, &m.Symbol, &m.BaseAssetID, &m.QuoteAssetID, &m.MinPrice, &m.MaxPrice, &m.TickSize, &m.MinNotional); err != nil {
+		return nil, err
+	}
+	m.IsActive = true
+	return &m, nil
+}
+
 // GetAllActiveMarkets retrieves all active markets
 func (r *MarketRepo) GetAllActiveMarkets(ctx context.Context) ([]models.Market, error) {
 	q := `SELECT id, symbol, base_asset_id, quote_asset_id, min_price, max_price, tick_size, min_notional FROM markets WHERE is_active = true`
@@ -52,23 +69,49 @@ func (r *MarketRepo) ValidateSymbol(ctx context.Context, symbol string) (bool, e
 	return exists, nil
 }
 
-// GetLatestTrades gets recent trades for candle aggregation
-func (r *MarketRepo) GetLatestTrades(ctx context.Context, since time.Time) ([]Trade, error) {
+// GetTradesAfterSeq returns trades with a sequence number above afterSeq, in
+// sequence order, including those of inactive markets so the sequence has no
+// holes; MarketActive tells them apart
+func (r *MarketRepo) GetTradesAfterSeq(ctx context.Context, afterSeq int64, limit int) ([]Trade, error) {
 	q := `
 		SELECT 
+			t.seq,
 			m.symbol,
 			t.price,
 			t.amount,
 			t.quote_amount,
-			t.trade_time
+			t.trade_time,
+			m.is_active
 		FROM trades t
 		JOIN markets m ON t.market_id = m.id
-		WHERE t.trade_time >= $1
-		AND m.is_active = true
-		ORDER BY t.trade_time ASC
+		WHERE t.seq > $1
+		ORDER BY t.seq ASC
+		LIMIT $2
+	`
+	return r.queryTrades(ctx, q, afterSeq, limit)
+}
+
+// GetTradesBySeq returns the trades with the given sequence numbers, in sequence order
+func (r *MarketRepo) GetTradesBySeq(ctx context.Context, seqs []int64) ([]Trade, error) {
+	q := `
+		SELECT 
+			t.seq,
+			m.symbol,
+			t.price,
+			t.amount,
+			t.quote_amount,
+			t.trade_time,
+			m.is_active
+		FROM trades t
+		JOIN markets m ON t.market_id = m.id
+		WHERE t.seq = ANY($1)
+		ORDER BY t.seq ASC
 	`
+	return r.queryTrades(ctx, q, seqs)
+}
 
-	rows, err := r.db.QueryContext(ctx, q, since)
+func (r *MarketRepo) queryTrades(ctx context.Context, q string, args ...interface{}) ([]Trade, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -78,11 +121,13 @@ func (r *MarketRepo) GetLatestTrades(ctx context.Context, since time.Time) ([]Tr
 	for rows.Next() {
 		var trade Trade
 		if err := rows.Scan(
+			&trade.Seq,
 			&trade.Symbol,
 			&trade.Price,
 			&trade.Amount,
 			&trade.QuoteAmount,
 			&trade.TradeTime,
+			&trade.MarketActive,
 		); err != nil {
 			return nil, err
 		}
@@ -91,20 +136,165 @@ func (r *MarketRepo) GetLatestTrades(ctx context.Context, since time.Time) ([]Tr
 	return trades, rows.Err()
 }
 
-// SaveOHLCV saves completed candle to database
-func (r *MarketRepo) SaveOHLCV(ctx context.Context, candle *models.OHLCV) error {
+// GetMaxTradeSeq returns the highest committed trade sequence number (0 if none)
+func (r *MarketRepo) GetMaxTradeSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM trades`).Scan(&seq)
+	return seq, err
+}
+
+// GetFirstTradeTime returns the time of the first trade of a symbol (nil if it never traded)
+func (r *MarketRepo) GetFirstTradeTime(ctx context.Context, symbol string) (*time.Time, error) {
 	q := `
+		SELECT MIN(t.trade_time)
+		FROM trades t
+		JOIN markets m ON t.market_id = m.id
+		WHERE m.symbol = $1
+	`
+	var first sql.NullTime
+	if err := r.db.QueryRowContext(ctx, q, symbol).Scan(&first); err != nil {
+		return nil, err
+	}
+	if !first.Valid {
+		return nil, nil
+	}
+	return &first.Time, nil
+}
+
+// GetLastTradePriceBefore returns the price of the last trade of a symbol before t (nil if none)
+func (r *MarketRepo) GetLastTradePriceBefore(ctx context.Context, symbol string, t time.Time) (*float64, error) {
+	q := `
+		SELECT t.price
+		FROM trades t
+		JOIN markets m ON t.market_id = m.id
+		WHERE m.symbol = $1 AND t.trade_time < $2
+		ORDER BY t.trade_time DESC, t.seq DESC
+		LIMIT 1
+	`
+	var price float64
+	err := r.db.QueryRowContext(ctx, q, symbol, t).Scan(&price)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &price, nil
+}
+
+// AggregateTrades1m builds 1-minute candles from the trades table for minutes in [from, to).
+// An empty symbol aggregates all active markets; maxSeq > 0 ignores later trades.
+// Minutes without trades are not returned.
+func (r *MarketRepo) AggregateTrades1m(ctx context.Context, symbol string, from, to time.Time, maxSeq int64) ([]models.OHLCV, error) {
+	q := `
+		SELECT
+			m.symbol,
+			date_trunc('minute', t.trade_time) AS minute,
+			(array_agg(t.price ORDER BY t.seq ASC))[1],
+			MAX(t.price),
+			MIN(t.price),
+			(array_agg(t.price ORDER BY t.seq DESC))[1],
+			SUM(t.quote_amount),
+			MAX(t.seq)
+		FROM trades t
+		JOIN markets m ON t.market_id = m.id
+		WHERE t.trade_time >= $1 AND t.trade_time < $2
+		AND ($3::text = '' OR m.symbol = $3)
+		AND ($4::bigint = 0 OR t.seq <= $4)
+		AND m.is_active = true
+		GROUP BY m.symbol, minute
+		ORDER BY m.symbol, minute ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, q, from, to, symbol, maxSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candles []models.OHLCV
+	for rows.Next() {
+		var c models.OHLCV
+		if err := rows.Scan(&c.Symbol, &c.OpenTime, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume, &c.LastTradeSeq); err != nil {
+			return nil, err
+		}
+		c.CloseTime = c.OpenTime.Add(time.Minute)
+		candles = append(candles, c)
+	}
+	return candles, rows.Err()
+}
+
+// GetLastCandleBefore returns the latest saved 1m candle opening before t (nil if none)
+func (r *MarketRepo) GetLastCandleBefore(ctx context.Context, symbol string, t time.Time) (*models.OHLCV, error) {
+	candles, err := r.get1mCandles(ctx, symbol, 1, &t)
+	if err != nil || len(candles) == 0 {
+		return nil, err
+	}
+	return &candles[0], nil
+}
+
+// Get1mCandlesRange returns the saved 1m candles of a symbol opening in [from, to), oldest first
+func (r *MarketRepo) Get1mCandlesRange(ctx context.Context, symbol string, from, to time.Time) ([]models.OHLCV, error) {
+	q := `
+		SELECT symbol, open_time, close_time, open, high, low, close, volume
+		FROM ohlcv_1m
+		WHERE symbol = $1 AND open_time >= $2 AND open_time < $3
+		ORDER BY open_time ASC
+	`
+	rows, err := r.db.QueryContext(ctx, q, symbol, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candles []models.OHLCV
+	for rows.Next() {
+		var c models.OHLCV
+		if err := rows.Scan(&c.Symbol, &c.OpenTime, &c.CloseTime, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume); err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	return candles, rows.Err()
+}
+
+// SaveOHLCVBatch saves several 1m candles in one transaction (idempotent upsert)
+func (r *MarketRepo) SaveOHLCVBatch(ctx context.Context, candles []models.OHLCV) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, saveOHLCVQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range candles {
+		if _, err := stmt.ExecContext(ctx, c.Symbol, c.OpenTime, c.CloseTime, c.Open, c.High, c.Low, c.Close, c.Volume); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const saveOHLCVQuery = `
 		INSERT INTO ohlcv_1m (symbol, open_time, close_time, open, high, low, close, volume)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (symbol, open_time) DO UPDATE SET
 			close_time = EXCLUDED.close_time,
+			open = EXCLUDED.open,
 			high = EXCLUDED.high,
 			low = EXCLUDED.low,
 			close = EXCLUDED.close,
 			volume = EXCLUDED.volume
 	`
 
-	_, err := r.db.ExecContext(ctx, q,
+// SaveOHLCV saves completed candle to database
+func (r *MarketRepo) SaveOHLCV(ctx context.Context, candle *models.OHLCV) error {
+	_, err := r.db.ExecContext(ctx, saveOHLCVQuery,
 		candle.Symbol,
 		candle.OpenTime,
 		candle.CloseTime,
@@ -117,39 +307,18 @@ func (r *MarketRepo) SaveOHLCV(ctx context.Context, candle *models.OHLCV) error
 	return err
 }
 
-// GetCandles retrieves historical candles and aggregates based on interval
+// GetCandles retrieves historical candles, newest first. 1m candles are read
+// directly; larger intervals come from the materialized rollups.
 func (r *MarketRepo) GetCandles(ctx context.Context, symbol string, interval string, limit int, endTime *time.Time) ([]models.OHLCV, error) {
-	// Map interval to minutes
-	intervalMap := map[string]int{
-		"1m":  1,
-		"5m":  5,
-		"15m": 15,
-		"1h":  60,
-		"4h":  240,
-		"1D":  1440,
-	}
-
-	intervalMinutes, ok := intervalMap[interval]
-	if !ok {
-		// Default to 1m if invalid interval
-		intervalMinutes = 1
-	}
-
-	// If 1m requested, query directly
-	if intervalMinutes == 1 {
+	if interval == "1m" {
 		return r.get1mCandles(ctx, symbol, limit, endTime)
 	}
 
-	// For other intervals, fetch more 1m candles and aggregate
-	// We need (limit * intervalMinutes) 1m candles to create 'limit' aggregated candles
-	requiredCandles := limit * intervalMinutes
-	candles1m, err := r.get1mCandles(ctx, symbol, requiredCandles, endTime)
-	if err != nil {
-		return nil, err
+	iv, ok := findCandleInterval(interval)
+	if !ok {
+		return nil, ErrUnsupportedInterval
 	}
-
-	// Aggregate candles
-	return r.aggregateCandles(candles1m, intervalMinutes, limit), nil
+	return r.getRollupCandles(ctx, symbol, iv, limit, endTime)
 }
 
 // get1mCandles fetches 1-minute candles from database
@@ -194,77 +363,13 @@ func (r *MarketRepo) get1mCandles(ctx context.Context, symbol string, limit int,
 	return candles, rows.Err()
 }
 
-// aggregateCandles aggregates 1m candles into larger intervals
-func (r *MarketRepo) aggregateCandles(candles1m []models.OHLCV, intervalMinutes int, limit int) []models.OHLCV {
-	if len(candles1m) == 0 {
-		return []models.OHLCV{}
-	}
-
-	// Reverse to process in chronological order (oldest first)
-	for i, j := 0, len(candles1m)-1; i < j; i, j = i+1, j-1 {
-		candles1m[i], candles1m[j] = candles1m[j], candles1m[i]
-	}
-
-	var aggregated []models.OHLCV
-	intervalDuration := time.Duration(intervalMinutes) * time.Minute
-
-	for i := 0; i < len(candles1m); {
-		// Start of this interval (truncate to interval boundary)
-		startTime := candles1m[i].OpenTime.Truncate(intervalDuration)
-		endTime := startTime.Add(intervalDuration)
-
-		// Aggregate all 1m candles in this interval
-		var bucket []models.OHLCV
-		for i < len(candles1m) && candles1m[i].OpenTime.Before(endTime) {
-			bucket = append(bucket, candles1m[i])
-			i++
-		}
-
-		if len(bucket) > 0 {
-			agg := models.OHLCV{
-				Symbol:    bucket[0].Symbol,
-				OpenTime:  startTime,
-				CloseTime: endTime,
-				Open:      bucket[0].Open,
-				Close:     bucket[len(bucket)-1].Close,
-				High:      bucket[0].High,
-				Low:       bucket[0].Low,
-				Volume:    0,
-			}
-
-			// Calculate high, low, volume
-			for _, c := range bucket {
-				if c.High > agg.High {
-					agg.High = c.High
-				}
-				if c.Low < agg.Low {
-					agg.Low = c.Low
-				}
-				agg.Volume += c.Volume
-			}
-
-			aggregated = append(aggregated, agg)
-
-			// Stop if we have enough candles
-			if len(aggregated) >= limit {
-				break
-			}
-		}
-	}
-
-	// Reverse back to descending order (newest first)
-	for i, j := 0, len(aggregated)-1; i < j; i, j = i+1, j-1 {
-		aggregated[i], aggregated[j] = aggregated[j], aggregated[i]
-	}
-
-	return aggregated
-}
-
 // Trade represents a trade for candle aggregation
 type Trade struct {
-	Symbol      string
-	Price       float64
-	Amount      float64
-	QuoteAmount float64
-	TradeTime   time.Time
+	Seq          int64
+	Symbol       string
+	Price        float64
+	Amount       float64
+	QuoteAmount  float64
+	TradeTime    time.Time
+	MarketActive bool
 }
@@ -2,6 +2,8 @@ package repo
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"time"
 	"database/sql"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
@@ -12,30 +14,74 @@ func NewOrderRepo(db *sql.DB) *OrderRepo { return &OrderRepo{db: db} }
 
 func (r *OrderRepo) Insert(ctx context.Context, tx *sql.Tx, o *models.Order) error {
 	q := `
-INSERT INTO orders(user_id, market_id, side, type, price, amount, filled_amount, quote_amount_max, status, fee, tif)
-VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+INSERT INTO orders(user_id, market_id, side, type, price, amount, filled_amount, quote_amount_max, status, fee, tif, client_order_id)
+VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
 RETURNING id, created_at, updated_at`
 	return tx.QueryRowContext(ctx, q,
 		o.UserID, o.MarketID, o.Side, o.Type, o.Price,
-		o.Amount, o.FilledAmount, o.QuoteAmountMax, o.Status, o.Fee, o.TIF,
+		o.Amount, o.FilledAmount, o.QuoteAmountMax, o.Status, o.Fee, o.TIF, o.ClientOrderID,
 	).Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
 }
 
 func (r *OrderRepo) GetByIDForUpdate(ctx context.Context, tx *sql.Tx, id string) (*models.Order, error) {
 	q := `
-SELECT id,user_id,market_id,side,type,price,amount,filled_amount,quote_amount_max,status,fee,tif,created_at,updated_at,canceled_at
+SELECT id,user_id,market_id,side,type,price,amount,filled_amount,quote_amount_max,status,fee,tif,client_order_id,created_at,updated_at,canceled_at
 FROM orders WHERE id=$1 FOR UPDATE`
 	row := tx.QueryRowContext(ctx, q, id)
 
 	var o models.Order
 	if err := row.Scan(&o.ID, &o.UserID, &o.MarketID, &o.Side, &o.Type, &o.Price,
-			&o.Amount, &o.FilledAmount, &o.QuoteAmountMax, &o.Status, &o.Fee, &o.TIF,
+			&o.Amount, &o.FilledAmount, &o.QuoteAmountMax, &o.Status, &o.Fee, &o.TIF, &o.ClientOrderID,
 			&o.CreatedAt, &o.UpdatedAt, &o.CanceledAt); err != nil {
 		return nil, err
 	}
 	return &o, nil
 }
 
+// GetByID returns a single order with its market symbol (no row lock)
+func (r *OrderRepo) GetByID(ctx context.Context, id string) (*models.Order, error) {
+	q := `
+SELECT o.id, o.user_id, o.market_id, m.symbol, o.side, o.type, o.price, o.amount, o.filled_amount, o.quote_amount_max, o.status, o.fee, o.tif, o.client_order_id, o.created_at, o.updated_at, o.canceled_at
+FROM orders o
+JOIN markets m ON o.market_id = m.id
+WHERE o.id = $1`
+	row := r.db.QueryRowContext(ctx, q, id)
+
+	var o models.Order
+	if err := row.Scan(&o.ID, &o.UserID, &o.MarketID, &o.Symbol, &o.Side, &o.Type, &o.Price,
+		&o.Amount, &o.FilledAmount, &o.QuoteAmountMax, &o.Status, &o.Fee, &o.TIF, &o.ClientOrderID,
+		&o.CreatedAt, &o.UpdatedAt, &o.CanceledAt); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// GetByIDs returns the given orders with their market symbols, in no particular order
+func (r *OrderRepo) GetByIDs(ctx context.Context, ids []string) ([]*models.Order, error) {
+	q := `
+SELECT o.id, o.user_id, o.market_id, m.symbol, o.side, o.type, o.price, o.amount, o.filled_amount, o.quote_amount_max, o.status, o.fee, o.tif, o.client_order_id, o.created_at, o.updated_at, o.canceled_at
+FROM orders o
+JOIN markets m ON o.market_id = m.id
+WHERE o.id = ANY($1::uuid[])`
+	rows, err := r.db.QueryContext(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	for rows.Next() {
+		var o models.Order
+		if err := rows.Scan(&o.ID, &o.UserID, &o.MarketID, &o.Symbol, &o.Side, &o.Type, &o.Price,
+			&o.Amount, &o.FilledAmount, &o.QuoteAmountMax, &o.Status, &o.Fee, &o.TIF, &o.ClientOrderID,
+			&o.CreatedAt, &o.UpdatedAt, &o.CanceledAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, &o)
+	}
+	return orders, rows.Err()
+}
+
 func (r *OrderRepo) UpdateFill(ctx context.Context, tx *sql.Tx, id string, newFilled float64, newStatus models.OrderStatus) error {
 	q := `UPDATE orders SET filled_amount=$2, status=$3, updated_at=NOW() WHERE id=$1`
 	_, err := tx.ExecContext(ctx, q, id, newFilled, newStatus)
@@ -48,46 +94,102 @@ func (r *OrderRepo) Cancel(ctx context.Context, tx *sql.Tx, id string) error {
 	return err
 }
 
-func (r *OrderRepo) GetByUserID(ctx context.Context, userID string, status string) ([]*models.Order, error) {
-	var q string
-	var args []interface{}
-
-	if status != "" {
-		q = `SELECT o.id, o.user_id, o.market_id, m.symbol, o.side, o.type, o.price, o.amount, o.filled_amount, o.quote_amount_max, o.status, o.fee, o.tif, o.created_at, o.updated_at, o.canceled_at
-			FROM orders o
-			JOIN markets m ON o.market_id = m.id
-			WHERE o.user_id = $1 AND o.status = $2
-			 ORDER BY created_at DESC
-			 LIMIT 100`
-		args = []interface{}{userID, status}
-	} else {
-		q = `SELECT o.id, o.user_id, o.market_id, m.symbol, o.side, o.type, o.price, o.amount, o.filled_amount, o.quote_amount_max, o.status, o.fee, o.tif, o.created_at, o.updated_at, o.canceled_at
-			 FROM orders o
-			 JOIN markets m ON o.market_id = m.id
-			 WHERE o.user_id = $1
-			 ORDER BY created_at DESC
-			 LIMIT 100`
-		args = []interface{}{userID}
+// OrderFilter narrows the order history returned by GetByUserID.
+// Zero values mean "no filter" for that field.
+type OrderFilter struct {
+	Symbol        string
+	MarketID      string
+	Side          models.OrderSide
+	Type          models.OrderType
+	Statuses      []models.OrderStatus
+	From          *time.Time // created_at >= From
+	To            *time.Time // created_at < To
+	ClientOrderID string
+	Cursor        *Cursor
+	Limit         int
+}
+
+// GetByUserID returns one page of a user's orders, newest first.
+// The returned cursor is nil when there are no more rows.
+func (r *OrderRepo) GetByUserID(ctx context.Context, userID string, f OrderFilter) ([]*models.Order, *Cursor, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	q := `SELECT o.id, o.user_id, o.market_id, m.symbol, o.side, o.type, o.price, o.amount, o.filled_amount, o.quote_amount_max, o.status, o.fee, o.tif, o.client_order_id, o.created_at, o.updated_at, o.canceled_at
+		FROM orders o
+		JOIN markets m ON o.market_id = m.id
+		WHERE o.user_id = $1`
+	args := []interface{}{userID}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Symbol != "" {
+		q += ` AND m.symbol = ` + arg(f.Symbol)
+	}
+	if f.MarketID != "" {
+		q += ` AND o.market_id = ` + arg(f.MarketID)
+	}
+	if f.Side != "" {
+		q += ` AND o.side = ` + arg(f.Side)
+	}
+	if f.Type != "" {
+		q += ` AND o.type = ` + arg(f.Type)
+	}
+	if len(f.Statuses) > 0 {
+		statuses := make([]string, len(f.Statuses))
+		for i, st := range f.Statuses {
+			statuses[i] = string(st)
+		}
+		q += ` AND o.status::text = ANY(` + arg(statuses) + `)`
+	}
+	if f.From != nil {
+		q += ` AND o.created_at >= ` + arg(*f.From)
+	}
+	if f.To != nil {
+		q += ` AND o.created_at < ` + arg(*f.To)
+	}
+	if f.ClientOrderID != "" {
+		q += ` AND o.client_order_id = ` + arg(f.ClientOrderID)
+	}
+	if f.Cursor != nil {
+		q += ` AND (o.created_at, o.id) < (` + arg(f.Cursor.Time) + `, ` + arg(f.Cursor.ID) + `::uuid)`
 	}
 
+	// Fetch one extra row to know whether another page exists
+	q += ` ORDER BY o.created_at DESC, o.id DESC LIMIT ` + arg(f.Limit+1)
+
 	rows, err := r.db.QueryContext(ctx, q, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
-	var orders []*models.Order
+	orders := []*models.Order{}
 	for rows.Next() {
 		var o models.Order
 		if err := rows.Scan(&o.ID, &o.UserID, &o.MarketID, &o.Symbol, &o.Side, &o.Type, &o.Price,
-			&o.Amount, &o.FilledAmount, &o.QuoteAmountMax, &o.Status, &o.Fee, &o.TIF,
+			&o.Amount, &o.FilledAmount, &o.QuoteAmountMax, &o.Status, &o.Fee, &o.TIF, &o.ClientOrderID,
 			&o.CreatedAt, &o.UpdatedAt, &o.CanceledAt); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		orders = append(orders, &o)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-	return orders, rows.Err()
+	var next *Cursor
+	if len(orders) > f.Limit {
+		orders = orders[:f.Limit]
+		last := orders[len(orders)-1]
+		next = &Cursor{Time: last.CreatedAt, ID: last.ID}
+	}
+
+	return orders, next, nil
 }
 
 
@@ -210,12 +312,115 @@ func (r *OrderRepo) GetOrderBook(ctx context.Context, marketID string, limit int
 	return orderbook, nil
 }
 
+// GetFullOrderBook retrieves every price level of a market's book, bypassing the cache
+func (r *OrderRepo) GetFullOrderBook(ctx context.Context, marketID string) (*OrderBook, error) {
+	return r.GetOrderBook(ctx, marketID, math.MaxInt32, nil)
+}
+
+// GetBookLevels returns the resting amount at the given prices of one book side.
+// Prices without resting orders are omitted.
+func (r *OrderRepo) GetBookLevels(ctx context.Context, marketID string, side models.OrderSide, prices []float64) (map[float64]float64, error) {
+	q := `
+		SELECT price, SUM(amount - filled_amount) as total_amount
+		FROM orders
+		WHERE market_id = $1
+			AND side = $2
+			AND type = 'limit'
+			AND status IN ('open', 'partially_filled')
+			AND tif IN ('GTC', 'POST_ONLY')
+			AND price = ANY($3)
+		GROUP BY price
+	`
+	rows, err := r.db.QueryContext(ctx, q, marketID, string(side), prices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := make(map[float64]float64, len(prices))
+	for rows.Next() {
+		var price, amount float64
+		if err := rows.Scan(&price, &amount); err != nil {
+			return nil, err
+		}
+		levels[price] = amount
+	}
+	return levels, rows.Err()
+}
+
+// BookOrder is the order book state of one limit order
+type BookOrder struct {
+	ID        string
+	MarketID  string
+	Side      models.OrderSide
+	Price     float64
+	Amount    float64
+	Filled    float64
+	Status    models.OrderStatus
+	Resting   bool // Open or partially filled and resting on the book
+	CreatedAt time.Time
+}
+
+// Remaining returns the unfilled amount
+func (o *BookOrder) Remaining() float64 { return o.Amount - o.Filled }
+
+const bookOrderColumns = `
+		id, market_id, side, price, amount, filled_amount, status, created_at,
+		(status IN ('open', 'partially_filled') AND tif IN ('GTC', 'POST_ONLY')) AS resting
+`
+
+// GetRestingOrders returns the limit orders resting on a market's book in priority order
+func (r *OrderRepo) GetRestingOrders(ctx context.Context, marketID string) ([]BookOrder, error) {
+	q := `
+		SELECT ` + bookOrderColumns + `
+		FROM orders
+		WHERE market_id = $1
+			AND type = 'limit'
+			AND status IN ('open', 'partially_filled')
+			AND tif IN ('GTC', 'POST_ONLY')
+			AND price IS NOT NULL
+		ORDER BY created_at ASC, id ASC
+	`
+	return r.queryBookOrders(ctx, q, marketID)
+}
+
+// GetBookOrders returns the book state of the given limit orders, resting or not
+func (r *OrderRepo) GetBookOrders(ctx context.Context, ids []string) ([]BookOrder, error) {
+	q := `
+		SELECT ` + bookOrderColumns + `
+		FROM orders
+		WHERE id = ANY($1::uuid[])
+			AND type = 'limit'
+			AND price IS NOT NULL
+		ORDER BY created_at ASC, id ASC
+	`
+	return r.queryBookOrders(ctx, q, ids)
+}
+
+func (r *OrderRepo) queryBookOrders(ctx context.Context, q string, args ...interface{}) ([]BookOrder, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []BookOrder
+	for rows.Next() {
+		var o BookOrder
+		if err := rows.Scan(&o.ID, &o.MarketID, &o.Side, &o.Price, &o.Amount, &o.Filled, &o.Status, &o.CreatedAt, &o.Resting); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
 // Select makers for matching (locked + skip locked)
 func (r *OrderRepo) SelectMakersForUpdate(ctx context.Context, tx *sql.Tx, marketID string, takerSide models.OrderSide, takerType models.OrderType, takerPrice *float64, limit int) ([]*models.Order, error) {
 	var q string
 	if takerSide == models.Buy {
 		q = `
-		SELECT id,user_id,market_id,side,type,price,amount,filled_amount,quote_amount_max,status,fee,tif,created_at,updated_at,canceled_at
+		SELECT id,user_id,market_id,side,type,price,amount,filled_amount,quote_amount_max,status,fee,tif,client_order_id,created_at,updated_at,canceled_at
 		FROM orders
 		WHERE market_id=$1 AND side='sell' AND type='limit'
 		AND status IN ('open','partially_filled')
@@ -225,7 +430,7 @@ func (r *OrderRepo) SelectMakersForUpdate(ctx context.Context, tx *sql.Tx, marke
 		LIMIT $4`
 	} else {
 		q = `
-		SELECT id,user_id,market_id,side,type,price,amount,filled_amount,quote_amount_max,status,fee,tif,created_at,updated_at,canceled_at
+		SELECT id,user_id,market_id,side,type,price,amount,filled_amount,quote_amount_max,status,fee,tif,client_order_id,created_at,updated_at,canceled_at
 		FROM orders
 		WHERE market_id=$1 AND side='buy' AND type='limit'
 		AND status IN ('open','partially_filled')
@@ -246,7 +451,7 @@ func (r *OrderRepo) SelectMakersForUpdate(ctx context.Context, tx *sql.Tx, marke
 	for rows.Next() {
 		var o models.Order
 		if err := rows.Scan(&o.ID,&o.UserID,&o.MarketID,&o.Side,&o.Type,&o.Price,
-				&o.Amount,&o.FilledAmount,&o.QuoteAmountMax,&o.Status,&o.Fee,&o.TIF,
+				&o.Amount,&o.FilledAmount,&o.QuoteAmountMax,&o.Status,&o.Fee,&o.TIF,&o.ClientOrderID,
 				&o.CreatedAt,&o.UpdatedAt,&o.CanceledAt); err != nil {
 			return nil, err
 		}
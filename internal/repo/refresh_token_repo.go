@@ -0,0 +1,172 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RefreshTokenRepo struct{ db *sql.DB }
+
+func NewRefreshTokenRepo(db *sql.DB) *RefreshTokenRepo { return &RefreshTokenRepo{db: db} }
+
+// RefreshToken is a stored refresh token, identified by the hash of its value
+type RefreshToken struct {
+	UserID    string
+	FamilyID  string
+	ExpiresAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
+
+// Insert stores a token of a family, or of a new family when familyID is
+// empty, and returns the family
+func (r *RefreshTokenRepo) Insert(ctx context.Context, userID, familyID, tokenHash, ip, userAgent string, expiresAt time.Time) (string, error) {
+	var family string
+	err := r.db.QueryRowContext(ctx, `
+INSERT INTO refresh_tokens(user_id, token_hash, ip_address, user_agent, expires_at, family_id)
+VALUES($1, $2, NULLIF($3, '')::inet, $4, $5, COALESCE(NULLIF($6, '')::uuid, gen_random_uuid()))
+RETURNING family_id::text`, userID, tokenHash, ip, userAgent, expiresAt, familyID).Scan(&family)
+	return family, err
+}
+
+func (r *RefreshTokenRepo) Get(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	var t RefreshToken
+	err := r.db.QueryRowContext(ctx, `
+SELECT user_id::text, family_id::text, expires_at, rotated_at, revoked_at
+FROM refresh_tokens
+WHERE token_hash = $1`, tokenHash).Scan(&t.UserID, &t.FamilyID, &t.ExpiresAt, &t.RotatedAt, &t.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Rotate marks a live token as rotated and stores its replacement in the same
+// family. It reports false when the token was rotated or revoked meanwhile.
+func (r *RefreshTokenRepo) Rotate(ctx context.Context, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var userID, familyID string
+	err = tx.QueryRowContext(ctx, `
+UPDATE refresh_tokens
+SET rotated_at = NOW()
+WHERE token_hash = $1 AND rotated_at IS NULL AND revoked_at IS NULL
+RETURNING user_id::text, family_id::text`, oldHash).Scan(&userID, &familyID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO refresh_tokens(user_id, token_hash, ip_address, user_agent, expires_at, family_id)
+VALUES($1, $2, NULLIF($3, '')::inet, $4, $5, $6)`, userID, newHash, ip, userAgent, expiresAt, familyID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RevokeFamily revokes every token of a family and returns the family when it
+// was not revoked yet
+func (r *RefreshTokenRepo) RevokeFamily(ctx context.Context, familyID, reason string) ([]string, error) {
+	return revokedFamilies(r.db.QueryContext(ctx, `
+UPDATE refresh_tokens
+SET revoked_at = NOW(), revoked_reason = $2
+WHERE family_id = $1 AND revoked_at IS NULL
+RETURNING family_id::text`, familyID, reason))
+}
+
+// RevokeUser revokes every token of the user, except the family exceptFamily
+// when set, and returns the families that were not revoked yet
+func (r *RefreshTokenRepo) RevokeUser(ctx context.Context, userID, exceptFamily, reason string) ([]string, error) {
+	return revokedFamilies(r.db.QueryContext(ctx, `
+UPDATE refresh_tokens
+SET revoked_at = NOW(), revoked_reason = $3
+WHERE user_id = $1 AND revoked_at IS NULL
+  AND ($2 = '' OR family_id <> NULLIF($2, '')::uuid)
+RETURNING family_id::text`, userID, exceptFamily, reason))
+}
+
+// FamilyRevoked reports whether a family has no unrevoked token left
+func (r *RefreshTokenRepo) FamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRowContext(ctx, `
+SELECT NOT EXISTS (
+	SELECT 1 FROM refresh_tokens WHERE family_id = $1::uuid AND revoked_at IS NULL
+)`, familyID).Scan(&revoked)
+	return revoked, err
+}
+
+// revokedFamilies collects the distinct families of revoked token rows
+func revokedFamilies(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	families := []string{}
+	for rows.Next() {
+		var family string
+		if err := rows.Scan(&family); err != nil {
+			return nil, err
+		}
+		if !seen[family] {
+			seen[family] = true
+			families = append(families, family)
+		}
+	}
+	return families, rows.Err()
+}
+
+// SessionRow is a live token family: its login time and the client of its
+// latest token
+type SessionRow struct {
+	FamilyID   string
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time // Login
+	LastUsedAt time.Time // Latest refresh
+	ExpiresAt  time.Time
+}
+
+// ListSessions returns the user's live families, most recently used first
+func (r *RefreshTokenRepo) ListSessions(ctx context.Context, userID string) ([]SessionRow, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT t.family_id::text, COALESCE(host(t.ip_address), ''), t.user_agent,
+       (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id),
+       t.created_at, t.expires_at
+FROM refresh_tokens t
+WHERE t.user_id = $1 AND t.revoked_at IS NULL AND t.rotated_at IS NULL AND t.expires_at > NOW()
+ORDER BY t.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionRow{}
+	for rows.Next() {
+		var s SessionRow
+		if err := rows.Scan(&s.FamilyID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeUserFamily revokes a family of the user and returns it when it was
+// not revoked yet
+func (r *RefreshTokenRepo) RevokeUserFamily(ctx context.Context, userID, familyID, reason string) ([]string, error) {
+	return revokedFamilies(r.db.QueryContext(ctx, `
+UPDATE refresh_tokens
+SET revoked_at = NOW(), revoked_reason = $3
+WHERE user_id = $1 AND family_id = $2::uuid AND revoked_at IS NULL
+RETURNING family_id::text`, userID, familyID, reason))
+}
@@ -3,6 +3,10 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
 )
 
@@ -13,70 +17,486 @@ func (r *TradeRepo) Insert(ctx context.Context, tx *sql.Tx, t *models.Trade) err
 	q := `
 		INSERT INTO trades(market_id, maker_order_id, taker_order_id, taker_side, price, amount, quote_amount, fee_maker, fee_taker)
 		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
-		RETURNING id, trade_time`
+		RETURNING id, seq, trade_time`
 	return tx.QueryRowContext(ctx, q,
 		t.MarketID, t.MakerOrderID, t.TakerOrderID, t.TakerSide,
 		t.Price, t.Amount, t.QuoteAmount, t.FeeMaker, t.FeeTaker,
-	).Scan(&t.ID, &t.TradeTime)
+	).Scan(&t.ID, &t.Seq, &t.TradeTime)
 }
 
-// TradeWithSymbol includes market symbol for API response
-type TradeWithSymbol struct {
-	ID          string
-	Symbol      string
-	Side        models.OrderSide
-	Price       float64
-	Amount      float64
-	QuoteAmount float64
-	Fee         float64
-	TradeTime   sql.NullTime
+// PublicTrade is an anonymized trade for the public trades tape
+type PublicTrade struct {
+	ID          int64            `json:"id"` // trade sequence number
+	Price       float64          `json:"price"`
+	Amount      float64          `json:"amount"`
+	QuoteAmount float64          `json:"quoteAmount"`
+	TakerSide   models.OrderSide `json:"takerSide"`
+	Time        time.Time        `json:"time"`
+}
+
+// MaxRecentTrades is the largest page served by GetRecentTrades
+const MaxRecentTrades = 500
+
+// GetRecentTrades returns the latest trades of a market, newest first.
+// Uses cache-aside pattern if cache service is provided
+func (r *TradeRepo) GetRecentTrades(ctx context.Context, marketID string, limit int, cache interface{}) ([]PublicTrade, error) {
+	type cacheService interface {
+		GetRecentTrades(ctx context.Context, marketID string) ([]PublicTrade, error)
+		SetRecentTrades(ctx context.Context, marketID string, trades []PublicTrade, ttl time.Duration) error
+	}
+
+	if limit <= 0 || limit > MaxRecentTrades {
+		limit = MaxRecentTrades
+	}
+
+	// The cache always holds the full MaxRecentTrades window, smaller pages are sliced from it
+	cs, ok := cache.(cacheService)
+	if ok && cs != nil {
+		cached, err := cs.GetRecentTrades(ctx, marketID)
+		if err == nil && cached != nil {
+			if len(cached) > limit {
+				cached = cached[:limit]
+			}
+			return cached, nil
+		}
+	}
+
+	q := `
+		SELECT seq, price, amount, quote_amount, taker_side, trade_time
+		FROM trades
+		WHERE market_id = $1
+		ORDER BY seq DESC
+		LIMIT $2
+	`
+	trades, err := r.queryPublicTrades(ctx, q, marketID, MaxRecentTrades)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && cs != nil {
+		// Invalidated on every new trade, the TTL only bounds staleness if that fails
+		go cs.SetRecentTrades(context.Background(), marketID, trades, 5*time.Second)
+	}
+
+	if len(trades) > limit {
+		trades = trades[:limit]
+	}
+	return trades, nil
+}
+
+// HistoricalTradesQuery selects a page of older trades, either from a trade ID
+// (sequence number, inclusive) or within a time range
+type HistoricalTradesQuery struct {
+	FromID    *int64
+	StartTime *time.Time // trade_time >= StartTime
+	EndTime   *time.Time // trade_time < EndTime
+	Limit     int
 }
 
-// GetByUserId returns all trades for a user (from both maker and taker orders)
-func (r *TradeRepo) GetByUserId(ctx context.Context, userId string, limit int) ([]TradeWithSymbol, error) {
-	if limit <= 0 {
-		limit = 100
+// CacheKey identifies the page for caching
+func (q HistoricalTradesQuery) CacheKey(marketID string) string {
+	key := marketID + ":" + strconv.Itoa(q.Limit)
+	if q.FromID != nil {
+		key += ":id" + strconv.FormatInt(*q.FromID, 10)
+	}
+	if q.StartTime != nil {
+		key += ":s" + strconv.FormatInt(q.StartTime.UnixMilli(), 10)
+	}
+	if q.EndTime != nil {
+		key += ":e" + strconv.FormatInt(q.EndTime.UnixMilli(), 10)
+	}
+	return key
+}
+
+// GetHistoricalTrades returns a page of trades in ascending sequence order.
+// Uses cache-aside pattern if cache service is provided; only pages that can
+// no longer change are cached.
+func (r *TradeRepo) GetHistoricalTrades(ctx context.Context, marketID string, hq HistoricalTradesQuery, cache interface{}) ([]PublicTrade, error) {
+	type cacheService interface {
+		GetTradePage(ctx context.Context, key string) ([]PublicTrade, error)
+		SetTradePage(ctx context.Context, key string, trades []PublicTrade, ttl time.Duration) error
+	}
+
+	if hq.Limit <= 0 || hq.Limit > MaxRecentTrades {
+		hq.Limit = MaxRecentTrades
+	}
+	key := hq.CacheKey(marketID)
+
+	cs, ok := cache.(cacheService)
+	if ok && cs != nil {
+		cached, err := cs.GetTradePage(ctx, key)
+		if err == nil && cached != nil {
+			return cached, nil
+		}
 	}
 
 	q := `
-		SELECT 
+		SELECT seq, price, amount, quote_amount, taker_side, trade_time
+		FROM trades
+		WHERE market_id = $1`
+	args := []interface{}{marketID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if hq.FromID != nil {
+		q += ` AND seq >= ` + arg(*hq.FromID)
+	}
+	if hq.StartTime != nil {
+		q += ` AND trade_time >= ` + arg(*hq.StartTime)
+	}
+	if hq.EndTime != nil {
+		q += ` AND trade_time < ` + arg(*hq.EndTime)
+	}
+	q += ` ORDER BY seq ASC LIMIT ` + arg(hq.Limit)
+
+	trades, err := r.queryPublicTrades(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// A full page, or a range that ended a while ago, will not change anymore
+	settled := time.Now().Add(-time.Minute)
+	stable := len(trades) == hq.Limit && trades[len(trades)-1].Time.Before(settled)
+	if hq.EndTime != nil && hq.EndTime.Before(settled) {
+		stable = true
+	}
+	if stable && ok && cs != nil {
+		go cs.SetTradePage(context.Background(), key, trades, 10*time.Minute)
+	}
+
+	return trades, nil
+}
+
+func (r *TradeRepo) queryPublicTrades(ctx context.Context, q string, args ...interface{}) ([]PublicTrade, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trades := []PublicTrade{}
+	for rows.Next() {
+		var t PublicTrade
+		if err := rows.Scan(&t.ID, &t.Price, &t.Amount, &t.QuoteAmount, &t.TakerSide, &t.Time); err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
+
+// TradeWithSymbol is one fill of a user's order, as returned by the API
+type TradeWithSymbol struct {
+	ID          string           `json:"id"`
+	OrderID     string           `json:"orderId"`
+	Symbol      string           `json:"symbol"`
+	Side        models.OrderSide `json:"side"`
+	Role        models.TradeRole `json:"role"`
+	Price       float64          `json:"price"`
+	Amount      float64          `json:"amount"`
+	QuoteAmount float64          `json:"quoteAmount"`
+	FeeAmount   float64          `json:"feeAmount"` // deducted from the proceeds; buyers pay none
+	FeeAsset    string           `json:"feeAsset"`  // fees are charged in the quote asset
+	TradeTime   time.Time        `json:"tradeTime"`
+}
+
+// userTradeColumns selects a TradeWithSymbol for the order aliased "o";
+// it must be used with scanUserTrade and the userTradeJoins
+const userTradeColumns = `
 			t.id,
+			o.id,
 			m.symbol,
-			CASE 
-				WHEN o.user_id = $1 AND o.side = 'buy' THEN 'buy'
-				WHEN o.user_id = $1 AND o.side = 'sell' THEN 'sell'
-				WHEN o2.user_id = $1 AND o2.side = 'buy' THEN 'buy'
-				ELSE 'sell'
-			END as side,
+			o.side,
+			CASE WHEN t.taker_order_id = o.id THEN 'taker' ELSE 'maker' END as role,
 			t.price,
 			t.amount,
 			t.quote_amount,
-			CASE 
-				WHEN o.user_id = $1 THEN 
-					CASE WHEN t.taker_order_id = o.id THEN t.fee_taker ELSE t.fee_maker END
-				ELSE 
-					CASE WHEN t.taker_order_id = o2.id THEN t.fee_taker ELSE t.fee_maker END
+			CASE
+				WHEN o.side <> 'sell' THEN 0
+				WHEN t.taker_order_id = o.id THEN t.fee_taker
+				ELSE t.fee_maker
 			END as fee,
-			t.trade_time
-		FROM trades t
+			qa.symbol,
+			t.trade_time`
+
+const userTradeJoins = `
 		JOIN markets m ON t.market_id = m.id
-		LEFT JOIN orders o ON (t.maker_order_id = o.id OR t.taker_order_id = o.id) AND o.user_id = $1
-		LEFT JOIN orders o2 ON (t.maker_order_id = o2.id OR t.taker_order_id = o2.id) AND o2.user_id = $1
-		WHERE (o.user_id = $1 OR o2.user_id = $1)
-		ORDER BY t.trade_time DESC
-		LIMIT $2
+		JOIN assets qa ON qa.id = m.quote_asset_id`
+
+func scanUserTrade(rows *sql.Rows) (TradeWithSymbol, error) {
+	var t TradeWithSymbol
+	if err := rows.Scan(&t.ID, &t.OrderID, &t.Symbol, &t.Side, &t.Role, &t.Price, &t.Amount,
+		&t.QuoteAmount, &t.FeeAmount, &t.FeeAsset, &t.TradeTime); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// TradeFilter narrows the trade history returned by GetByUserId.
+// Type, Statuses and ClientOrderID apply to the user's own order in the fill.
+type TradeFilter struct {
+	Symbol        string
+	MarketID      string
+	Side          models.OrderSide
+	Type          models.OrderType
+	Statuses      []models.OrderStatus
+	From          *time.Time // trade_time >= From
+	To            *time.Time // trade_time < To
+	ClientOrderID string
+	Cursor        *Cursor
+	Limit         int
+}
+
+// GetByUserId returns one page of a user's fills (from both maker and taker orders), newest first.
+// The returned cursor is nil when there are no more rows.
+func (r *TradeRepo) GetByUserId(ctx context.Context, userId string, f TradeFilter) ([]TradeWithSymbol, *Cursor, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	// A self-trade matches the user's order twice (maker and taker), so each
+	// row is a (trade, order) pair and o.id is part of the sort key.
+	q := `
+		SELECT ` + userTradeColumns + `
+		FROM trades t
+		JOIN orders o ON o.id IN (t.maker_order_id, t.taker_order_id)` + userTradeJoins + `
+		WHERE o.user_id = $1`
+	args := []interface{}{userId}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Symbol != "" {
+		q += ` AND m.symbol = ` + arg(f.Symbol)
+	}
+	if f.MarketID != "" {
+		q += ` AND t.market_id = ` + arg(f.MarketID)
+	}
+	if f.Side != "" {
+		q += ` AND o.side = ` + arg(f.Side)
+	}
+	if f.Type != "" {
+		q += ` AND o.type = ` + arg(f.Type)
+	}
+	if len(f.Statuses) > 0 {
+		statuses := make([]string, len(f.Statuses))
+		for i, st := range f.Statuses {
+			statuses[i] = string(st)
+		}
+		q += ` AND o.status::text = ANY(` + arg(statuses) + `)`
+	}
+	if f.From != nil {
+		q += ` AND t.trade_time >= ` + arg(*f.From)
+	}
+	if f.To != nil {
+		q += ` AND t.trade_time < ` + arg(*f.To)
+	}
+	if f.ClientOrderID != "" {
+		q += ` AND o.client_order_id = ` + arg(f.ClientOrderID)
+	}
+	if f.Cursor != nil {
+		// Fills are keyed by trade and order, a cursor of another listing lacks the order
+		if f.Cursor.Tiebreak == "" {
+			return nil, nil, ErrInvalidCursor
+		}
+		q += ` AND (t.trade_time, t.id, o.id) < (` + arg(f.Cursor.Time) + `, ` + arg(f.Cursor.ID) + `::uuid, ` + arg(f.Cursor.Tiebreak) + `::uuid)`
+	}
+
+	// Fetch one extra row to know whether another page exists
+	q += ` ORDER BY t.trade_time DESC, t.id DESC, o.id DESC LIMIT ` + arg(f.Limit+1)
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	trades := []TradeWithSymbol{}
+	for rows.Next() {
+		t, err := scanUserTrade(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		trades = append(trades, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	if len(trades) > f.Limit {
+		trades = trades[:f.Limit]
+		last := trades[len(trades)-1]
+		next = &Cursor{Time: last.TradeTime, ID: last.ID, Tiebreak: last.OrderID}
+	}
+
+	return trades, next, nil
+}
+
+// GetByOrderID returns all fills of a single order, oldest first
+func (r *TradeRepo) GetByOrderID(ctx context.Context, orderID string) ([]TradeWithSymbol, error) {
+	q := `
+		SELECT ` + userTradeColumns + `
+		FROM trades t
+		JOIN orders o ON o.id = $1` + userTradeJoins + `
+		WHERE t.maker_order_id = $1 OR t.taker_order_id = $1
+		ORDER BY t.trade_time ASC, t.id ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, q, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trades := []TradeWithSymbol{}
+	for rows.Next() {
+		t, err := scanUserTrade(rows)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
+
+// UserFill is a fill together with the owner of the order
+type UserFill struct {
+	UserID string `json:"userId"`
+	TradeWithSymbol
+}
+
+// GetFillsByTradeIDs returns both sides of the given trades, oldest first
+func (r *TradeRepo) GetFillsByTradeIDs(ctx context.Context, tradeIDs []string) ([]UserFill, error) {
+	q := `
+		SELECT o.user_id, ` + userTradeColumns + `
+		FROM trades t
+		JOIN orders o ON o.id IN (t.maker_order_id, t.taker_order_id)` + userTradeJoins + `
+		WHERE t.id::text = ANY($1)
+		ORDER BY t.seq ASC, o.id ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, q, tradeIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fills []UserFill
+	for rows.Next() {
+		var f UserFill
+		t := &f.TradeWithSymbol
+		if err := rows.Scan(&f.UserID, &t.ID, &t.OrderID, &t.Symbol, &t.Side, &t.Role, &t.Price, &t.Amount,
+			&t.QuoteAmount, &t.FeeAmount, &t.FeeAsset, &t.TradeTime); err != nil {
+			return nil, err
+		}
+		fills = append(fills, f)
+	}
+	return fills, rows.Err()
+}
+
+// TradeMinuteStats aggregates one market's trades within one minute
+type TradeMinuteStats struct {
+	MarketID    string
+	Minute      time.Time
+	Open        float64
+	High        float64
+	Low         float64
+	Close       float64
+	BaseVolume  float64
+	QuoteVolume float64
+	Count       int64
+	FirstSeq    int64
+	LastSeq     int64
+}
+
+// GetMinuteStats returns per-market, per-minute trade aggregates since the
+// given time and, read from the same snapshot, the sequences of the trades
+// since recent, so a caller can tell which later-published trades it holds
+func (r *TradeRepo) GetMinuteStats(ctx context.Context, since, recent time.Time) ([]TradeMinuteStats, []int64, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	q := `
+		SELECT
+			market_id,
+			date_trunc('minute', trade_time) AS minute,
+			(array_agg(price ORDER BY seq ASC))[1],
+			MAX(price),
+			MIN(price),
+			(array_agg(price ORDER BY seq DESC))[1],
+			SUM(amount),
+			SUM(quote_amount),
+			COUNT(*),
+			MIN(seq),
+			MAX(seq)
+		FROM trades
+		WHERE trade_time >= $1
+		GROUP BY market_id, minute
+		ORDER BY minute ASC
+	`
+
+	rows, err := tx.QueryContext(ctx, q, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var stats []TradeMinuteStats
+	for rows.Next() {
+		var s TradeMinuteStats
+		if err := rows.Scan(&s.MarketID, &s.Minute, &s.Open, &s.High, &s.Low, &s.Close,
+			&s.BaseVolume, &s.QuoteVolume, &s.Count, &s.FirstSeq, &s.LastSeq); err != nil {
+			return nil, nil, err
+		}
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	seqRows, err := tx.QueryContext(ctx, `SELECT seq FROM trades WHERE trade_time >= $1`, recent)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer seqRows.Close()
+
+	var seqs []int64
+	for seqRows.Next() {
+		var seq int64
+		if err := seqRows.Scan(&seq); err != nil {
+			return nil, nil, err
+		}
+		seqs = append(seqs, seq)
+	}
+	return stats, seqs, seqRows.Err()
+}
+
+// GetLastTrades returns the most recent trade of every market that has traded
+func (r *TradeRepo) GetLastTrades(ctx context.Context) ([]models.Trade, error) {
+	q := `
+		SELECT DISTINCT ON (market_id) id, seq, market_id, price, amount, quote_amount, trade_time
+		FROM trades
+		ORDER BY market_id, seq DESC
 	`
 
-	rows, err := r.db.QueryContext(ctx, q, userId, limit)
+	rows, err := r.db.QueryContext(ctx, q)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var trades []TradeWithSymbol
+	var trades []models.Trade
 	for rows.Next() {
-		var t TradeWithSymbol
-		if err := rows.Scan(&t.ID, &t.Symbol, &t.Side, &t.Price, &t.Amount, &t.QuoteAmount, &t.Fee, &t.TradeTime); err != nil {
+		var t models.Trade
+		if err := rows.Scan(&t.ID, &t.Seq, &t.MarketID, &t.Price, &t.Amount, &t.QuoteAmount, &t.TradeTime); err != nil {
 			return nil, err
 		}
 		trades = append(trades, t)
@@ -0,0 +1,228 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type TwoFARepo struct{ db *sql.DB }
+
+func NewTwoFARepo(db *sql.DB) *TwoFARepo { return &TwoFARepo{db: db} }
+
+// TwoFAState is the 2FA columns of user_auth
+type TwoFAState struct {
+	SecretEnc *string
+	Enabled   bool
+	LastStep  int64 // -1 when no code was accepted yet
+}
+
+func (r *TwoFARepo) Get(ctx context.Context, userID string) (*TwoFAState, error) {
+	var st TwoFAState
+	err := r.db.QueryRowContext(ctx, `
+SELECT twofa_secret, twofa_enabled, COALESCE(twofa_last_step, -1)
+FROM user_auth
+WHERE user_id = $1`, userID).Scan(&st.SecretEnc, &st.Enabled, &st.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// SetPendingSecret stores a secret awaiting its first code; it fails with
+// sql.ErrNoRows when 2FA is already enabled
+func (r *TwoFARepo) SetPendingSecret(ctx context.Context, userID, secretEnc string) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE user_auth
+SET twofa_secret = $2, twofa_last_step = NULL
+WHERE user_id = $1 AND twofa_enabled = false`, userID, secretEnc)
+	return expectRow(res, err)
+}
+
+// Enable turns 2FA on with the step of the verifying code and replaces the
+// recovery codes
+func (r *TwoFARepo) Enable(ctx context.Context, userID string, step int64, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE user_auth
+SET twofa_enabled = true, twofa_last_step = $2
+WHERE user_id = $1 AND twofa_enabled = false AND twofa_secret IS NOT NULL`, userID, step)
+	if err := expectRow(res, err); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Disable turns 2FA off and deletes the secret and recovery codes
+func (r *TwoFARepo) Disable(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE user_auth
+SET twofa_enabled = false, twofa_secret = NULL, twofa_last_step = NULL
+WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM twofa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdvanceStep records an accepted TOTP step and reports false when the step
+// was already used, which makes accepting a code atomic
+func (r *TwoFARepo) AdvanceStep(ctx context.Context, userID string, step int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE user_auth
+SET twofa_last_step = $2
+WHERE user_id = $1 AND (twofa_last_step IS NULL OR twofa_last_step < $2)`, userID, step)
+	return rowAffected(res, err)
+}
+
+// ReplaceRecoveryCodes deletes the user's recovery codes and stores new ones
+func (r *TwoFARepo) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM twofa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO twofa_recovery_codes(user_id, code_hash)
+SELECT $1, unnest($2::text[])`, userID, codeHashes)
+	return err
+}
+
+// UseRecoveryCode marks an unused code as used and reports whether there was one
+func (r *TwoFARepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE twofa_recovery_codes
+SET used_at = NOW()
+WHERE id = (
+	SELECT id FROM twofa_recovery_codes
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	LIMIT 1
+) AND used_at IS NULL`, userID, codeHash)
+	return rowAffected(res, err)
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes
+func (r *TwoFARepo) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM twofa_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// BeginAttempt counts a code check before it is made, so concurrent guesses
+// count too, and locks code checks until now+lockout at the maxFailures-th
+// attempt without a success. It returns the lock end and false when checks
+// are already locked. The count starts over once a lock has ended.
+func (r *TwoFARepo) BeginAttempt(ctx context.Context, userID string, maxFailures int, lockout time.Duration) (time.Time, bool, error) {
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+UPDATE user_auth
+SET twofa_failed_attempts = CASE WHEN twofa_locked_until IS NULL THEN twofa_failed_attempts + 1 ELSE 1 END,
+	twofa_locked_until = CASE
+		WHEN (CASE WHEN twofa_locked_until IS NULL THEN twofa_failed_attempts + 1 ELSE 1 END) >= $2
+		THEN NOW() + make_interval(secs => $3)
+	END
+WHERE user_id = $1 AND (twofa_locked_until IS NULL OR twofa_locked_until <= NOW())
+RETURNING twofa_locked_until`, userID, maxFailures, lockout.Seconds()).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		err = r.db.QueryRowContext(ctx, `
+SELECT twofa_locked_until FROM user_auth WHERE user_id = $1`, userID).Scan(&lockedUntil)
+		return lockedUntil.Time, false, err
+	}
+	return lockedUntil.Time, true, err
+}
+
+// ResetAttempts clears the failed code count after a successful check
+func (r *TwoFARepo) ResetAttempts(ctx context.Context, userID string) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE user_auth
+SET twofa_failed_attempts = 0, twofa_locked_until = NULL
+WHERE user_id = $1 AND (twofa_failed_attempts <> 0 OR twofa_locked_until IS NOT NULL)`, userID)
+	return err
+}
+
+// InsertChallenge stores a login challenge
+func (r *TwoFARepo) InsertChallenge(ctx context.Context, userID, tokenHash, ip string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO login_challenges(user_id, token_hash, ip_address, expires_at)
+VALUES($1, $2, NULLIF($3, '')::inet, $4)`, userID, tokenHash, ip, expiresAt)
+	return err
+}
+
+// AttemptChallenge counts an attempt on a pending challenge and returns its
+// user; sql.ErrNoRows when the challenge is unknown, expired, completed or out
+// of attempts
+func (r *TwoFARepo) AttemptChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	var userID string
+	err := r.db.QueryRowContext(ctx, `
+UPDATE login_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1 AND completed_at IS NULL AND expires_at > NOW() AND attempts < $2
+RETURNING user_id`, tokenHash, maxAttempts).Scan(&userID)
+	return userID, err
+}
+
+// ChallengeUser returns the user of a pending challenge without counting an
+// attempt; sql.ErrNoRows like AttemptChallenge
+func (r *TwoFARepo) ChallengeUser(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	var userID string
+	err := r.db.QueryRowContext(ctx, `
+SELECT user_id
+FROM login_challenges
+WHERE token_hash = $1 AND completed_at IS NULL AND expires_at > NOW() AND attempts < $2`, tokenHash, maxAttempts).Scan(&userID)
+	return userID, err
+}
+
+// CompleteChallenge marks a challenge as used and reports false when it already was
+func (r *TwoFARepo) CompleteChallenge(ctx context.Context, tokenHash string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE login_challenges
+SET completed_at = NOW()
+WHERE token_hash = $1 AND completed_at IS NULL`, tokenHash)
+	return rowAffected(res, err)
+}
+
+func rowAffected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// expectRow returns sql.ErrNoRows when an update matched no row
+func expectRow(res sql.Result, err error) error {
+	ok, err := rowAffected(res, err)
+	if err == nil && !ok {
+		return sql.ErrNoRows
+	}
+	return err
+}
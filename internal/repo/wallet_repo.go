@@ -3,7 +3,8 @@ package repo
 import (
 	"context"
 	"database/sql"
-	
+	"time"
+
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
 )
 
@@ -40,3 +41,38 @@ func (r *WalletRepo) UpdateBalances(ctx context.Context, tx *sql.Tx, userID, ass
 	_, err := tx.ExecContext(ctx, q, userID, assetID, balanceDelta, inOrdersDelta)
 	return err
 }
+
+// Balance is one asset balance of a user, as pushed on the user stream
+type Balance struct {
+	UserID    string    `json:"-"`
+	Asset     string    `json:"asset"`
+	Available float64   `json:"available"`
+	InOrders  float64   `json:"inOrders"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// GetMarketBalances returns the base and quote balances of the given users in a market
+func (r *WalletRepo) GetMarketBalances(ctx context.Context, userIDs []string, marketID string) ([]Balance, error) {
+	q := `
+		SELECT w.user_id, a.symbol, w.balance::float8, w.in_orders::float8, w.updated_at
+		FROM wallets w
+		JOIN markets m ON m.id = $2 AND w.asset_id IN (m.base_asset_id, m.quote_asset_id)
+		JOIN assets a ON a.id = w.asset_id
+		WHERE w.user_id::text = ANY($1)
+		ORDER BY w.user_id, a.symbol`
+	rows, err := r.db.QueryContext(ctx, q, userIDs, marketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []Balance
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.UserID, &b.Asset, &b.Available, &b.InOrders, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
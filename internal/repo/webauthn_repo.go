@@ -0,0 +1,158 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+)
+
+type WebAuthnRepo struct{ db *sql.DB }
+
+func NewWebAuthnRepo(db *sql.DB) *WebAuthnRepo { return &WebAuthnRepo{db: db} }
+
+const webauthnCredentialColumns = `
+	id, user_id, credential_id, public_key, sign_count, name,
+	array_to_string(transports, ','), aaguid::text, created_at, last_used_at`
+
+func scanWebAuthnCredential(row interface{ Scan(...any) error }) (*models.WebAuthnCredential, error) {
+	var (
+		cred       models.WebAuthnCredential
+		transports string
+	)
+	if err := row.Scan(&cred.ID, &cred.UserID, &cred.CredentialID, &cred.PublicKey, &cred.SignCount, &cred.Name,
+		&transports, &cred.AAGUID, &cred.CreatedAt, &cred.LastUsedAt); err != nil {
+		return nil, err
+	}
+	cred.Transports = splitList(transports)
+	return &cred, nil
+}
+
+// InsertCredential stores a credential and marks the user as having passkeys
+func (r *WebAuthnRepo) InsertCredential(ctx context.Context, cred *models.WebAuthnCredential) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO webauthn_credentials(user_id, credential_id, public_key, sign_count, name, transports, aaguid)
+VALUES($1, $2, $3, $4, $5, $6::text[], $7::uuid)
+RETURNING id, created_at`,
+		cred.UserID, cred.CredentialID, cred.PublicKey, cred.SignCount, cred.Name, cred.Transports, cred.AAGUID,
+	).Scan(&cred.ID, &cred.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET passkey_enabled = true WHERE id = $1`, cred.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetCredential returns a credential by its raw credential id
+func (r *WebAuthnRepo) GetCredential(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	q := `SELECT` + webauthnCredentialColumns + `
+FROM webauthn_credentials
+WHERE credential_id = $1`
+	return scanWebAuthnCredential(r.db.QueryRowContext(ctx, q, credentialID))
+}
+
+// ListByUser returns the user's credentials, oldest first
+func (r *WebAuthnRepo) ListByUser(ctx context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	q := `SELECT` + webauthnCredentialColumns + `
+FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at`
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []*models.WebAuthnCredential{}
+	for rows.Next() {
+		cred, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
+}
+
+// UpdateSignCount stores the counter of a successful assertion. It only moves
+// forward, so of two concurrent assertions with the same counter one fails.
+func (r *WebAuthnRepo) UpdateSignCount(ctx context.Context, id string, signCount int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE webauthn_credentials
+SET sign_count = $2, last_used_at = NOW()
+WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))`, id, signCount)
+	return rowAffected(res, err)
+}
+
+// Rename renames one of the user's credentials and reports whether it exists
+func (r *WebAuthnRepo) Rename(ctx context.Context, userID, id, name string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE webauthn_credentials SET name = $3
+WHERE id::text = $1 AND user_id = $2`, id, userID, name)
+	return rowAffected(res, err)
+}
+
+// Delete removes one of the user's credentials and reports whether it
+// existed; passkey_enabled is cleared with the last one
+func (r *WebAuthnRepo) Delete(ctx context.Context, userID, id string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+DELETE FROM webauthn_credentials
+WHERE id::text = $1 AND user_id = $2`, id, userID)
+	if ok, err := rowAffected(res, err); err != nil || !ok {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users
+SET passkey_enabled = EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = $1)
+WHERE id = $1`, userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// InsertChallenge stores the challenge of a pending ceremony, dropping expired
+// ones; userID is empty for a login where the user is not known yet
+func (r *WebAuthnRepo) InsertChallenge(ctx context.Context, challenge, kind, userID, name string, expiresAt time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM webauthn_challenges WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO webauthn_challenges(challenge, kind, user_id, name, expires_at)
+VALUES($1, $2, NULLIF($3, '')::uuid, $4, $5)`, challenge, kind, userID, name, expiresAt)
+	return err
+}
+
+// ConsumeChallenge deletes a pending challenge and returns its user ("" if
+// none) and credential name; sql.ErrNoRows when unknown or expired
+func (r *WebAuthnRepo) ConsumeChallenge(ctx context.Context, challenge, kind string) (userID, name string, err error) {
+	var (
+		uid   sql.NullString
+		valid bool
+	)
+	err = r.db.QueryRowContext(ctx, `
+DELETE FROM webauthn_challenges
+WHERE challenge = $1 AND kind = $2
+RETURNING user_id::text, name, expires_at > NOW()`, challenge, kind).Scan(&uid, &name, &valid)
+	if err != nil {
+		return "", "", err
+	}
+	if !valid {
+		return "", "", sql.ErrNoRows
+	}
+	return uid.String, name, nil
+}
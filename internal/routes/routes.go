@@ -5,19 +5,27 @@ import (
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/data"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/controller"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/handler"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/middleware"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/service"
 )
 
-func AuthRoutes(r *gin.Engine, pg *data.Postgres) {
+func AuthRoutes(r *gin.Engine, pg *data.Postgres, events *service.EventBus, refreshTokens *service.RefreshTokenService, devices *service.DeviceService, twofa *service.TwoFAService, throttle *service.LoginThrottle, accounts *service.AccountService) {
 	auth := r.Group("/auth") 
 
-	auth.POST("/register", controller.Register(pg.DB))
-	auth.POST("/login", controller.SignIn(pg.DB))
-	auth.POST("/logout", controller.SignOut(pg.DB))
-	auth.POST("/refresh", controller.RefreshToken(pg.DB))
+	auth.POST("/register", controller.Register(pg.DB, accounts))
+	auth.POST("/login", controller.SignIn(pg.DB, events, refreshTokens, devices, twofa, throttle))
+	auth.POST("/login/2fa", controller.SignInTwoFA(pg.DB, events, refreshTokens, devices, twofa, throttle))
+	auth.POST("/unlock", controller.UnlockAccount(events, throttle))
+	auth.POST("/password/forgot", controller.ForgotPassword(accounts))
+	auth.POST("/password/reset", controller.ResetPassword(events, accounts, refreshTokens, throttle))
+	auth.POST("/verify-email", controller.VerifyEmail(events, accounts))
+	auth.POST("/logout", controller.SignOut(events, refreshTokens))
+	auth.POST("/refresh", controller.RefreshToken(events, refreshTokens))
 }
 
 func UserRoutes(r *gin.Engine, pg *data.Postgres) {
-	user := r.Group("/user") 
+	user := r.Group("/user", middleware.RequireScope(models.ScopeRead))
 
 	user.GET("/profile", controller.AuthMe())	
 	user.GET("/login-activity", controller.GetLoginActivityHandler(pg.DB))
@@ -25,9 +33,84 @@ func UserRoutes(r *gin.Engine, pg *data.Postgres) {
 	user.GET("/trades", controller.GetUserTradesHandler(pg.DB))
 }
 
+// SessionRoutes manages the user's login sessions; register after RequireAuth
+func SessionRoutes(r *gin.Engine, events *service.EventBus, refreshTokens *service.RefreshTokenService) {
+	user := r.Group("/user", middleware.RequireSession())
+	{
+		user.POST("/logout-all", controller.SignOutAll(events, refreshTokens))
+		user.GET("/sessions", controller.ListSessions(refreshTokens))
+		user.DELETE("/sessions/:id", controller.RevokeSession(events, refreshTokens))
+		user.DELETE("/sessions", controller.RevokeOtherSessions(events, refreshTokens))
+	}
+}
+
+// AccountRoutes changes the password and manages email verification; register
+// after RequireAuth. Changing the password needs a 2FA code.
+func AccountRoutes(r *gin.Engine, accounts *service.AccountService, refreshTokens *service.RefreshTokenService, twofa *service.TwoFAService, events *service.EventBus) {
+	user := r.Group("/user", middleware.RequireSession())
+	{
+		user.POST("/password", middleware.RequireTwoFA(twofa), controller.ChangePassword(events, accounts, refreshTokens))
+		user.GET("/email", controller.GetEmailStatus(accounts))
+		user.POST("/email/verification", controller.ResendEmailVerification(accounts))
+	}
+}
+
+// APIKeyRoutes manages the user's API keys; register after RequireAuth. Keys
+// cannot be managed with an API key, and creating one needs a 2FA code.
+func APIKeyRoutes(r *gin.Engine, apiKeys *service.APIKeyService, twofa *service.TwoFAService) {
+	keys := r.Group("/user/api-keys", middleware.RequireSession())
+	{
+		keys.POST("", middleware.RequireTwoFA(twofa), controller.CreateAPIKey(apiKeys))
+		keys.GET("", controller.ListAPIKeys(apiKeys))
+		keys.DELETE("/:id", controller.RevokeAPIKey(apiKeys))
+	}
+}
+
+// TwoFARoutes manages TOTP two-factor authentication; register after RequireAuth
+func TwoFARoutes(r *gin.Engine, twofa *service.TwoFAService, events *service.EventBus) {
+	twoFA := r.Group("/user/2fa", middleware.RequireSession())
+	{
+		twoFA.GET("", controller.GetTwoFAStatus(twofa))
+		twoFA.POST("/enroll", controller.EnrollTwoFA(twofa))
+		twoFA.POST("/enable", controller.EnableTwoFA(twofa, events))
+		twoFA.POST("/disable", controller.DisableTwoFA(twofa, events))
+		twoFA.POST("/recovery-codes", controller.RegenerateRecoveryCodes(twofa))
+	}
+}
+
+// PasskeyLoginRoutes signs users in with a passkey
+func PasskeyLoginRoutes(r *gin.Engine, pg *data.Postgres, events *service.EventBus, refreshTokens *service.RefreshTokenService, devices *service.DeviceService, webauthn *service.WebAuthnService, twofa *service.TwoFAService) {
+	passkey := r.Group("/auth/passkey")
+	{
+		passkey.POST("/login/begin", controller.BeginPasskeyLogin(pg.DB, webauthn))
+		passkey.POST("/login/finish", controller.FinishPasskeyLogin(pg.DB, events, refreshTokens, devices, webauthn, twofa))
+	}
+}
+
+// PasskeyRoutes manages the user's passkeys; register after RequireAuth. A
+// passkey signs in without the password, so registering one needs a 2FA code
+// or the current password; finishing consumes the challenge of that request.
+func PasskeyRoutes(r *gin.Engine, webauthn *service.WebAuthnService, events *service.EventBus, twofa *service.TwoFAService, accounts *service.AccountService, throttle *service.LoginThrottle) {
+	passkeys := r.Group("/user/passkeys", middleware.RequireSession())
+	{
+		passkeys.GET("", controller.ListPasskeys(webauthn))
+		passkeys.POST("/register/begin", middleware.RequireTwoFAOrPassword(twofa, accounts, throttle), controller.BeginPasskeyRegistration(webauthn))
+		passkeys.POST("/register/finish", controller.FinishPasskeyRegistration(webauthn, events))
+		passkeys.PATCH("/:id", controller.RenamePasskey(webauthn))
+		passkeys.DELETE("/:id", controller.RemovePasskey(webauthn, events))
+	}
+}
+
 func WebSocketRoutes(r *gin.Engine, h *handler.Handler) {
+	r.GET("/ws", h.Gateway.HandleWebSocket)
 	r.GET("/ws/market-prices", h.WSHub.HandleWebSocket)
 	r.GET("/ws/orderbook", h.OrderbookHub.HandleWebSocket)
+	r.GET("/ws/ticker", h.TickerHub.HandleWebSocket)
+}
+
+// SSERoutes streams the /ws topics as Server-Sent Events
+func SSERoutes(r *gin.Engine, h *handler.Handler) {
+	r.GET("/sse", h.Gateway.HandleSSE)
 }
 
 func MarketRoutes(r *gin.Engine, h *handler.Handler) {
@@ -35,19 +118,40 @@ func MarketRoutes(r *gin.Engine, h *handler.Handler) {
 	{
 		market.GET("/list", h.MarketHandler.GetMarkets)
 		market.GET("/candles", h.MarketHandler.GetCandles)
+		market.GET("/ticker", h.MarketHandler.GetTicker)
+		market.GET("/depth", h.MarketHandler.GetDepth)
+		market.GET("/trades", h.MarketHandler.GetRecentTrades)
+		market.GET("/trades/history", h.MarketHandler.GetHistoricalTrades)
 	}
 }
 
-func OrderRoutes(r *gin.Engine, h *handler.Handler) {
+func OrderRoutes(r *gin.Engine, h *handler.Handler, accounts *service.AccountService) {
 	orders := r.Group("/orders")
 	{
-		orders.GET("", h.OrderHandler.List)
-		orders.POST("", h.OrderHandler.Place)
-		orders.DELETE("/:id", h.OrderHandler.Cancel)
-		orders.PUT("/:id", h.OrderHandler.Amend)
+		read := middleware.RequireScope(models.ScopeRead)
+		trade := middleware.RequireScope(models.ScopeTrade)
+		verified := middleware.RequireVerifiedEmail(accounts)
+
+		orders.GET("", read, h.OrderHandler.List)
+		orders.POST("", trade, verified, h.OrderHandler.Place)
+		orders.GET("/:id", read, h.OrderHandler.Get)
+		orders.GET("/:id/trades", read, h.OrderHandler.Trades)
+		orders.DELETE("/:id", trade, h.OrderHandler.Cancel)
+		orders.PUT("/:id", trade, verified, h.OrderHandler.Amend)
 	}
 }
 
+// UserStreamRoutes exposes the private account stream; register after RequireAuth
+func UserStreamRoutes(r *gin.Engine, h *handler.Handler) {
+	r.GET("/ws/user", middleware.RequireScope(models.ScopeRead), h.UserHub.HandleWebSocket)
+}
+
+// L3Routes exposes the order-by-order feed; register after RequireAuth
+func L3Routes(r *gin.Engine, h *handler.Handler) {
+	r.GET("/ws/l3", middleware.RequireScope(models.ScopeRead), h.L3Hub.HandleWebSocket)
+	r.GET("/market/l3", middleware.RequireScope(models.ScopeRead), h.L3Hub.GetSnapshot)
+}
+
 func HealthRoutes(r *gin.Engine) {
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
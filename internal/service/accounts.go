@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/mailer"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+var (
+	ErrIncorrectPassword    = errors.New("current password is incorrect")
+	ErrInvalidPassword      = errors.New("invalid password")
+	ErrInvalidAccountToken  = errors.New("token is invalid or expired")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	ErrMailRateLimited      = errors.New("an email was sent recently, try again later")
+)
+
+const (
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 48 * time.Hour
+
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt ignores anything longer
+	passwordHashCost  = 10
+	// mailResendInterval limits verification and reset emails per user
+	mailResendInterval = time.Minute
+)
+
+// AccountService changes and resets passwords and verifies emails. Links in
+// emails point to the frontend at appURL, which posts the token back.
+type AccountService struct {
+	repo   *repo.AccountRepo
+	mailer mailer.Mailer
+	appURL string
+}
+
+func NewAccountService(r *repo.AccountRepo, m mailer.Mailer, appURL string) *AccountService {
+	return &AccountService{repo: r, mailer: m, appURL: strings.TrimRight(appURL, "/")}
+}
+
+// CheckPassword returns ErrIncorrectPassword unless password is the user's
+func (s *AccountService) CheckPassword(ctx context.Context, userID, password string) error {
+	hash, err := s.repo.PasswordHash(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
+
+// ChangePassword replaces the password of a user who knows the current one
+func (s *AccountService) ChangePassword(ctx context.Context, userID, current, next string) error {
+	if err := s.CheckPassword(ctx, userID, current); err != nil {
+		return err
+	}
+	if current == next {
+		return fmt.Errorf("%w: new password must differ from the current one", ErrInvalidPassword)
+	}
+	return s.setPassword(ctx, userID, next)
+}
+
+// RequestPasswordReset mails a reset link to the owner of an email. Unknown
+// emails and repeated requests succeed silently so accounts cannot be probed;
+// callers run it off the request path so the time taken does not tell either.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	userID, err := s.repo.UserIDByEmail(ctx, strings.TrimSpace(email))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = s.sendToken(ctx, userID, repo.TokenPasswordReset, PasswordResetTTL, "/reset-password", func(name, link string) mailer.Message {
+		return mailer.Message{
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nOpen this link within %s to choose a new password:\n%s\n\n"+
+				"If you did not ask for a reset, ignore this email; your password is unchanged.\n", name, PasswordResetTTL, link),
+		}
+	})
+	if errors.Is(err, ErrMailRateLimited) {
+		return nil
+	}
+	return err
+}
+
+// ResetPassword sets a new password with a reset token and returns its user
+func (s *AccountService) ResetPassword(ctx context.Context, token, next string) (string, error) {
+	if err := validatePassword(next); err != nil {
+		return "", err
+	}
+	userID, err := s.repo.UseToken(ctx, repo.TokenPasswordReset, hashToken(token))
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidAccountToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, s.setPassword(ctx, userID, next)
+}
+
+// SendEmailVerification mails a verification link to a user
+func (s *AccountService) SendEmailVerification(ctx context.Context, userID string) error {
+	return s.sendToken(ctx, userID, repo.TokenEmailVerification, EmailVerificationTTL, "/verify-email", func(name, link string) mailer.Message {
+		return mailer.Message{
+			Subject: "Verify your email",
+			Body: fmt.Sprintf("Hi %s,\n\nOpen this link within %s to verify your email and start trading:\n%s\n",
+				name, EmailVerificationTTL, link),
+		}
+	})
+}
+
+// VerifyEmail verifies the email of a verification token and returns its user
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) (string, error) {
+	userID, err := s.repo.UseToken(ctx, repo.TokenEmailVerification, hashToken(token))
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidAccountToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.repo.SetEmailVerified(ctx, userID); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// Email returns a user's email and its verification state
+func (s *AccountService) Email(ctx context.Context, userID string) (*repo.AccountEmail, error) {
+	return s.repo.Email(ctx, userID)
+}
+
+// EmailVerified reports whether a user may trade
+func (s *AccountService) EmailVerified(ctx context.Context, userID string) (bool, error) {
+	return s.repo.EmailVerified(ctx, userID)
+}
+
+// SendUnlockToken mails the unlock link of a locked account; it is the
+// LoginThrottle's UnlockNotifier
+func (s *AccountService) SendUnlockToken(ctx context.Context, userID, token string) error {
+	e, err := s.repo.Email(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      e.Email,
+		Subject: "Your account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account was locked after too many failed login attempts. "+
+			"It unlocks by itself later, or right away with this link (valid %s):\n%s\n\n"+
+			"If these attempts were not yours, consider changing your password.\n",
+			e.FirstName, UnlockTokenTTL, s.link("/unlock", token)),
+	})
+}
+
+func (s *AccountService) setPassword(ctx context.Context, userID, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return err
+	}
+	return s.repo.SetPassword(ctx, userID, string(hash))
+}
+
+// sendToken issues a token of a purpose and mails its link
+func (s *AccountService) sendToken(ctx context.Context, userID, purpose string, ttl time.Duration, path string, compose func(name, link string) mailer.Message) error {
+	e, err := s.repo.Email(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if purpose == repo.TokenEmailVerification && e.VerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+	last, err := s.repo.LastTokenAt(ctx, userID, purpose)
+	if err != nil {
+		return err
+	}
+	if time.Since(last) < mailResendInterval {
+		return ErrMailRateLimited
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.InsertToken(ctx, userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return err
+	}
+	msg := compose(e.FirstName, s.link(path, token))
+	msg.To = e.Email
+	return s.mailer.Send(ctx, msg)
+}
+
+func (s *AccountService) link(path, token string) string {
+	return s.appURL + path + "?token=" + url.QueryEscape(token)
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrInvalidPassword, minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrInvalidPassword, maxPasswordLength)
+	}
+	return nil
+}
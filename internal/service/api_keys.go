@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+var (
+	ErrInvalidAPIKeyRequest = errors.New("invalid API key request")
+	ErrInvalidAPIKey        = errors.New("invalid API key")
+	ErrAPIKeyExpired        = errors.New("API key expired")
+	ErrAPIKeyIPNotAllowed   = errors.New("IP address not allowed for this API key")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrOutsideRecvWindow    = errors.New("timestamp outside recvWindow")
+)
+
+const (
+	DefaultRecvWindow = 5 * time.Second
+	MaxRecvWindow     = 60 * time.Second
+
+	// maxClockAhead tolerates client clocks slightly ahead of the server
+	maxClockAhead = time.Second
+	// lastUsedPrecision limits last_used_at writes to one per key per minute
+	lastUsedPrecision = time.Minute
+)
+
+var apiKeyScopes = map[string]bool{
+	models.ScopeRead:     true,
+	models.ScopeTrade:    true,
+	models.ScopeWithdraw: true,
+	models.ScopeTransfer: true,
+}
+
+// NewAPIKey describes a key to create
+type NewAPIKey struct {
+	Label       string
+	Scopes      []string
+	IPAllowlist []string
+	ExpiresAt   *time.Time
+}
+
+// SignedRequest is an API key request as seen by the signature check. The
+// signature is the hex HMAC-SHA256, keyed by the secret, of
+// timestamp + method + path + body, where path includes the query string.
+type SignedRequest struct {
+	Key        string
+	Signature  string
+	Timestamp  int64         // Unix milliseconds
+	RecvWindow time.Duration // 0 for DefaultRecvWindow
+	Method     string
+	Path       string
+	Body       []byte
+	IP         string
+}
+
+// APIKeyService creates API keys and authenticates signed requests. Secrets
+// are kept encrypted with AES-GCM since checking a signature needs them.
+type APIKeyService struct {
+	repo *repo.APIKeyRepo
+	box  *secretBox
+}
+
+// NewAPIKeyService creates the service; secrets are encrypted with a key derived from encryptionKey
+func NewAPIKeyService(r *repo.APIKeyRepo, encryptionKey string) (*APIKeyService, error) {
+	box, err := newSecretBox(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("API key %w", err)
+	}
+	return &APIKeyService{repo: r, box: box}, nil
+}
+
+// Create validates and stores a new key. The secret is returned only here.
+func (s *APIKeyService) Create(ctx context.Context, userID string, req NewAPIKey) (*models.APIKey, string, error) {
+	if len(req.Label) > 64 {
+		return nil, "", fmt.Errorf("%w: label is longer than 64 characters", ErrInvalidAPIKeyRequest)
+	}
+	if len(req.Scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyRequest)
+	}
+	seen := make(map[string]bool)
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !apiKeyScopes[scope] {
+			return nil, "", fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKeyRequest, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	ips := []string{}
+	for _, ip := range req.IPAllowlist {
+		normalized, ok := normalizeIPEntry(ip)
+		if !ok {
+			return nil, "", fmt.Errorf("%w: invalid IP address or CIDR %q", ErrInvalidAPIKeyRequest, ip)
+		}
+		ips = append(ips, normalized)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidAPIKeyRequest)
+	}
+
+	key, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secretEnc, err := s.box.seal([]byte(secret))
+	if err != nil {
+		return nil, "", err
+	}
+
+	k := &models.APIKey{
+		UserID:      userID,
+		Key:         key,
+		Label:       req.Label,
+		Scopes:      scopes,
+		IPAllowlist: ips,
+		ExpiresAt:   req.ExpiresAt,
+		SecretEnc:   secretEnc,
+	}
+	if err := s.repo.Insert(ctx, k); err != nil {
+		return nil, "", err
+	}
+	return k, secret, nil
+}
+
+// List returns the user's active keys
+func (s *APIKeyService) List(ctx context.Context, userID string) ([]*models.APIKey, error) {
+	return s.repo.ListByUser(ctx, userID)
+}
+
+// Revoke revokes one of the user's keys and reports whether it existed
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id string) (bool, error) {
+	return s.repo.Revoke(ctx, userID, id)
+}
+
+// Authenticate checks a signed request and returns its key
+func (s *APIKeyService) Authenticate(ctx context.Context, req SignedRequest) (*models.APIKey, error) {
+	now := time.Now()
+	if err := checkRecvWindow(req, now); err != nil {
+		return nil, err
+	}
+
+	k, err := s.repo.GetActiveByKey(ctx, req.Key)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+	if !ipAllowed(k.IPAllowlist, req.IP) {
+		return nil, ErrAPIKeyIPNotAllowed
+	}
+
+	secret, err := s.box.open(k.SecretEnc)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting API key secret: %w", err)
+	}
+	if err := checkSignature(secret, req); err != nil {
+		return nil, err
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > lastUsedPrecision {
+		if err := s.repo.TouchLastUsed(ctx, k.ID); err != nil {
+			log.Printf("Error updating API key last use: %v", err)
+		}
+	}
+	return k, nil
+}
+
+// checkRecvWindow rejects a request signed longer than its recvWindow before
+// now, or too far in the future
+func checkRecvWindow(req SignedRequest, now time.Time) error {
+	window := req.RecvWindow
+	if window == 0 {
+		window = DefaultRecvWindow
+	}
+	ts := time.UnixMilli(req.Timestamp)
+	if ts.After(now.Add(maxClockAhead)) || now.Sub(ts) > window {
+		return ErrOutsideRecvWindow
+	}
+	return nil
+}
+
+// signRequest is the signature of a request with a secret
+func signRequest(secret []byte, req SignedRequest) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(req.Timestamp, 10) + req.Method + req.Path))
+	mac.Write(req.Body)
+	return mac.Sum(nil)
+}
+
+// checkSignature compares the hex signature of a request with its expected value
+func checkSignature(secret []byte, req SignedRequest) error {
+	signature, err := hex.DecodeString(req.Signature)
+	if err != nil || !hmac.Equal(signature, signRequest(secret, req)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// normalizeIPEntry accepts an IP address or a CIDR
+func normalizeIPEntry(entry string) (string, bool) {
+	if ip := net.ParseIP(entry); ip != nil {
+		return ip.String(), true
+	}
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		return network.String(), true
+	}
+	return "", false
+}
+
+// ipAllowed reports whether ip matches the allowlist; an empty list allows any IP
+func ipAllowed(allowlist []string, ip string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
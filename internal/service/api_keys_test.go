@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckRecvWindow(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	ms := func(d time.Duration) int64 { return now.Add(d).UnixMilli() }
+
+	tests := []struct {
+		name       string
+		timestamp  int64
+		recvWindow time.Duration
+		wantErr    error
+	}{
+		{"now", ms(0), 0, nil},
+		{"within default window", ms(-DefaultRecvWindow), 0, nil},
+		{"past default window", ms(-DefaultRecvWindow - time.Millisecond), 0, ErrOutsideRecvWindow},
+		{"within custom window", ms(-30 * time.Second), 30 * time.Second, nil},
+		{"past custom window", ms(-31 * time.Second), 30 * time.Second, ErrOutsideRecvWindow},
+		{"clock slightly ahead", ms(maxClockAhead), 0, nil},
+		{"clock too far ahead", ms(maxClockAhead + time.Millisecond), 0, ErrOutsideRecvWindow},
+		{"far future with large window", ms(time.Minute), MaxRecvWindow, ErrOutsideRecvWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRecvWindow(SignedRequest{Timestamp: tt.timestamp, RecvWindow: tt.recvWindow}, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkRecvWindow = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	secret := []byte("secret")
+	order := SignedRequest{
+		Timestamp: 1700000000000,
+		Method:    "POST",
+		Path:      "/api/order?symbol=BTCUSDT",
+		Body:      []byte(`{"side":"BUY"}`),
+		Signature: "2759e6122ebf7bc7ff1642334753723561192df19044bb07e1742d1801c7e796",
+	}
+	balance := SignedRequest{
+		Timestamp: 1700000000000,
+		Method:    "GET",
+		Path:      "/api/user/balance",
+		Signature: "0f7cdddd93aa47ef623c5cc11862495768d6397652ef4a355a96fa0bd66a705a",
+	}
+	with := func(req SignedRequest, change func(*SignedRequest)) SignedRequest {
+		change(&req)
+		return req
+	}
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		req     SignedRequest
+		wantErr error
+	}{
+		{"signed body", secret, order, nil},
+		{"no body", secret, balance, nil},
+		{"uppercase hex", secret, with(order, func(r *SignedRequest) { r.Signature = strings.ToUpper(r.Signature) }), nil},
+		{"wrong secret", []byte("other"), order, ErrInvalidSignature},
+		{"changed body", secret, with(order, func(r *SignedRequest) { r.Body = []byte(`{"side":"SELL"}`) }), ErrInvalidSignature},
+		{"changed query", secret, with(order, func(r *SignedRequest) { r.Path = "/api/order?symbol=ETHUSDT" }), ErrInvalidSignature},
+		{"changed method", secret, with(order, func(r *SignedRequest) { r.Method = "PUT" }), ErrInvalidSignature},
+		{"changed timestamp", secret, with(order, func(r *SignedRequest) { r.Timestamp++ }), ErrInvalidSignature},
+		{"truncated signature", secret, with(order, func(r *SignedRequest) { r.Signature = r.Signature[:62] }), ErrInvalidSignature},
+		{"not hex", secret, with(order, func(r *SignedRequest) { r.Signature = "zz" + r.Signature[2:] }), ErrInvalidSignature},
+		{"empty signature", secret, with(order, func(r *SignedRequest) { r.Signature = "" }), ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkSignature(tt.secret, tt.req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkSignature = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		ip        string
+		want      bool
+	}{
+		{"empty allowlist", nil, "203.0.113.7", true},
+		{"exact address", []string{"203.0.113.7"}, "203.0.113.7", true},
+		{"other address", []string{"203.0.113.7"}, "203.0.113.8", false},
+		{"inside CIDR", []string{"10.0.0.0/8"}, "10.1.2.3", true},
+		{"outside CIDR", []string{"10.0.0.0/8"}, "11.0.0.1", false},
+		{"IPv6 CIDR", []string{"2001:db8::/32"}, "2001:db8::1", true},
+		{"any entry matches", []string{"10.0.0.0/8", "203.0.113.7"}, "203.0.113.7", true},
+		{"unparsable client IP", []string{"10.0.0.0/8"}, "unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ipAllowed(tt.allowlist, tt.ip); got != tt.want {
+				t.Errorf("ipAllowed(%v, %q) = %v, want %v", tt.allowlist, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
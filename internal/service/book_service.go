@@ -0,0 +1,449 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// bookResyncInterval bounds how long a missed book event can leave the
+// in-memory book out of date
+const bookResyncInterval = 30 * time.Second
+
+// Depth limits of a book view
+const (
+	MinBookDepth     = 5
+	MaxBookDepth     = 5000
+	DefaultBookDepth = 100
+)
+
+var (
+	ErrUnknownMarket = errors.New("unknown market")
+	ErrInvalidDepth  = errors.New("depth must be between 5 and 5000")
+	ErrInvalidGroup  = errors.New("group must be a positive multiple of the tick size")
+)
+
+// BookSnapshot is the L2 book of a market as of LastUpdateID
+type BookSnapshot struct {
+	MarketID     string                `json:"marketId"`
+	Symbol       string                `json:"symbol"`
+	LastUpdateID int64                 `json:"lastUpdateId"`
+	Bids         []repo.OrderBookEntry `json:"bids"` // Sorted DESC by price
+	Asks         []repo.OrderBookEntry `json:"asks"` // Sorted ASC by price
+	Timestamp    time.Time             `json:"timestamp"`
+}
+
+// BookDiff carries the L2 levels changed by updates FirstUpdateID..LastUpdateID.
+// Amounts are absolute; an amount of 0 removes the level.
+type BookDiff struct {
+	MarketID      string                `json:"marketId"`
+	Symbol        string                `json:"symbol"`
+	FirstUpdateID int64                 `json:"firstUpdateId"`
+	LastUpdateID  int64                 `json:"lastUpdateId"`
+	Bids          []repo.OrderBookEntry `json:"bids"`
+	Asks          []repo.OrderBookEntry `json:"asks"`
+	Timestamp     time.Time             `json:"timestamp"`
+}
+
+// l2Book is the in-memory aggregated book of one market
+type l2Book struct {
+	marketID string
+	symbol   string
+	bids     map[float64]float64 // price -> resting amount
+	asks     map[float64]float64
+	updateID int64   // Incremented once per changed level
+	tickSize float64 // 0 when unknown
+}
+
+// BookService keeps the L2 book of every market in memory and publishes
+// sequenced diffs. It is driven by book change events: only the touched price
+// levels are re-read from the database, and a periodic full comparison repairs
+// anything a dropped event missed.
+type BookService struct {
+	order  *repo.OrderRepo
+	market *repo.MarketRepo
+	events *EventBus
+
+	mu    sync.RWMutex
+	books map[string]*l2Book // market id -> book
+}
+
+// NewBookService creates a book service; call Start to load and run it
+func NewBookService(or *repo.OrderRepo, mr *repo.MarketRepo, events *EventBus) *BookService {
+	return &BookService{
+		order:  or,
+		market: mr,
+		events: events,
+		books:  make(map[string]*l2Book),
+	}
+}
+
+// Start loads the books of all active markets and keeps them updated from events
+func (s *BookService) Start(ctx context.Context) error {
+	// Subscribe before loading so no change falls between the two
+	ch, unsubscribe := s.events.Subscribe(4096)
+
+	if err := s.resyncAll(ctx); err != nil {
+		unsubscribe()
+		return err
+	}
+
+	go s.run(ctx, ch, unsubscribe)
+	return nil
+}
+
+// Snapshot returns the book of a market with at most depth levels per side
+// (0 for all levels)
+func (s *BookService) Snapshot(marketID string, depth int) (*BookSnapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.books[marketID]
+	if !ok {
+		return nil, false
+	}
+	return &BookSnapshot{
+		MarketID:     b.marketID,
+		Symbol:       b.symbol,
+		LastUpdateID: b.updateID,
+		Bids:         sortedLevels(b.bids, true, depth),
+		Asks:         sortedLevels(b.asks, false, depth),
+		Timestamp:    time.Now(),
+	}, true
+}
+
+// View returns the book of a market with levels grouped into price buckets of
+// size group (0 for no grouping) and at most depth levels per side (0 for all).
+// Bids are rounded down to their bucket and asks up, so buckets never cross.
+func (s *BookService) View(marketID string, depth int, group float64) (*BookSnapshot, error) {
+	if depth != 0 && (depth < MinBookDepth || depth > MaxBookDepth) {
+		return nil, ErrInvalidDepth
+	}
+
+	s.mu.RLock()
+	b, ok := s.books[marketID]
+	var tick float64
+	if ok {
+		tick = b.tickSize
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownMarket
+	}
+	if !validGroup(group, tick) {
+		return nil, ErrInvalidGroup
+	}
+
+	if group == 0 {
+		snapshot, _ := s.Snapshot(marketID, depth)
+		return snapshot, nil
+	}
+
+	snapshot, _ := s.Snapshot(marketID, 0)
+	snapshot.Bids = GroupLevels(snapshot.Bids, group, true)
+	snapshot.Asks = GroupLevels(snapshot.Asks, group, false)
+	if depth > 0 {
+		if len(snapshot.Bids) > depth {
+			snapshot.Bids = snapshot.Bids[:depth]
+		}
+		if len(snapshot.Asks) > depth {
+			snapshot.Asks = snapshot.Asks[:depth]
+		}
+	}
+	return snapshot, nil
+}
+
+func validGroup(group, tick float64) bool {
+	if group == 0 {
+		return true
+	}
+	if group < 0 || math.IsNaN(group) || math.IsInf(group, 0) {
+		return false
+	}
+	if tick <= 0 {
+		return true
+	}
+	ratio := group / tick
+	return ratio >= 1-1e-9 && math.Abs(ratio-math.Round(ratio)) < 1e-6
+}
+
+// GroupLevels merges sorted levels into price buckets of size group. Bids
+// (desc) are rounded down to their bucket, asks up. The order is preserved.
+func GroupLevels(levels []repo.OrderBookEntry, group float64, desc bool) []repo.OrderBookEntry {
+	decimals := groupDecimals(group)
+	grouped := []repo.OrderBookEntry{}
+	for _, l := range levels {
+		var bucket float64
+		if desc {
+			bucket = math.Floor(l.Price/group + 1e-9)
+		} else {
+			bucket = math.Ceil(l.Price/group - 1e-9)
+		}
+		price := roundTo(bucket*group, decimals)
+
+		if n := len(grouped); n > 0 && grouped[n-1].Price == price {
+			grouped[n-1].Amount += l.Amount
+			continue
+		}
+		grouped = append(grouped, repo.OrderBookEntry{Price: price, Amount: l.Amount})
+	}
+	return grouped
+}
+
+// groupDecimals returns the number of decimals of a group size like 0.05
+func groupDecimals(group float64) int {
+	str := strconv.FormatFloat(group, 'f', -1, 64)
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		return len(str) - i - 1
+	}
+	return 0
+}
+
+func roundTo(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
+
+func sortedLevels(side map[float64]float64, desc bool, depth int) []repo.OrderBookEntry {
+	levels := make([]repo.OrderBookEntry, 0, len(side))
+	for price, amount := range side {
+		levels = append(levels, repo.OrderBookEntry{Price: price, Amount: amount})
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if desc {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	if depth > 0 && len(levels) > depth {
+		levels = levels[:depth]
+	}
+	return levels
+}
+
+func (s *BookService) run(ctx context.Context, ch <-chan Event, unsubscribe func()) {
+	defer unsubscribe()
+
+	resync := time.NewTicker(bookResyncInterval)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev := <-ch:
+			// Coalesce the changes already queued into one refresh per market
+			pending := make(map[string]*bookRefresh)
+			s.collect(pending, ev)
+		drain:
+			for {
+				select {
+				case ev := <-ch:
+					s.collect(pending, ev)
+				default:
+					break drain
+				}
+			}
+			for marketID, r := range pending {
+				if err := s.refresh(ctx, marketID, r); err != nil {
+					log.Printf("Book: error refreshing market %s: %v", marketID, err)
+				}
+			}
+
+		case <-resync.C:
+			if err := s.resyncAll(ctx); err != nil {
+				log.Printf("Book: resync failed: %v", err)
+			}
+		}
+	}
+}
+
+// bookRefresh collects the levels to re-read for one market
+type bookRefresh struct {
+	symbol string
+	full   bool
+	bids   map[float64]bool
+	asks   map[float64]bool
+}
+
+func (s *BookService) collect(pending map[string]*bookRefresh, ev Event) {
+	if ev.Type != EventBookChanged {
+		return
+	}
+
+	r, ok := pending[ev.MarketID]
+	if !ok {
+		r = &bookRefresh{symbol: ev.Symbol, bids: make(map[float64]bool), asks: make(map[float64]bool)}
+		pending[ev.MarketID] = r
+	}
+	if len(ev.BookPrices) == 0 {
+		r.full = true
+	}
+	for _, p := range ev.BookPrices {
+		if p.Side == models.Buy {
+			r.bids[p.Price] = true
+		} else {
+			r.asks[p.Price] = true
+		}
+	}
+}
+
+// refresh re-reads the touched levels of a market and publishes the changes
+func (s *BookService) refresh(ctx context.Context, marketID string, r *bookRefresh) error {
+	s.mu.RLock()
+	_, known := s.books[marketID]
+	s.mu.RUnlock()
+
+	if r.full || !known {
+		return s.resyncMarket(ctx, marketID, r.symbol)
+	}
+
+	bids, err := s.readLevels(ctx, marketID, models.Buy, r.bids)
+	if err != nil {
+		return err
+	}
+	asks, err := s.readLevels(ctx, marketID, models.Sell, r.asks)
+	if err != nil {
+		return err
+	}
+	s.apply(marketID, r.symbol, bids, asks, false)
+	return nil
+}
+
+// readLevels returns the current amount of each price, 0 for emptied levels
+func (s *BookService) readLevels(ctx context.Context, marketID string, side models.OrderSide, prices map[float64]bool) (map[float64]float64, error) {
+	if len(prices) == 0 {
+		return nil, nil
+	}
+	list := make([]float64, 0, len(prices))
+	for p := range prices {
+		list = append(list, p)
+	}
+
+	found, err := s.order.GetBookLevels(ctx, marketID, side, list)
+	if err != nil {
+		return nil, err
+	}
+	levels := make(map[float64]float64, len(list))
+	for _, p := range list {
+		levels[p] = found[p]
+	}
+	return levels, nil
+}
+
+func (s *BookService) resyncAll(ctx context.Context) error {
+	markets, err := s.market.GetAllActiveMarkets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range markets {
+		if err := s.resyncMarket(ctx, m.ID, m.Symbol); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.books[m.ID].tickSize = m.TickSize
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// resyncMarket compares the whole book with the database and publishes any difference
+func (s *BookService) resyncMarket(ctx context.Context, marketID, symbol string) error {
+	book, err := s.order.GetFullOrderBook(ctx, marketID)
+	if err != nil {
+		return err
+	}
+
+	bids := make(map[float64]float64, len(book.Bids))
+	for _, l := range book.Bids {
+		bids[l.Price] = l.Amount
+	}
+	asks := make(map[float64]float64, len(book.Asks))
+	for _, l := range book.Asks {
+		asks[l.Price] = l.Amount
+	}
+	s.apply(marketID, symbol, bids, asks, true)
+	return nil
+}
+
+// apply stores the given levels and publishes those that changed. With full
+// set, levels missing from bids/asks are treated as removed.
+func (s *BookService) apply(marketID, symbol string, bids, asks map[float64]float64, full bool) {
+	s.mu.Lock()
+
+	b, ok := s.books[marketID]
+	if !ok {
+		b = &l2Book{
+			marketID: marketID,
+			symbol:   symbol,
+			bids:     make(map[float64]float64),
+			asks:     make(map[float64]float64),
+		}
+		s.books[marketID] = b
+	}
+	if full {
+		for p := range b.bids {
+			if _, ok := bids[p]; !ok {
+				bids[p] = 0
+			}
+		}
+		for p := range b.asks {
+			if _, ok := asks[p]; !ok {
+				asks[p] = 0
+			}
+		}
+	}
+
+	first := b.updateID + 1
+	diff := &BookDiff{
+		MarketID:      marketID,
+		Symbol:        b.symbol,
+		FirstUpdateID: first,
+		Bids:          b.applySide(b.bids, bids, true),
+		Asks:          b.applySide(b.asks, asks, false),
+		Timestamp:     time.Now(),
+	}
+	diff.LastUpdateID = b.updateID
+	s.mu.Unlock()
+
+	if diff.LastUpdateID >= first {
+		s.events.Publish(Event{Type: EventBookDiff, MarketID: marketID, Symbol: diff.Symbol, BookDiff: diff})
+	}
+}
+
+// applySide must be called with s.mu held
+func (b *l2Book) applySide(side, levels map[float64]float64, desc bool) []repo.OrderBookEntry {
+	changed := []repo.OrderBookEntry{}
+	for price, amount := range levels {
+		if amount <= 1e-12 {
+			amount = 0
+		}
+		if side[price] == amount {
+			continue
+		}
+		if amount == 0 {
+			delete(side, price)
+		} else {
+			side[price] = amount
+		}
+		b.updateID++
+		changed = append(changed, repo.OrderBookEntry{Price: price, Amount: amount})
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		if desc {
+			return changed[i].Price > changed[j].Price
+		}
+		return changed[i].Price < changed[j].Price
+	})
+	return changed
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// Live candles of the larger intervals are assembled from three parts: the
+// rollup row of the bucket in progress (minutes already saved), the closed
+// minutes waiting for their settle delay, and the live 1m candle.
+
+// liveIntervals are the intervals streamed besides 1m
+func liveIntervals() []string {
+	return repo.CandleIntervals()[1:]
+}
+
+// loadPartials reads the saved part of the buckets containing the current minute
+func (s *CandleService) loadPartials(ctx context.Context) error {
+	partial := make(map[string]map[string]models.OHLCV)
+	for _, iv := range liveIntervals() {
+		rows, err := s.market.GetRollupBucket(ctx, iv, s.current)
+		if err != nil {
+			return err
+		}
+		bySymbol := make(map[string]models.OHLCV, len(rows))
+		for _, c := range rows {
+			bySymbol[c.Symbol] = c
+		}
+		partial[iv] = bySymbol
+	}
+	s.partial = partial
+	return nil
+}
+
+// liveCandles returns the in-progress candles of an interval given the live 1m candles
+func (s *CandleService) liveCandles(interval string, current []models.OHLCV) []models.OHLCV {
+	start, end, err := s.market.CandleBucket(interval, s.current)
+	if err != nil {
+		return nil
+	}
+
+	var closing []time.Time
+	for minute := range s.closing {
+		if !minute.Before(start) && minute.Before(end) {
+			closing = append(closing, minute)
+		}
+	}
+	sort.Slice(closing, func(i, j int) bool { return closing[i].Before(closing[j]) })
+
+	live := make([]models.OHLCV, 0, len(current))
+	for _, c := range current {
+		var candle models.OHLCV
+		have := false
+		if p, ok := s.partial[interval][c.Symbol]; ok && p.OpenTime.Equal(start) {
+			candle, have = p, true
+		}
+		for _, minute := range closing {
+			if m, ok := s.closing[minute][c.Symbol]; ok {
+				have = mergeCandle(&candle, have, m)
+			}
+		}
+		have = mergeCandle(&candle, have, c)
+		if !have {
+			continue
+		}
+
+		candle.Symbol = c.Symbol
+		candle.OpenTime, candle.CloseTime = start, end
+		live = append(live, candle)
+	}
+	return live
+}
+
+// mergeCandle extends candle with the next candle in time order; candles
+// without a price (market never traded) are skipped
+func mergeCandle(candle *models.OHLCV, have bool, next models.OHLCV) bool {
+	if next.Close == 0 {
+		return have
+	}
+	if !have {
+		*candle = next
+		return true
+	}
+	if next.High > candle.High {
+		candle.High = next.High
+	}
+	if next.Low < candle.Low {
+		candle.Low = next.Low
+	}
+	candle.Close = next.Close
+	candle.Volume += next.Volume
+	if next.LastTradeSeq > 0 {
+		candle.LastTradeSeq = next.LastTradeSeq
+	}
+	return true
+}
+
+// publishClosed sends the final candles of a saved minute and of every bucket
+// it completes. A minute saved again after a late commit re-sends them corrected.
+func (s *CandleService) publishClosed(ctx context.Context, minute time.Time, saved []models.OHLCV) error {
+	if len(saved) > 0 {
+		s.events.Publish(Event{Type: EventCandles, Interval: "1m", Closed: true, Candles: saved})
+	}
+
+	for _, iv := range liveIntervals() {
+		_, end, err := s.market.CandleBucket(iv, minute)
+		if err != nil {
+			return err
+		}
+		if !end.Equal(minute.Add(time.Minute)) {
+			continue
+		}
+
+		candles, err := s.market.GetRollupBucket(ctx, iv, minute)
+		if err != nil {
+			return err
+		}
+		if len(candles) > 0 {
+			s.events.Publish(Event{Type: EventCandles, Interval: iv, Closed: true, Candles: candles})
+		}
+	}
+	return nil
+}
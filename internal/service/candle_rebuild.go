@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// ErrInvalidRebuildRange is returned when a rebuild range is empty
+var ErrInvalidRebuildRange = errors.New("rebuild range is empty")
+
+// CandleRebuildOptions controls a candle rebuild
+type CandleRebuildOptions struct {
+	DryRun   bool                        // Compute the diff without writing
+	Chunk    time.Duration               // Range processed per step (default 24h)
+	Progress func(CandleRebuildProgress) // Called after each chunk (optional)
+}
+
+// CandleRebuildProgress reports how far a rebuild got
+type CandleRebuildProgress struct {
+	Symbol    string
+	ChunkEnd  time.Time
+	Processed int // Minutes processed so far
+	Total     int // Minutes in the whole range
+}
+
+// CandleDiff is a 1m candle whose rebuilt value differs from the saved one
+type CandleDiff struct {
+	OpenTime time.Time     `json:"openTime"`
+	Old      *models.OHLCV `json:"old"` // nil when the minute was missing
+	New      models.OHLCV  `json:"new"`
+}
+
+// CandleRebuildResult summarizes a rebuild
+type CandleRebuildResult struct {
+	Symbol    string       `json:"symbol"`
+	Minutes   int          `json:"minutes"`   // Candles rebuilt
+	Missing   int          `json:"missing"`   // Minutes that had no saved candle
+	Changed   int          `json:"changed"`   // Saved candles that differed
+	Unchanged int          `json:"unchanged"` // Saved candles already correct
+	Diffs     []CandleDiff `json:"diffs"`
+}
+
+// RebuildCandles recomputes the 1m candles of a symbol for [from, to) from the
+// trades table, fills quiet minutes with the previous close and upserts them,
+// then rolls the range up into the larger intervals. Running it again is a no-op.
+// With DryRun set nothing is written and the result lists the differences.
+func RebuildCandles(ctx context.Context, mr *repo.MarketRepo, symbol string, from, to time.Time, opts CandleRebuildOptions) (*CandleRebuildResult, error) {
+	from = from.Truncate(time.Minute)
+	to = to.Truncate(time.Minute)
+	if !from.Before(to) {
+		return nil, ErrInvalidRebuildRange
+	}
+	if opts.Chunk <= 0 {
+		opts.Chunk = candleBackfillChunk
+	}
+
+	// Quiet minutes at the start continue from the last trade before the range
+	var prevClose float64
+	last, err := mr.GetLastTradePriceBefore(ctx, symbol, from)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		prevClose = *last
+	}
+
+	result := &CandleRebuildResult{Symbol: symbol}
+	total := int(to.Sub(from) / time.Minute)
+	processed := 0
+
+	for chunkStart := from; chunkStart.Before(to); chunkStart = chunkStart.Add(opts.Chunk) {
+		chunkEnd := chunkStart.Add(opts.Chunk)
+		if chunkEnd.After(to) {
+			chunkEnd = to
+		}
+
+		traded, err := mr.AggregateTrades1m(ctx, symbol, chunkStart, chunkEnd, 0)
+		if err != nil {
+			return nil, err
+		}
+		rebuilt := fillQuietMinutes(symbol, traded, chunkStart, chunkEnd, &prevClose)
+
+		saved, err := mr.Get1mCandlesRange(ctx, symbol, chunkStart, chunkEnd)
+		if err != nil {
+			return nil, err
+		}
+		result.diff(saved, rebuilt)
+
+		if !opts.DryRun {
+			if err := mr.SaveOHLCVBatch(ctx, rebuilt); err != nil {
+				return nil, err
+			}
+			if err := mr.RollupCandles(ctx, symbol, chunkStart, chunkEnd); err != nil {
+				return nil, err
+			}
+		}
+
+		processed += int(chunkEnd.Sub(chunkStart) / time.Minute)
+		if opts.Progress != nil {
+			opts.Progress(CandleRebuildProgress{
+				Symbol:    symbol,
+				ChunkEnd:  chunkEnd,
+				Processed: processed,
+				Total:     total,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func (r *CandleRebuildResult) diff(saved, rebuilt []models.OHLCV) {
+	byMinute := make(map[time.Time]models.OHLCV, len(saved))
+	for _, c := range saved {
+		byMinute[c.OpenTime.UTC()] = c
+	}
+
+	for _, c := range rebuilt {
+		r.Minutes++
+		old, ok := byMinute[c.OpenTime.UTC()]
+		switch {
+		case !ok:
+			r.Missing++
+			r.Diffs = append(r.Diffs, CandleDiff{OpenTime: c.OpenTime, New: c})
+		case !sameCandle(old, c):
+			r.Changed++
+			r.Diffs = append(r.Diffs, CandleDiff{OpenTime: c.OpenTime, Old: &old, New: c})
+		default:
+			r.Unchanged++
+		}
+	}
+}
+
+func sameCandle(a, b models.OHLCV) bool {
+	return sameValue(a.Open, b.Open) && sameValue(a.High, b.High) && sameValue(a.Low, b.Low) &&
+		sameValue(a.Close, b.Close) && sameValue(a.Volume, b.Volume)
+}
+
+// sameValue compares prices read back from NUMERIC columns
+func sameValue(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
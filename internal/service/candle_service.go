@@ -0,0 +1,510 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+const (
+	candlePollInterval      = 1 * time.Second
+	candleBroadcastInterval = 2 * time.Second
+	candleSettleDelay       = 3 * time.Second  // wait after a minute closes before saving it, for late commits
+	candleGapTimeout        = 10 * time.Second // a sequence missing this long belongs to a rolled back transaction
+	candleBackfillChunk     = 24 * time.Hour
+	candleRollupChunk       = 7 * 24 * time.Hour
+	candleTradeBatch        = 1000
+	candleMaxGap            = 1000 // most sequences of one gap retried; older ones are given up
+)
+
+// CandleService builds 1-minute candles from the trade stream.
+//
+// Live candles are updated from trades in sequence order, applying each
+// sequence number exactly once; sequences that are missing (not committed yet)
+// are retried until they show up or time out. Closed minutes are saved from an
+// authoritative aggregation of the trades table, so a late commit only causes
+// the minute to be saved again. Saved minutes are rolled up into the larger
+// intervals. On start, minutes missed while the process was down are
+// backfilled, with flat candles for quiet minutes.
+type CandleService struct {
+	market *repo.MarketRepo
+	store  CandleStore
+	events *EventBus
+
+	symbols      []string
+	current      time.Time           // minute of the live candles
+	highSeq      int64               // highest applied trade sequence
+	missing      map[int64]time.Time // sequences below highSeq not seen yet -> first noticed
+	pendingFlush map[time.Time]time.Time
+	dirty        bool
+
+	closing map[time.Time]map[string]models.OHLCV // closed minutes not saved yet -> live 1m candles
+	partial map[string]map[string]models.OHLCV    // interval -> symbol -> saved part of the bucket
+}
+
+// NewCandleService creates a candle service keeping live candles in store; call Start to run it
+func NewCandleService(mr *repo.MarketRepo, store CandleStore, events *EventBus) *CandleService {
+	return &CandleService{
+		market:       mr,
+		store:        store,
+		events:       events,
+		missing:      make(map[int64]time.Time),
+		pendingFlush: make(map[time.Time]time.Time),
+		closing:      make(map[time.Time]map[string]models.OHLCV),
+	}
+}
+
+// Start backfills missed minutes and runs the aggregation loop in the background
+func (s *CandleService) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+func (s *CandleService) run(ctx context.Context) {
+	// Subscribe first so trades committed during init still wake us up
+	ch, unsubscribe := s.events.Subscribe(256)
+	defer unsubscribe()
+
+	for {
+		err := s.init(ctx)
+		if err == nil {
+			break
+		}
+		log.Printf("Candle service init failed: %v (retrying)", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	log.Println("Candle service started")
+
+	poll := time.NewTicker(candlePollInterval)
+	defer poll.Stop()
+	broadcast := time.NewTicker(candleBroadcastInterval)
+	defer broadcast.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev := <-ch:
+			if ev.Type == EventTrades {
+				s.roll(ctx, time.Now())
+				s.poll(ctx)
+				s.publish(ctx, false)
+			}
+
+		case now := <-poll.C:
+			s.roll(ctx, now)
+			s.poll(ctx)
+			s.flushDue(ctx, now)
+			s.publish(ctx, false)
+
+		case <-broadcast.C:
+			// Always broadcast current candle state (even if no trades)
+			s.publish(ctx, true)
+		}
+	}
+}
+
+// init backfills closed minutes up to now and seeds the live candles
+func (s *CandleService) init(ctx context.Context) error {
+	if err := s.loadSymbols(ctx); err != nil {
+		return err
+	}
+
+	highSeq, err := s.market.GetMaxTradeSeq(ctx)
+	if err != nil {
+		return err
+	}
+	s.highSeq = highSeq
+	s.current = time.Now().Truncate(time.Minute)
+
+	for _, symbol := range s.symbols {
+		if err := s.backfill(ctx, symbol, s.current); err != nil {
+			return err
+		}
+		if err := s.catchUpRollups(ctx, symbol, s.current); err != nil {
+			return err
+		}
+	}
+
+	live, err := s.market.AggregateTrades1m(ctx, "", s.current, s.current.Add(time.Minute), s.highSeq)
+	if err != nil {
+		return err
+	}
+	seeded := make(map[string]bool)
+	for i := range live {
+		if err := s.store.SetCandle(ctx, live[i].Symbol, &live[i]); err != nil {
+			return err
+		}
+		seeded[live[i].Symbol] = true
+	}
+	for _, symbol := range s.symbols {
+		if seeded[symbol] {
+			continue
+		}
+		if err := s.resetFromHistory(ctx, symbol, s.current); err != nil {
+			return err
+		}
+	}
+	s.store.RemoveStaleCandles(ctx, s.symbols)
+	s.dirty = true
+	return s.loadPartials(ctx)
+}
+
+func (s *CandleService) loadSymbols(ctx context.Context) error {
+	markets, err := s.market.GetAllActiveMarkets(ctx)
+	if err != nil {
+		return err
+	}
+	symbols := make([]string, 0, len(markets))
+	for _, m := range markets {
+		symbols = append(symbols, m.Symbol)
+	}
+	sort.Strings(symbols)
+	s.symbols = symbols
+	return nil
+}
+
+// backfill saves every closed minute after the last saved candle, up to until
+func (s *CandleService) backfill(ctx context.Context, symbol string, until time.Time) error {
+	last, err := s.market.GetLastCandleBefore(ctx, symbol, until)
+	if err != nil {
+		return err
+	}
+
+	var from time.Time
+	var prevClose float64
+	if last != nil {
+		from = last.OpenTime.Add(time.Minute)
+		prevClose = last.Close
+	} else {
+		first, err := s.market.GetFirstTradeTime(ctx, symbol)
+		if err != nil {
+			return err
+		}
+		if first == nil {
+			return nil // Never traded, nothing to backfill
+		}
+		from = first.Truncate(time.Minute)
+	}
+	if !from.Before(until) {
+		return nil
+	}
+
+	log.Printf("[CandleService] Backfilling %s from %v to %v", symbol, from, until)
+
+	total := 0
+	for chunkStart := from; chunkStart.Before(until); chunkStart = chunkStart.Add(candleBackfillChunk) {
+		chunkEnd := chunkStart.Add(candleBackfillChunk)
+		if chunkEnd.After(until) {
+			chunkEnd = until
+		}
+
+		traded, err := s.market.AggregateTrades1m(ctx, symbol, chunkStart, chunkEnd, 0)
+		if err != nil {
+			return err
+		}
+		candles := fillQuietMinutes(symbol, traded, chunkStart, chunkEnd, &prevClose)
+		if err := s.market.SaveOHLCVBatch(ctx, candles); err != nil {
+			return err
+		}
+		total += len(candles)
+	}
+
+	log.Printf("[CandleService] Backfilled %d candles for %s", total, symbol)
+	return nil
+}
+
+// catchUpRollups rolls up the saved 1m candles from the last materialized
+// bucket (or the first candle) up to until
+func (s *CandleService) catchUpRollups(ctx context.Context, symbol string, until time.Time) error {
+	from, err := s.market.GetLastRollupTime(ctx, symbol)
+	if err != nil {
+		return err
+	}
+	if from == nil {
+		if from, err = s.market.GetFirstCandleTime(ctx, symbol); err != nil || from == nil {
+			return err
+		}
+	}
+	if !from.Before(until) {
+		return nil
+	}
+
+	for chunkStart := *from; chunkStart.Before(until); chunkStart = chunkStart.Add(candleRollupChunk) {
+		chunkEnd := chunkStart.Add(candleRollupChunk)
+		if chunkEnd.After(until) {
+			chunkEnd = until
+		}
+		if err := s.market.RollupCandles(ctx, symbol, chunkStart, chunkEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillQuietMinutes returns one candle per minute in [from, to): traded minutes
+// as aggregated, quiet minutes flat at the previous close. prevClose is carried
+// over; quiet minutes before any known price (prevClose 0) are left out.
+func fillQuietMinutes(symbol string, traded []models.OHLCV, from, to time.Time, prevClose *float64) []models.OHLCV {
+	byMinute := make(map[time.Time]models.OHLCV, len(traded))
+	for _, c := range traded {
+		byMinute[c.OpenTime.UTC()] = c
+	}
+
+	var out []models.OHLCV
+	for m := from; m.Before(to); m = m.Add(time.Minute) {
+		c, ok := byMinute[m.UTC()]
+		if !ok {
+			if *prevClose == 0 {
+				continue
+			}
+			c = flatCandle(symbol, m, *prevClose)
+		}
+		*prevClose = c.Close
+		out = append(out, c)
+	}
+	return out
+}
+
+func flatCandle(symbol string, minute time.Time, price float64) models.OHLCV {
+	return models.OHLCV{
+		Symbol:    symbol,
+		OpenTime:  minute,
+		CloseTime: minute.Add(time.Minute),
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Close:     price,
+	}
+}
+
+// roll starts new live candles when the wall clock enters a new minute
+// and schedules the closed minutes for saving
+func (s *CandleService) roll(ctx context.Context, now time.Time) {
+	minute := now.Truncate(time.Minute)
+	if !minute.After(s.current) {
+		return
+	}
+
+	for m := s.current; m.Before(minute); m = m.Add(time.Minute) {
+		s.pendingFlush[m] = m.Add(time.Minute + candleSettleDelay)
+	}
+	closed := s.current
+	s.current = minute
+
+	// Pick up markets listed or delisted since the last minute
+	if err := s.loadSymbols(ctx); err != nil {
+		log.Printf("[CandleService] Error loading markets: %v", err)
+	}
+
+	// Keep the closed minute's live candles until it is saved, the larger
+	// intervals still need them
+	closing := make(map[string]models.OHLCV)
+	for _, symbol := range s.symbols {
+		candle, err := s.store.GetCandle(ctx, symbol)
+		if err != nil || candle == nil {
+			if err := s.resetFromHistory(ctx, symbol, minute); err != nil {
+				log.Printf("[CandleService] Error initializing candle for %s: %v", symbol, err)
+			}
+			continue
+		}
+		if candle.OpenTime.Equal(closed) {
+			closing[symbol] = *candle
+		}
+		// Reset candle for new minute (use last close as new open)
+		s.store.ResetCandle(ctx, symbol, minute, candle.Close)
+	}
+	s.closing[closed] = closing
+	s.store.RemoveStaleCandles(ctx, s.symbols)
+	s.dirty = true
+}
+
+// resetFromHistory opens a flat live candle at the last saved close
+func (s *CandleService) resetFromHistory(ctx context.Context, symbol string, minute time.Time) error {
+	var price float64
+	last, err := s.market.GetLastCandleBefore(ctx, symbol, minute)
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		price = last.Close
+	}
+	return s.store.ResetCandle(ctx, symbol, minute, price)
+}
+
+// poll applies newly committed trades in sequence order
+func (s *CandleService) poll(ctx context.Context) {
+	now := time.Now()
+
+	// Retry sequences that were skipped, they may have committed since
+	if len(s.missing) > 0 {
+		seqs := make([]int64, 0, len(s.missing))
+		for seq, since := range s.missing {
+			if now.Sub(since) > candleGapTimeout {
+				delete(s.missing, seq) // Rolled back, never coming
+				continue
+			}
+			seqs = append(seqs, seq)
+		}
+		if len(seqs) > 0 {
+			trades, err := s.market.GetTradesBySeq(ctx, seqs)
+			if err != nil {
+				log.Printf("[CandleService] Error fetching late trades: %v", err)
+			}
+			for _, t := range trades {
+				delete(s.missing, t.Seq)
+				s.apply(ctx, t)
+			}
+		}
+	}
+
+	for {
+		trades, err := s.market.GetTradesAfterSeq(ctx, s.highSeq, candleTradeBatch)
+		if err != nil {
+			log.Printf("[CandleService] Error fetching trades: %v", err)
+			return
+		}
+
+		for _, t := range trades {
+			s.noteGap(t.Seq, now)
+			s.highSeq = t.Seq
+			s.apply(ctx, t)
+		}
+
+		if len(trades) < candleTradeBatch {
+			return
+		}
+	}
+}
+
+// noteGap records the sequences between highSeq and seq as missing. Only the
+// last candleMaxGap are kept: a larger gap means sequence values were skipped
+// (e.g. a sequence restart), not transactions still in flight.
+func (s *CandleService) noteGap(seq int64, now time.Time) {
+	from := s.highSeq + 1
+	if gap := seq - from; gap > candleMaxGap {
+		log.Printf("[CandleService] %d trade sequences missing before %d, retrying only the last %d", gap, seq, candleMaxGap)
+		from = seq - candleMaxGap
+	}
+	for ; from < seq; from++ {
+		s.missing[from] = now
+	}
+}
+
+func (s *CandleService) apply(ctx context.Context, t repo.Trade) {
+	if !t.MarketActive {
+		return // Takes its sequence number, but has no candles
+	}
+	minute := t.TradeTime.Truncate(time.Minute)
+
+	if minute.Before(s.current) {
+		// Committed after its minute closed: save that minute again
+		if _, ok := s.pendingFlush[minute]; !ok {
+			s.pendingFlush[minute] = time.Now().Add(candleSettleDelay)
+		}
+		return
+	}
+
+	if err := s.store.UpdateCandleWithTrade(ctx, t, s.current); err != nil {
+		log.Printf("[CandleService] Error updating candle for %s: %v", t.Symbol, err)
+		return
+	}
+	s.dirty = true
+}
+
+// flushDue saves closed minutes whose settle delay has passed
+func (s *CandleService) flushDue(ctx context.Context, now time.Time) {
+	var due []time.Time
+	for minute, at := range s.pendingFlush {
+		if !now.Before(at) {
+			due = append(due, minute)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Before(due[j]) })
+
+	for _, minute := range due {
+		if err := s.flushMinute(ctx, minute); err != nil {
+			log.Printf("[CandleService] Error saving candles for %v: %v (will retry)", minute, err)
+			return
+		}
+		delete(s.pendingFlush, minute)
+		delete(s.closing, minute)
+	}
+	if len(due) > 0 {
+		if err := s.loadPartials(ctx); err != nil {
+			log.Printf("[CandleService] Error loading candle rollups: %v", err)
+		}
+		s.dirty = true
+	}
+}
+
+// flushMinute saves the candles of one closed minute for all markets
+func (s *CandleService) flushMinute(ctx context.Context, minute time.Time) error {
+	traded, err := s.market.AggregateTrades1m(ctx, "", minute, minute.Add(time.Minute), 0)
+	if err != nil {
+		return err
+	}
+	bySymbol := make(map[string]models.OHLCV, len(traded))
+	for _, c := range traded {
+		bySymbol[c.Symbol] = c
+	}
+
+	candles := make([]models.OHLCV, 0, len(s.symbols))
+	for _, symbol := range s.symbols {
+		c, ok := bySymbol[symbol]
+		if !ok {
+			last, err := s.market.GetLastCandleBefore(ctx, symbol, minute)
+			if err != nil {
+				return err
+			}
+			if last == nil {
+				continue // Never traded
+			}
+			c = flatCandle(symbol, minute, last.Close)
+		}
+		candles = append(candles, c)
+	}
+
+	if err := s.market.SaveOHLCVBatch(ctx, candles); err != nil {
+		return err
+	}
+	if err := s.market.RollupCandles(ctx, "", minute, minute.Add(time.Minute)); err != nil {
+		return err
+	}
+	log.Printf("[CandleService] Saved %d candles for %v", len(candles), minute)
+	return s.publishClosed(ctx, minute, candles)
+}
+
+// publish sends the live candles of every interval to subscribers when they
+// changed (or always if forced)
+func (s *CandleService) publish(ctx context.Context, force bool) {
+	if !s.dirty && !force {
+		return
+	}
+
+	candles, err := s.store.GetAllCandles(ctx)
+	if err != nil {
+		log.Printf("[CandleService] Error getting candles: %v", err)
+		return
+	}
+	s.dirty = false
+
+	if len(candles) == 0 {
+		return
+	}
+	s.events.Publish(Event{Type: EventCandles, Interval: "1m", Candles: candles})
+	for _, iv := range liveIntervals() {
+		if live := s.liveCandles(iv, candles); len(live) > 0 {
+			s.events.Publish(Event{Type: EventCandles, Interval: iv, Candles: live})
+		}
+	}
+}
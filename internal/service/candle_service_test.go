@@ -0,0 +1,151 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+)
+
+func TestFillQuietMinutes(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	minute := func(i int) time.Time { return base.Add(time.Duration(i) * time.Minute) }
+	traded := func(i int, open, high, low, close, volume float64) models.OHLCV {
+		return models.OHLCV{
+			Symbol: "BTC/USDT", OpenTime: minute(i), CloseTime: minute(i + 1),
+			Open: open, High: high, Low: low, Close: close, Volume: volume,
+		}
+	}
+	flat := func(i int, price float64) models.OHLCV { return flatCandle("BTC/USDT", minute(i), price) }
+
+	tests := []struct {
+		name      string
+		traded    []models.OHLCV
+		from, to  int
+		prevClose float64
+		want      []models.OHLCV
+		wantClose float64
+	}{
+		{
+			name:      "every minute traded",
+			traded:    []models.OHLCV{traded(0, 100, 110, 95, 105, 2), traded(1, 105, 106, 101, 102, 1)},
+			from:      0,
+			to:        2,
+			want:      []models.OHLCV{traded(0, 100, 110, 95, 105, 2), traded(1, 105, 106, 101, 102, 1)},
+			wantClose: 102,
+		},
+		{
+			name:      "gaps flat at the previous close",
+			traded:    []models.OHLCV{traded(0, 100, 110, 95, 105, 2), traded(3, 104, 108, 103, 107, 1)},
+			from:      0,
+			to:        5,
+			want:      []models.OHLCV{traded(0, 100, 110, 95, 105, 2), flat(1, 105), flat(2, 105), traded(3, 104, 108, 103, 107, 1), flat(4, 107)},
+			wantClose: 107,
+		},
+		{
+			name:      "leading gap uses the carried close",
+			traded:    []models.OHLCV{traded(2, 90, 91, 89, 91, 1)},
+			from:      0,
+			to:        3,
+			prevClose: 88,
+			want:      []models.OHLCV{flat(0, 88), flat(1, 88), traded(2, 90, 91, 89, 91, 1)},
+			wantClose: 91,
+		},
+		{
+			name:      "no price before the first trade",
+			traded:    []models.OHLCV{traded(2, 90, 91, 89, 91, 1)},
+			from:      0,
+			to:        4,
+			want:      []models.OHLCV{traded(2, 90, 91, 89, 91, 1), flat(3, 91)},
+			wantClose: 91,
+		},
+		{
+			name:      "quiet chunk",
+			from:      0,
+			to:        3,
+			prevClose: 50,
+			want:      []models.OHLCV{flat(0, 50), flat(1, 50), flat(2, 50)},
+			wantClose: 50,
+		},
+		{
+			name: "never traded",
+			from: 0,
+			to:   3,
+		},
+		{
+			name:      "trades outside the range are ignored",
+			traded:    []models.OHLCV{traded(-1, 10, 10, 10, 10, 1), traded(1, 20, 20, 20, 20, 1), traded(3, 30, 30, 30, 30, 1)},
+			from:      0,
+			to:        3,
+			prevClose: 5,
+			want:      []models.OHLCV{flat(0, 5), traded(1, 20, 20, 20, 20, 1), flat(2, 20)},
+			wantClose: 20,
+		},
+		{
+			name:      "empty range",
+			traded:    []models.OHLCV{traded(0, 10, 10, 10, 10, 1)},
+			from:      0,
+			to:        0,
+			prevClose: 5,
+			wantClose: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevClose := tt.prevClose
+			got := fillQuietMinutes("BTC/USDT", tt.traded, minute(tt.from), minute(tt.to), &prevClose)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fillQuietMinutes =\n%+v\nwant\n%+v", got, tt.want)
+			}
+			if prevClose != tt.wantClose {
+				t.Errorf("prevClose = %v, want %v", prevClose, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestFillQuietMinutesMatchesOtherZones(t *testing.T) {
+	// Candles read back from Postgres may carry a local zone
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	local := from.Add(time.Minute).In(time.FixedZone("ICT", 7*3600))
+	traded := []models.OHLCV{{Symbol: "BTC/USDT", OpenTime: local, CloseTime: local.Add(time.Minute), Open: 2, High: 2, Low: 2, Close: 2, Volume: 1}}
+
+	prevClose := 1.0
+	got := fillQuietMinutes("BTC/USDT", traded, from, from.Add(2*time.Minute), &prevClose)
+	if len(got) != 2 || got[1].Volume != 1 || prevClose != 2 {
+		t.Errorf("fillQuietMinutes = %+v, prevClose %v; want the traded candle second", got, prevClose)
+	}
+}
+
+func TestCandleNoteGap(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		highSeq   int64
+		seq       int64
+		wantCount int
+		wantFirst int64
+	}{
+		{"no gap", 10, 11, 0, 0},
+		{"small gap", 10, 14, 3, 11},
+		{"gap at the cap", 0, candleMaxGap + 1, candleMaxGap, 1},
+		{"gap past the cap", 0, 5 * candleMaxGap, candleMaxGap, 4 * candleMaxGap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &CandleService{highSeq: tt.highSeq, missing: make(map[int64]time.Time)}
+			s.noteGap(tt.seq, now)
+
+			if len(s.missing) != tt.wantCount {
+				t.Fatalf("missing %d sequences, want %d", len(s.missing), tt.wantCount)
+			}
+			for seq := tt.wantFirst; tt.wantCount > 0 && seq < tt.seq; seq++ {
+				if _, ok := s.missing[seq]; !ok {
+					t.Errorf("sequence %d not missing", seq)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// CandleStore holds the live 1m candle of each symbol.
+// CacheService implements it on Redis; MemoryCandleStore keeps candles in process.
+type CandleStore interface {
+	GetCandle(ctx context.Context, symbol string) (*models.OHLCV, error)
+	SetCandle(ctx context.Context, symbol string, candle *models.OHLCV) error
+	GetAllCandles(ctx context.Context) ([]models.OHLCV, error)
+	ResetCandle(ctx context.Context, symbol string, newMinute time.Time, lastClose float64) error
+	UpdateCandleWithTrade(ctx context.Context, trade repo.Trade, currentMinute time.Time) error
+	RemoveStaleCandles(ctx context.Context, activeSymbols []string) error
+}
+
+var (
+	_ CandleStore = (*CacheService)(nil)
+	_ CandleStore = (*MemoryCandleStore)(nil)
+)
+
+// MemoryCandleStore is an in-process CandleStore for single-node deployments
+// running without Redis
+type MemoryCandleStore struct {
+	candles map[string]*models.OHLCV // symbol -> current candle
+	mu      sync.RWMutex
+}
+
+// NewMemoryCandleStore creates an empty in-process candle store
+func NewMemoryCandleStore() *MemoryCandleStore {
+	return &MemoryCandleStore{candles: make(map[string]*models.OHLCV)}
+}
+
+// GetCandle returns a copy of the current candle for a symbol (nil if none)
+func (s *MemoryCandleStore) GetCandle(ctx context.Context, symbol string) (*models.OHLCV, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candle, ok := s.candles[symbol]
+	if !ok {
+		return nil, nil
+	}
+	c := *candle
+	return &c, nil
+}
+
+// SetCandle stores the current candle for a symbol
+func (s *MemoryCandleStore) SetCandle(ctx context.Context, symbol string, candle *models.OHLCV) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *candle
+	s.candles[symbol] = &c
+	return nil
+}
+
+// GetAllCandles returns copies of all current candles
+func (s *MemoryCandleStore) GetAllCandles(ctx context.Context) ([]models.OHLCV, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candles := make([]models.OHLCV, 0, len(s.candles))
+	for _, candle := range s.candles {
+		candles = append(candles, *candle)
+	}
+	return candles, nil
+}
+
+// ResetCandle resets a candle for a new time period
+func (s *MemoryCandleStore) ResetCandle(ctx context.Context, symbol string, newMinute time.Time, lastClose float64) error {
+	return s.SetCandle(ctx, symbol, &models.OHLCV{
+		Symbol:    symbol,
+		OpenTime:  newMinute,
+		CloseTime: newMinute.Add(time.Minute),
+		Open:      lastClose,
+		High:      lastClose,
+		Low:       lastClose,
+		Close:     lastClose,
+	})
+}
+
+// UpdateCandleWithTrade updates the current candle with a trade, same rules as CacheService
+func (s *MemoryCandleStore) UpdateCandleWithTrade(ctx context.Context, trade repo.Trade, currentMinute time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candle, ok := s.candles[trade.Symbol]
+	if !ok {
+		s.candles[trade.Symbol] = &models.OHLCV{
+			Symbol:    trade.Symbol,
+			OpenTime:  currentMinute,
+			CloseTime: currentMinute.Add(time.Minute),
+			Open:      trade.Price,
+			High:      trade.Price,
+			Low:       trade.Price,
+			Close:     trade.Price,
+			Volume:    trade.QuoteAmount,
+
+			LastTradeSeq: trade.Seq,
+		}
+		return nil
+	}
+
+	if trade.Price > candle.High {
+		candle.High = trade.Price
+	}
+	if trade.Price < candle.Low {
+		candle.Low = trade.Price
+	}
+	if trade.Seq > candle.LastTradeSeq {
+		candle.Close = trade.Price
+		candle.LastTradeSeq = trade.Seq
+	}
+	candle.Volume += trade.QuoteAmount
+	return nil
+}
+
+// RemoveStaleCandles removes candles for inactive symbols
+func (s *MemoryCandleStore) RemoveStaleCandles(ctx context.Context, activeSymbols []string) error {
+	active := make(map[string]bool, len(activeSymbols))
+	for _, symbol := range activeSymbols {
+		active[symbol] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for symbol := range s.candles {
+		if !active[symbol] {
+			delete(s.candles, symbol)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Examples from RFC 8949 appendix A, plus a COSE key as found in WebAuthn
+// attestation objects
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name  string
+		hex   string
+		want  interface{}
+		wantN int // Bytes read, 0 for the whole input
+	}{
+		{"zero", "00", int64(0), 0},
+		{"small int", "17", int64(23), 0},
+		{"one-byte int", "1818", int64(24), 0},
+		{"two-byte int", "1903e8", int64(1000), 0},
+		{"four-byte int", "1a000f4240", int64(1000000), 0},
+		{"eight-byte int", "1b000000e8d4a51000", int64(1000000000000), 0},
+		{"max int64", "1b7fffffffffffffff", int64(math.MaxInt64), 0},
+		{"minus one", "20", int64(-1), 0},
+		{"negative int", "3863", int64(-100), 0},
+		{"two-byte negative int", "3903e7", int64(-1000), 0},
+		{"empty byte string", "40", []byte{}, 0},
+		{"byte string", "4401020304", []byte{1, 2, 3, 4}, 0},
+		{"empty text", "60", "", 0},
+		{"text", "6449455446", "IETF", 0},
+		{"utf-8 text", "62c3bc", "ü", 0},
+		{"empty array", "80", []interface{}{}, 0},
+		{"array", "83010203", []interface{}{int64(1), int64(2), int64(3)}, 0},
+		{"nested array", "8301820203820405", []interface{}{
+			int64(1),
+			[]interface{}{int64(2), int64(3)},
+			[]interface{}{int64(4), int64(5)},
+		}, 0},
+		{"empty map", "a0", map[interface{}]interface{}{}, 0},
+		{"int keys", "a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}, 0},
+		{"text keys", "a26161016162820203", map[interface{}]interface{}{
+			"a": int64(1),
+			"b": []interface{}{int64(2), int64(3)},
+		}, 0},
+		{"false", "f4", false, 0},
+		{"true", "f5", true, 0},
+		{"null", "f6", nil, 0},
+		{"undefined", "f7", nil, 0},
+		{"half float", "f93c00", 1.0, 0},
+		{"negative half float", "f9c400", -4.0, 0},
+		{"subnormal half float", "f90001", 5.960464477539063e-8, 0},
+		{"half float infinity", "f97c00", math.Inf(1), 0},
+		{"single float", "fa47c35000", 100000.0, 0},
+		{"double float", "fb3ff199999999999a", 1.1, 0},
+		{"tag is skipped", "c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z", 0},
+		{"trailing bytes are left", "0102", int64(1), 1},
+		{"COSE EC2 key", "a5010203262001215820" + strings.Repeat("11", 32) + "225820" + strings.Repeat("22", 32),
+			map[interface{}]interface{}{
+				int64(1):  int64(2),  // kty: EC2
+				int64(3):  int64(-7), // alg: ES256
+				int64(-1): int64(1),  // crv: P-256
+				int64(-2): bytes.Repeat([]byte{0x11}, 32),
+				int64(-3): bytes.Repeat([]byte{0x22}, 32),
+			}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, n, err := decodeCBOR(data)
+			if err != nil {
+				t.Fatalf("decodeCBOR error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCBOR = %#v, want %#v", got, tt.want)
+			}
+			wantN := tt.wantN
+			if wantN == 0 {
+				wantN = len(data)
+			}
+			if n != wantN {
+				t.Errorf("read %d bytes, want %d", n, wantN)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORHalfFloatNaN(t *testing.T) {
+	got, _, err := decodeCBOR([]byte{0xf9, 0x7e, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := got.(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("decodeCBOR = %#v, want NaN", got)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"empty", ""},
+		{"truncated argument", "1903"},
+		{"truncated text", "6261"},
+		{"truncated byte string", "4401"},
+		{"uint above int64", "1bffffffffffffffff"},
+		{"negative below int64", "3bffffffffffffffff"},
+		{"indefinite length", "5f"},
+		{"reserved additional info", "1c"},
+		{"array longer than input", "8401"},
+		{"map longer than input", "a301"},
+		{"map missing value", "a101"},
+		{"byte string key", "a14001"},
+		{"array key", "a18001"},
+		{"simple value in next byte", "f820"},
+		{"too deep", strings.Repeat("81", cborMaxDepth+1) + "00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, _, err := decodeCBOR(data); err != errCBOR {
+				t.Errorf("decodeCBOR = (%#v, %v), want errCBOR", v, err)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORMaxDepth(t *testing.T) {
+	data, _ := hex.DecodeString(strings.Repeat("81", cborMaxDepth) + "00")
+	if _, _, err := decodeCBOR(data); err != nil {
+		t.Errorf("nesting of %d arrays: %v", cborMaxDepth, err)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// eventBridgeChannel is the Redis pub/sub channel shared by all API instances
+const eventBridgeChannel = "events"
+
+// bridgedEvents are the events other instances cannot derive themselves:
+// the outcome of requests they did not serve, and the leader's candles.
+// Everything else (books, tickers, user streams) is derived on each instance.
+var bridgedEvents = map[EventType]bool{
+	EventTrades:      true,
+	EventBookChanged: true,
+	EventCandles:     true,
+	EventSecurity:    true,
+
+	EventSessionsRevoked: true,
+}
+
+// EventBridge relays events between the EventBus of every API instance
+// through Redis pub/sub, so that any instance delivers the same feeds.
+// Local events are published with this instance's id as origin; events
+// received from Redis are republished locally with their origin set, which
+// keeps them from being relayed again.
+type EventBridge struct {
+	client *redis.Client
+	events *EventBus
+	origin string
+}
+
+// NewEventBridge creates a bridge for the local bus
+func NewEventBridge(client *redis.Client, events *EventBus, instanceID string) *EventBridge {
+	return &EventBridge{client: client, events: events, origin: instanceID}
+}
+
+// Start subscribes to the Redis channel and relays events in both directions until ctx is done
+func (b *EventBridge) Start(ctx context.Context) error {
+	pubsub := b.client.Subscribe(ctx, eventBridgeChannel)
+	// Wait for the subscription so no remote event is missed after Start returns
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	local, unsubscribe := b.events.Subscribe(4096)
+	go b.publishLocal(ctx, local, unsubscribe)
+	go b.receiveRemote(ctx, pubsub)
+
+	log.Printf("Event bridge started (instance %s)", b.origin)
+	return nil
+}
+
+func (b *EventBridge) publishLocal(ctx context.Context, ch <-chan Event, unsubscribe func()) {
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-ch:
+			if ev.Origin != "" || !bridgedEvents[ev.Type] {
+				continue
+			}
+			ev.Origin = b.origin
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("EventBridge: error marshaling %s event: %v", ev.Type, err)
+				continue
+			}
+			if err := b.client.Publish(ctx, eventBridgeChannel, data).Err(); err != nil {
+				log.Printf("EventBridge: error publishing %s event: %v", ev.Type, err)
+			}
+		}
+	}
+}
+
+func (b *EventBridge) receiveRemote(ctx context.Context, pubsub *redis.PubSub) {
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var ev Event
+			if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+				log.Printf("EventBridge: error decoding event: %v", err)
+				continue
+			}
+			if ev.Origin == b.origin || ev.Origin == "" {
+				continue
+			}
+			b.events.Publish(ev)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"log"
+	"sync"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// EventType identifies what an Event carries
+type EventType string
+
+const (
+	EventTrades      EventType = "trades"       // Trades committed by one order placement
+	EventBookChanged EventType = "book_changed" // Resting orders of a market changed
+	EventTicker      EventType = "ticker"       // 24h ticker statistics changed
+	EventCandles     EventType = "candles"      // Live candles of one interval changed or closed
+	EventBookDiff    EventType = "book_diff"    // L2 levels changed, with update ids
+	EventL3          EventType = "l3"           // Order-by-order book events, with sequence numbers
+
+	// Private events, delivered only to UserID
+	EventUserOrders   EventType = "user_orders"   // The user's orders changed
+	EventUserFills    EventType = "user_fills"    // The user's orders were filled
+	EventUserBalances EventType = "user_balances" // The user's balances changed
+	EventSecurity     EventType = "security"      // Login or other account security event
+
+	EventSessionsRevoked EventType = "sessions_revoked" // Sessions of UserID were revoked, ends their connections
+)
+
+// Event is published on the EventBus after the originating transaction commits
+type Event struct {
+	Type     EventType
+	Origin   string // Instance that published the event, empty when local
+	MarketID string
+	Symbol   string
+	Trades   []*models.Trade // EventTrades, ordered by Seq
+	Tickers  []Ticker        // EventTicker
+	Candles  []models.OHLCV  // EventCandles
+	Interval string          // EventCandles: interval of the candles
+	Closed   bool            // EventCandles: final candles of a closed bucket, else in progress
+
+	BookPrices []BookPrice // EventBookChanged: levels touched (empty = unknown, refresh all)
+	OrderIDs   []string    // EventBookChanged: orders touched (empty = unknown, refresh all)
+	BookDiff   *BookDiff   // EventBookDiff
+	L3         *L3Update   // EventL3
+
+	UserID   string          // Private events
+	Orders   []*models.Order // EventUserOrders
+	Fills    []repo.UserFill // EventUserFills
+	Balances []repo.Balance  // EventUserBalances
+	Security *SecurityEvent  // EventSecurity
+
+	SessionIDs []string // EventSessionsRevoked
+}
+
+// BookPrice identifies one price level of an order book side
+type BookPrice struct {
+	Side  models.OrderSide
+	Price float64
+}
+
+// EventBus fans out market events to in-process subscribers
+type EventBus struct {
+	mu   sync.RWMutex
+	subs map[chan Event]struct{}
+}
+
+// NewEventBus creates an empty event bus
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving every published event and a function
+// to unsubscribe. Events are dropped for a subscriber whose buffer is full.
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers the event to all subscribers without blocking.
+// It is safe to call on a nil bus.
+func (b *EventBus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("EventBus: subscriber buffer full, dropping %s event for market %s", ev.Type, ev.MarketID)
+		}
+	}
+}
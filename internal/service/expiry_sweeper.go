@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+const (
+	expirySweepInterval = 10 * time.Minute
+	// expiryRetention keeps expired rows a while for support and audit
+	expiryRetention = 24 * time.Hour
+)
+
+// ExpirySweeper periodically deletes expired challenges, tokens and login
+// throttle entries. It runs on the leader only.
+type ExpirySweeper struct {
+	repo *repo.ExpiryRepo
+}
+
+func NewExpirySweeper(r *repo.ExpiryRepo) *ExpirySweeper {
+	return &ExpirySweeper{repo: r}
+}
+
+// Start sweeps now and then every expirySweepInterval until ctx is done
+func (s *ExpirySweeper) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+func (s *ExpirySweeper) run(ctx context.Context) {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ExpirySweeper) sweep(ctx context.Context) {
+	deleted, err := s.repo.DeleteExpired(ctx, time.Now().Add(-expiryRetention))
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Expiry sweep error: %v", err)
+	}
+	for table, n := range deleted {
+		if n > 0 {
+			log.Printf("Expiry sweep: deleted %d rows from %s", n, table)
+		}
+	}
+}
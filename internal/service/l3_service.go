@@ -0,0 +1,408 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// L3 event types
+const (
+	L3Add     = "add"     // Order rests on the book, at the back of its price level
+	L3Modify  = "modify"  // Price or amount amended, queue position kept
+	L3Delete  = "delete"  // Order left the book without being filled
+	L3Execute = "execute" // Order (partially) filled; gone from the book when amount is 0
+)
+
+// L3Event is one order-by-order book change
+type L3Event struct {
+	Sequence int64            `json:"sequence"`
+	Type     string           `json:"type"`
+	OrderID  string           `json:"orderId"` // Anonymized, stable for the order's lifetime
+	Side     models.OrderSide `json:"side"`
+	Price    float64          `json:"price"`
+	Amount   float64          `json:"amount"`             // Remaining amount after the event
+	Executed float64          `json:"executed,omitempty"` // Filled by this event (execute)
+	Time     time.Time        `json:"time"`               // Order time, its priority within the price level
+}
+
+// L3Update carries the events with sequence numbers FirstSequence..LastSequence
+type L3Update struct {
+	MarketID      string    `json:"marketId"`
+	Symbol        string    `json:"symbol"`
+	FirstSequence int64     `json:"firstSequence"`
+	LastSequence  int64     `json:"lastSequence"`
+	Events        []L3Event `json:"events"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// L3Order is one resting order of an L3 snapshot
+type L3Order struct {
+	OrderID string    `json:"orderId"`
+	Price   float64   `json:"price"`
+	Amount  float64   `json:"amount"`
+	Time    time.Time `json:"time"`
+}
+
+// L3Snapshot is every resting order of a market as of Sequence, in priority order
+type L3Snapshot struct {
+	MarketID  string    `json:"marketId"`
+	Symbol    string    `json:"symbol"`
+	Sequence  int64     `json:"sequence"`
+	Bids      []L3Order `json:"bids"`
+	Asks      []L3Order `json:"asks"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type l3Book struct {
+	symbol string
+	orders map[string]*repo.BookOrder // Resting orders by id
+	seq    int64
+}
+
+// L3Service keeps the resting orders of every market in memory and publishes
+// order-by-order events. Like BookService it re-reads the orders named by book
+// change events and periodically compares the whole book with the database.
+type L3Service struct {
+	order  *repo.OrderRepo
+	market *repo.MarketRepo
+	events *EventBus
+	idKey  []byte // HMAC key anonymizing order ids
+
+	mu    sync.RWMutex
+	books map[string]*l3Book // market id -> book
+}
+
+// NewL3Service creates an L3 service. Order ids are anonymized with an HMAC
+// keyed by idSecret; without a secret a random key is used, so anonymized
+// ids change on restart.
+func NewL3Service(or *repo.OrderRepo, mr *repo.MarketRepo, events *EventBus, idSecret string) *L3Service {
+	key := []byte(idSecret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+		log.Println("L3: no order id secret configured, anonymized order ids change on restart")
+	}
+	return &L3Service{
+		order:  or,
+		market: mr,
+		events: events,
+		idKey:  key,
+		books:  make(map[string]*l3Book),
+	}
+}
+
+// Start loads the resting orders of all active markets and keeps them updated from events
+func (s *L3Service) Start(ctx context.Context) error {
+	ch, unsubscribe := s.events.Subscribe(4096)
+
+	if err := s.resyncAll(ctx); err != nil {
+		unsubscribe()
+		return err
+	}
+
+	go s.run(ctx, ch, unsubscribe)
+	return nil
+}
+
+// anonymize maps an order id to its public id
+func (s *L3Service) anonymize(orderID string) string {
+	mac := hmac.New(sha256.New, s.idKey)
+	mac.Write([]byte(orderID))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// Snapshot returns the resting orders of a market
+func (s *L3Service) Snapshot(marketID string) (*L3Snapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.books[marketID]
+	if !ok {
+		return nil, false
+	}
+
+	snapshot := &L3Snapshot{
+		MarketID:  marketID,
+		Symbol:    b.symbol,
+		Sequence:  b.seq,
+		Bids:      []L3Order{},
+		Asks:      []L3Order{},
+		Timestamp: time.Now(),
+	}
+	orders := make([]*repo.BookOrder, 0, len(b.orders))
+	for _, o := range b.orders {
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return priorityBefore(orders[i], orders[j]) })
+
+	for _, o := range orders {
+		l3 := L3Order{OrderID: s.anonymize(o.ID), Price: o.Price, Amount: o.Remaining(), Time: o.CreatedAt}
+		if o.Side == models.Buy {
+			snapshot.Bids = append(snapshot.Bids, l3)
+		} else {
+			snapshot.Asks = append(snapshot.Asks, l3)
+		}
+	}
+	return snapshot, true
+}
+
+// priorityBefore orders resting orders the way the matching engine does:
+// best price first, then oldest first
+func priorityBefore(a, b *repo.BookOrder) bool {
+	if a.Side != b.Side {
+		return a.Side == models.Buy
+	}
+	if a.Price != b.Price {
+		if a.Side == models.Buy {
+			return a.Price > b.Price
+		}
+		return a.Price < b.Price
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+func (s *L3Service) run(ctx context.Context, ch <-chan Event, unsubscribe func()) {
+	defer unsubscribe()
+
+	resync := time.NewTicker(bookResyncInterval)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev := <-ch:
+			// Coalesce the changes already queued into one refresh per market
+			pending := make(map[string]*l3Refresh)
+			s.collect(pending, ev)
+		drain:
+			for {
+				select {
+				case ev := <-ch:
+					s.collect(pending, ev)
+				default:
+					break drain
+				}
+			}
+			for marketID, r := range pending {
+				if err := s.refresh(ctx, marketID, r); err != nil {
+					log.Printf("L3: error refreshing market %s: %v", marketID, err)
+				}
+			}
+
+		case <-resync.C:
+			if err := s.resyncAll(ctx); err != nil {
+				log.Printf("L3: resync failed: %v", err)
+			}
+		}
+	}
+}
+
+// l3Refresh collects the orders to re-read for one market
+type l3Refresh struct {
+	symbol string
+	full   bool
+	ids    map[string]bool
+}
+
+func (s *L3Service) collect(pending map[string]*l3Refresh, ev Event) {
+	if ev.Type != EventBookChanged {
+		return
+	}
+
+	r, ok := pending[ev.MarketID]
+	if !ok {
+		r = &l3Refresh{symbol: ev.Symbol, ids: make(map[string]bool)}
+		pending[ev.MarketID] = r
+	}
+	if len(ev.OrderIDs) == 0 {
+		r.full = true
+	}
+	for _, id := range ev.OrderIDs {
+		r.ids[id] = true
+	}
+}
+
+func (s *L3Service) refresh(ctx context.Context, marketID string, r *l3Refresh) error {
+	s.mu.RLock()
+	_, known := s.books[marketID]
+	s.mu.RUnlock()
+
+	if r.full || !known {
+		return s.resyncMarket(ctx, marketID, r.symbol)
+	}
+
+	ids := make([]string, 0, len(r.ids))
+	for id := range r.ids {
+		ids = append(ids, id)
+	}
+	current, err := s.order.GetBookOrders(ctx, ids)
+	if err != nil {
+		return err
+	}
+	s.apply(marketID, r.symbol, ids, current)
+	return nil
+}
+
+func (s *L3Service) resyncAll(ctx context.Context) error {
+	markets, err := s.market.GetAllActiveMarkets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range markets {
+		if err := s.resyncMarket(ctx, m.ID, m.Symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resyncMarket compares all resting orders with the database and publishes any difference
+func (s *L3Service) resyncMarket(ctx context.Context, marketID, symbol string) error {
+	resting, err := s.order.GetRestingOrders(ctx, marketID)
+	if err != nil {
+		return err
+	}
+
+	// Orders we hold that no longer rest: read their final state to tell
+	// executions from cancellations
+	inDB := make(map[string]bool, len(resting))
+	for _, o := range resting {
+		inDB[o.ID] = true
+	}
+	var gone []string
+	s.mu.RLock()
+	if b, ok := s.books[marketID]; ok {
+		for id := range b.orders {
+			if !inDB[id] {
+				gone = append(gone, id)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	current := resting
+	if len(gone) > 0 {
+		final, err := s.order.GetBookOrders(ctx, gone)
+		if err != nil {
+			return err
+		}
+		current = append(current, final...)
+	}
+
+	ids := make([]string, 0, len(inDB)+len(gone))
+	for id := range inDB {
+		ids = append(ids, id)
+	}
+	ids = append(ids, gone...)
+	s.apply(marketID, symbol, ids, current)
+	return nil
+}
+
+// apply compares the held state of the given orders with their current state
+// (orders missing from current no longer exist as limit orders) and publishes
+// the resulting events
+func (s *L3Service) apply(marketID, symbol string, ids []string, current []repo.BookOrder) {
+	byID := make(map[string]*repo.BookOrder, len(current))
+	for i := range current {
+		byID[current[i].ID] = &current[i]
+	}
+
+	s.mu.Lock()
+
+	b, ok := s.books[marketID]
+	if !ok {
+		b = &l3Book{symbol: symbol, orders: make(map[string]*repo.BookOrder)}
+		s.books[marketID] = b
+	}
+
+	// Known orders first, in book priority, then new orders in time priority
+	var known, added []string
+	for _, id := range ids {
+		if _, ok := b.orders[id]; ok {
+			known = append(known, id)
+		} else if cur := byID[id]; cur != nil && cur.Resting {
+			added = append(added, id)
+		}
+	}
+	sort.Slice(known, func(i, j int) bool { return priorityBefore(b.orders[known[i]], b.orders[known[j]]) })
+	sort.Slice(added, func(i, j int) bool { return priorityBefore(byID[added[i]], byID[added[j]]) })
+
+	first := b.seq + 1
+	var events []L3Event
+	emit := func(typ string, o *repo.BookOrder, price, amount, executed float64) {
+		b.seq++
+		events = append(events, L3Event{
+			Sequence: b.seq,
+			Type:     typ,
+			OrderID:  s.anonymize(o.ID),
+			Side:     o.Side,
+			Price:    price,
+			Amount:   amount,
+			Executed: executed,
+			Time:     o.CreatedAt,
+		})
+	}
+
+	for _, id := range known {
+		old, cur := b.orders[id], byID[id]
+
+		remaining := old.Remaining()
+		if cur != nil && cur.Filled-old.Filled > 1e-12 {
+			remaining = cur.Remaining()
+			if cur.Status == models.Filled {
+				remaining = 0
+			}
+			emit(L3Execute, old, old.Price, remaining, cur.Filled-old.Filled)
+		}
+
+		switch {
+		case cur == nil || !cur.Resting:
+			if remaining > 1e-12 {
+				emit(L3Delete, old, old.Price, 0, 0)
+			}
+			delete(b.orders, id)
+		case cur.Price != old.Price || cur.Amount != old.Amount:
+			emit(L3Modify, cur, cur.Price, cur.Remaining(), 0)
+			b.orders[id] = cur
+		default:
+			b.orders[id] = cur
+		}
+	}
+	for _, id := range added {
+		cur := byID[id]
+		emit(L3Add, cur, cur.Price, cur.Remaining(), 0)
+		b.orders[id] = cur
+	}
+
+	var update *L3Update
+	if len(events) > 0 {
+		update = &L3Update{
+			MarketID:      marketID,
+			Symbol:        b.symbol,
+			FirstSequence: first,
+			LastSequence:  b.seq,
+			Events:        events,
+			Timestamp:     time.Now(),
+		}
+	}
+	s.mu.Unlock()
+
+	if update != nil {
+		s.events.Publish(Event{Type: EventL3, MarketID: marketID, Symbol: update.Symbol, L3: update})
+	}
+}
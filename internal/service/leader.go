@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// renewLeaderScript extends the lock only while we still hold it
+var renewLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseLeaderScript deletes the lock only while we still hold it
+var releaseLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// NewInstanceID returns the INSTANCE_ID environment variable, or the host name
+// with a random suffix, to tell API instances apart
+func NewInstanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return host + "-" + hex.EncodeToString(buf)
+}
+
+// LeaderElector runs singleton jobs on one API instance at a time, holding a
+// Redis lock (SET NX PX) that is renewed while the jobs run. Without Redis the
+// instance is always the leader.
+type LeaderElector struct {
+	client *redis.Client
+	key    string
+	id     string
+	ttl    time.Duration
+}
+
+// NewLeaderElector creates an elector for the lock key; client may be nil
+func NewLeaderElector(client *redis.Client, key, instanceID string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{client: client, key: key, id: instanceID, ttl: ttl}
+}
+
+// Run calls job with a context that is cancelled when leadership is lost, and
+// competes for leadership again afterwards, until ctx is done
+func (e *LeaderElector) Run(ctx context.Context, job func(ctx context.Context)) {
+	if e.client == nil {
+		log.Printf("Leader: no Redis, running singleton jobs on this instance")
+		job(ctx)
+		return
+	}
+
+	retry := time.NewTicker(e.ttl / 3)
+	defer retry.Stop()
+
+	for {
+		ok, err := e.client.SetNX(ctx, e.key, e.id, e.ttl).Result()
+		if err != nil {
+			log.Printf("Leader: error acquiring %s: %v", e.key, err)
+		}
+		if ok {
+			log.Printf("Leader: %s acquired %s", e.id, e.key)
+			e.lead(ctx, job)
+			log.Printf("Leader: %s lost %s", e.id, e.key)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-retry.C:
+		}
+	}
+}
+
+// lead runs job while renewing the lock, and stops it when renewal fails
+func (e *LeaderElector) lead(ctx context.Context, job func(ctx context.Context)) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		job(jobCtx)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+		releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer releaseCancel()
+		releaseLeaderScript.Run(releaseCtx, e.client, []string{e.key}, e.id)
+	}()
+
+	renew := time.NewTicker(e.ttl / 3)
+	defer renew.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-renew.C:
+			n, err := renewLeaderScript.Run(ctx, e.client, []string{e.key}, e.id, e.ttl.Milliseconds()).Int()
+			if err != nil || n == 0 {
+				if err != nil {
+					log.Printf("Leader: error renewing %s: %v", e.key, err)
+				}
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+var ErrInvalidUnlockToken = errors.New("unlock token is invalid or expired")
+
+// UnlockTokenTTL is how long the unlock link of a locked account stays valid
+const UnlockTokenTTL = 24 * time.Hour
+
+// LoginThrottleStore keeps login failures and blocks per key. CacheService
+// implements it on Redis and repo.LoginThrottleRepo on Postgres.
+type LoginThrottleStore interface {
+	// AddFailure counts a failure of a key and returns the failures within the window
+	AddFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	// Block blocks a key until a time; locked marks a lockout rather than a delay
+	Block(ctx context.Context, key string, until time.Time, locked bool) error
+	// Blocked returns the block of a key, a zero time when there is none
+	Blocked(ctx context.Context, key string) (time.Time, bool, error)
+	// Reset clears the failures and block of a key
+	Reset(ctx context.Context, key string) error
+}
+
+var (
+	_ LoginThrottleStore = (*CacheService)(nil)
+	_ LoginThrottleStore = (*repo.LoginThrottleRepo)(nil)
+)
+
+// fallbackLoginThrottleStore uses a primary store and switches to a fallback
+// for every call the primary fails, so an outage of Redis leaves throttling
+// on Postgres instead of disabling it
+type fallbackLoginThrottleStore struct {
+	primary  LoginThrottleStore
+	fallback LoginThrottleStore
+}
+
+// NewFallbackLoginThrottleStore returns a store using primary and, for each
+// call primary fails, fallback; it fails only when both do
+func NewFallbackLoginThrottleStore(primary, fallback LoginThrottleStore) LoginThrottleStore {
+	return &fallbackLoginThrottleStore{primary: primary, fallback: fallback}
+}
+
+func (s *fallbackLoginThrottleStore) failover(op string, err error) {
+	log.Printf("Login throttle store error on %s, using fallback: %v", op, err)
+}
+
+func (s *fallbackLoginThrottleStore) AddFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	n, err := s.primary.AddFailure(ctx, key, window)
+	if err == nil {
+		return n, nil
+	}
+	s.failover("AddFailure", err)
+	return s.fallback.AddFailure(ctx, key, window)
+}
+
+func (s *fallbackLoginThrottleStore) Block(ctx context.Context, key string, until time.Time, locked bool) error {
+	err := s.primary.Block(ctx, key, until, locked)
+	if err == nil {
+		return nil
+	}
+	s.failover("Block", err)
+	return s.fallback.Block(ctx, key, until, locked)
+}
+
+func (s *fallbackLoginThrottleStore) Blocked(ctx context.Context, key string) (time.Time, bool, error) {
+	until, locked, err := s.primary.Blocked(ctx, key)
+	if err == nil {
+		return until, locked, nil
+	}
+	s.failover("Blocked", err)
+	return s.fallback.Blocked(ctx, key)
+}
+
+// Reset clears both stores, so failures counted in the fallback during an
+// outage do not come back with the next one
+func (s *fallbackLoginThrottleStore) Reset(ctx context.Context, key string) error {
+	err := s.primary.Reset(ctx, key)
+	if err != nil {
+		s.failover("Reset", err)
+	}
+	if fallbackErr := s.fallback.Reset(ctx, key); fallbackErr != nil && err != nil {
+		return fallbackErr
+	}
+	return nil
+}
+
+// LoginThrottleConfig holds the brute-force thresholds. Failures count within
+// Window; from DelayAfter failures on, each failure blocks the key for
+// BaseDelay, doubling up to MaxDelay, and the maximum locks it for Lockout.
+type LoginThrottleConfig struct {
+	MaxAccountFailures int // Failures before an account is locked, 0 never locks
+	MaxIPFailures      int // Failures before an IP address is locked, 0 never locks
+	DelayAfter         int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	Window             time.Duration
+	Lockout            time.Duration
+}
+
+// LoginThrottleConfigFromEnv reads the thresholds from
+// LOGIN_MAX_ACCOUNT_FAILURES, LOGIN_MAX_IP_FAILURES, LOGIN_DELAY_AFTER,
+// LOGIN_BASE_DELAY, LOGIN_MAX_DELAY, LOGIN_FAILURE_WINDOW and LOGIN_LOCKOUT,
+// with defaults for unset variables
+func LoginThrottleConfigFromEnv() (LoginThrottleConfig, error) {
+	cfg := LoginThrottleConfig{
+		MaxAccountFailures: 10,
+		MaxIPFailures:      50,
+		DelayAfter:         3,
+		BaseDelay:          time.Second,
+		MaxDelay:           30 * time.Second,
+		Window:             15 * time.Minute,
+		Lockout:            30 * time.Minute,
+	}
+
+	for name, v := range map[string]*int{
+		"LOGIN_MAX_ACCOUNT_FAILURES": &cfg.MaxAccountFailures,
+		"LOGIN_MAX_IP_FAILURES":      &cfg.MaxIPFailures,
+		"LOGIN_DELAY_AFTER":          &cfg.DelayAfter,
+	} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid %s: %q", name, s)
+		}
+		*v = n
+	}
+
+	for name, v := range map[string]*time.Duration{
+		"LOGIN_BASE_DELAY":     &cfg.BaseDelay,
+		"LOGIN_MAX_DELAY":      &cfg.MaxDelay,
+		"LOGIN_FAILURE_WINDOW": &cfg.Window,
+		"LOGIN_LOCKOUT":        &cfg.Lockout,
+	} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid %s: %q", name, s)
+		}
+		*v = d
+	}
+	if cfg.Window == 0 || cfg.Lockout == 0 {
+		return cfg, fmt.Errorf("invalid LOGIN_FAILURE_WINDOW or LOGIN_LOCKOUT: must be positive")
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		return cfg, fmt.Errorf("invalid LOGIN_MAX_DELAY: shorter than LOGIN_BASE_DELAY")
+	}
+	return cfg, nil
+}
+
+// LoginBlock is a login refused until Until
+type LoginBlock struct {
+	Until  time.Time
+	Locked bool // Locked out rather than delayed
+	// NewLock is set by Failure when that failure locked the account
+	NewLock bool
+}
+
+// RetryAfter is the wait before the next attempt, at least a second
+func (b *LoginBlock) RetryAfter() time.Duration {
+	d := time.Until(b.Until).Round(time.Second)
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// UnlockNotifier delivers the unlock token of a locked account to its owner
+type UnlockNotifier func(ctx context.Context, userID, token string) error
+
+// LogUnlockNotifier logs unlock tokens, for development without a mailer
+func LogUnlockNotifier(_ context.Context, userID, token string) error {
+	log.Printf("Account %s locked, unlock token: %s", userID, token)
+	return nil
+}
+
+// LoginThrottle slows down and locks out password guessing per account and
+// per IP address. Locked accounts get a single-use unlock token.
+type LoginThrottle struct {
+	store  LoginThrottleStore
+	tokens *repo.LoginThrottleRepo
+	cfg    LoginThrottleConfig
+	notify UnlockNotifier
+}
+
+func NewLoginThrottle(store LoginThrottleStore, tokens *repo.LoginThrottleRepo, cfg LoginThrottleConfig, notify UnlockNotifier) *LoginThrottle {
+	if notify == nil {
+		notify = LogUnlockNotifier
+	}
+	return &LoginThrottle{store: store, tokens: tokens, cfg: cfg, notify: notify}
+}
+
+func accountThrottleKey(userID string) string { return "account:" + userID }
+func ipThrottleKey(ip string) string          { return "ip:" + ip }
+
+// Check returns the block of a login from an IP address, for an account when
+// userID is known, or nil when the login may go ahead
+func (t *LoginThrottle) Check(ctx context.Context, userID, ip string) (*LoginBlock, error) {
+	keys := []string{ipThrottleKey(ip)}
+	if userID != "" {
+		keys = append(keys, accountThrottleKey(userID))
+	}
+	var block *LoginBlock
+	for _, key := range keys {
+		until, locked, err := t.store.Blocked(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if until.IsZero() {
+			continue
+		}
+		if block == nil || until.After(block.Until) {
+			block = &LoginBlock{Until: until, Locked: locked}
+		}
+	}
+	return block, nil
+}
+
+// Failure records a failed login from an IP address, for an account when
+// userID is known, and returns the block it caused, if any. Locking an
+// account sends its owner an unlock token.
+func (t *LoginThrottle) Failure(ctx context.Context, userID, ip string) (*LoginBlock, error) {
+	block, err := t.fail(ctx, ipThrottleKey(ip), t.cfg.MaxIPFailures)
+	if err != nil || userID == "" {
+		return block, err
+	}
+	accountBlock, err := t.fail(ctx, accountThrottleKey(userID), t.cfg.MaxAccountFailures)
+	if err != nil {
+		return block, err
+	}
+	if accountBlock != nil && accountBlock.Locked {
+		accountBlock.NewLock = true
+		if err := t.sendUnlockToken(ctx, userID); err != nil {
+			log.Printf("Error sending unlock token: %v", err)
+		}
+	}
+	if block == nil || (accountBlock != nil && accountBlock.Until.After(block.Until)) {
+		block = accountBlock
+	}
+	return block, nil
+}
+
+// Success clears the failures of an account after a completed login
+func (t *LoginThrottle) Success(ctx context.Context, userID string) error {
+	return t.store.Reset(ctx, accountThrottleKey(userID))
+}
+
+// Unlock lifts the lockout of the account of an unlock token and returns it
+func (t *LoginThrottle) Unlock(ctx context.Context, token string) (string, error) {
+	userID, err := t.tokens.UseUnlockToken(ctx, hashToken(token))
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidUnlockToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, t.store.Reset(ctx, accountThrottleKey(userID))
+}
+
+// fail counts a failure of a key and blocks it once past the thresholds
+func (t *LoginThrottle) fail(ctx context.Context, key string, maxFailures int) (*LoginBlock, error) {
+	n, err := t.store.AddFailure(ctx, key, t.cfg.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	d, locked := t.cfg.blockAfter(n, maxFailures)
+	if d == 0 {
+		return nil, nil
+	}
+	block := &LoginBlock{Until: time.Now().Add(d), Locked: locked}
+	if err := t.store.Block(ctx, key, block.Until, block.Locked); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// blockAfter returns how long a key is blocked after its nth failure, 0 when
+// it is not, and whether that is a lockout
+func (cfg LoginThrottleConfig) blockAfter(n, maxFailures int) (time.Duration, bool) {
+	switch {
+	case maxFailures > 0 && n >= maxFailures:
+		return cfg.Lockout, true
+	case cfg.DelayAfter > 0 && n >= cfg.DelayAfter && cfg.BaseDelay > 0:
+		delay := cfg.BaseDelay
+		for i := cfg.DelayAfter; i < n && delay < cfg.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > cfg.MaxDelay {
+			delay = cfg.MaxDelay
+		}
+		return delay, false
+	}
+	return 0, false
+}
+
+func (t *LoginThrottle) sendUnlockToken(ctx context.Context, userID string) error {
+	token, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	if err := t.tokens.InsertUnlockToken(ctx, userID, hashToken(token), time.Now().Add(UnlockTokenTTL)); err != nil {
+		return err
+	}
+	return t.notify(ctx, userID, token)
+}
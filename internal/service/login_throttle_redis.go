@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Login throttling on Redis: a counter per key expiring with the failure
+// window, and a block key expiring when the block ends
+
+func loginFailuresKey(key string) string { return "login:failures:" + key }
+func loginBlockKey(key string) string    { return "login:block:" + key }
+
+// AddFailure counts a failure of a key within the window
+func (s *CacheService) AddFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	k := loginFailuresKey(key)
+	pipe := s.client.TxPipeline()
+	incr := pipe.Incr(ctx, k)
+	pipe.ExpireNX(ctx, k, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+// Block blocks a key until a time; locked marks a lockout rather than a delay
+func (s *CacheService) Block(ctx context.Context, key string, until time.Time, locked bool) error {
+	value := "delay"
+	if locked {
+		value = "locked"
+	}
+	return s.client.Set(ctx, loginBlockKey(key), value, time.Until(until)).Err()
+}
+
+// Blocked returns the block of a key, a zero time when there is none
+func (s *CacheService) Blocked(ctx context.Context, key string) (time.Time, bool, error) {
+	k := loginBlockKey(key)
+	pipe := s.client.Pipeline()
+	get := pipe.Get(ctx, k)
+	ttl := pipe.PTTL(ctx, k)
+	if _, err := pipe.Exec(ctx); err == redis.Nil {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	if ttl.Val() <= 0 {
+		return time.Time{}, false, nil
+	}
+	return time.Now().Add(ttl.Val()), get.Val() == "locked", nil
+}
+
+// Reset clears the failures and block of a key
+func (s *CacheService) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, loginFailuresKey(key), loginBlockKey(key)).Err()
+}
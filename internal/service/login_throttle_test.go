@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleBlockAfter(t *testing.T) {
+	cfg := LoginThrottleConfig{
+		DelayAfter: 3,
+		BaseDelay:  time.Second,
+		MaxDelay:   30 * time.Second,
+		Window:     15 * time.Minute,
+		Lockout:    30 * time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		cfg         LoginThrottleConfig
+		n           int
+		maxFailures int
+		want        time.Duration
+		wantLocked  bool
+	}{
+		{"first failure", cfg, 1, 10, 0, false},
+		{"before delays", cfg, 2, 10, 0, false},
+		{"first delay", cfg, 3, 10, time.Second, false},
+		{"doubles", cfg, 4, 10, 2 * time.Second, false},
+		{"doubles again", cfg, 6, 10, 8 * time.Second, false},
+		{"capped", cfg, 9, 10, 30 * time.Second, false},
+		{"locked at max", cfg, 10, 10, 30 * time.Minute, true},
+		{"stays locked past max", cfg, 11, 10, 30 * time.Minute, true},
+		{"never locks without max", cfg, 1000, 0, 30 * time.Second, false},
+		{"lock before delays", cfg, 2, 2, 30 * time.Minute, true},
+		{"no delays", LoginThrottleConfig{Lockout: time.Hour}, 5, 10, 0, false},
+		{"no base delay", LoginThrottleConfig{DelayAfter: 1, MaxDelay: time.Second, Lockout: time.Hour}, 5, 10, 0, false},
+		{"delay from the first failure", LoginThrottleConfig{DelayAfter: 1, BaseDelay: time.Second, MaxDelay: time.Minute}, 1, 0, time.Second, false},
+		{"huge count does not overflow", cfg, 1 << 30, 0, 30 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, locked := tt.cfg.blockAfter(tt.n, tt.maxFailures)
+			if got != tt.want || locked != tt.wantLocked {
+				t.Errorf("blockAfter(%d, %d) = (%s, %v), want (%s, %v)", tt.n, tt.maxFailures, got, locked, tt.want, tt.wantLocked)
+			}
+		})
+	}
+}
+
+// memoryThrottleStore is a LoginThrottleStore in memory; err makes every call fail
+type memoryThrottleStore struct {
+	failures map[string]int
+	blocks   map[string]memoryBlock
+	err      error
+	calls    int
+}
+
+type memoryBlock struct {
+	until  time.Time
+	locked bool
+}
+
+func newMemoryThrottleStore() *memoryThrottleStore {
+	return &memoryThrottleStore{failures: make(map[string]int), blocks: make(map[string]memoryBlock)}
+}
+
+func (s *memoryThrottleStore) AddFailure(_ context.Context, key string, _ time.Duration) (int, error) {
+	s.calls++
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.failures[key]++
+	return s.failures[key], nil
+}
+
+func (s *memoryThrottleStore) Block(_ context.Context, key string, until time.Time, locked bool) error {
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	s.blocks[key] = memoryBlock{until: until, locked: locked}
+	return nil
+}
+
+func (s *memoryThrottleStore) Blocked(_ context.Context, key string) (time.Time, bool, error) {
+	s.calls++
+	if s.err != nil {
+		return time.Time{}, false, s.err
+	}
+	b := s.blocks[key]
+	if time.Now().After(b.until) {
+		return time.Time{}, false, nil
+	}
+	return b.until, b.locked, nil
+}
+
+func (s *memoryThrottleStore) Reset(_ context.Context, key string) error {
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.failures, key)
+	delete(s.blocks, key)
+	return nil
+}
+
+// Lockouts of accounts issue unlock tokens in Postgres, so these tests stay
+// below MaxAccountFailures; blockAfter covers the lockout itself
+func TestLoginThrottleFailureAndCheck(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryThrottleStore()
+	throttle := NewLoginThrottle(store, nil, LoginThrottleConfig{
+		MaxAccountFailures: 100,
+		MaxIPFailures:      4,
+		DelayAfter:         2,
+		BaseDelay:          time.Minute,
+		MaxDelay:           time.Hour,
+		Window:             time.Hour,
+		Lockout:            3 * time.Hour,
+	}, nil)
+
+	// Failures of an unknown user count against the IP only
+	if block, err := throttle.Failure(ctx, "", "203.0.113.7"); err != nil || block != nil {
+		t.Fatalf("first failure = (%+v, %v), want no block", block, err)
+	}
+	if block, _ := throttle.Check(ctx, "", "203.0.113.7"); block != nil {
+		t.Fatalf("Check after one failure = %+v, want nil", block)
+	}
+
+	// The second failure delays the IP and, counted once, not yet the account
+	block, err := throttle.Failure(ctx, "user-1", "203.0.113.7")
+	if err != nil || block == nil || block.Locked || block.NewLock {
+		t.Fatalf("second failure = (%+v, %v), want a delay", block, err)
+	}
+	if d := block.RetryAfter(); d < 59*time.Second || d > time.Minute {
+		t.Errorf("RetryAfter = %s, want about a minute", d)
+	}
+	if store.failures["account:user-1"] != 1 || store.failures["ip:203.0.113.7"] != 2 {
+		t.Errorf("failures = %v", store.failures)
+	}
+	if block, _ := throttle.Check(ctx, "user-1", "198.51.100.1"); block != nil {
+		t.Errorf("Check from another IP = %+v, want nil", block)
+	}
+	if block, _ := throttle.Check(ctx, "", "203.0.113.7"); block == nil {
+		t.Error("Check of the delayed IP = nil, want a block")
+	}
+
+	// The account delay is reported when it ends after the IP's
+	if block, _ := throttle.Failure(ctx, "user-1", "198.51.100.1"); block == nil || block.Locked {
+		t.Fatalf("account delay = %+v, want a delay", block)
+	}
+	check, _ := throttle.Check(ctx, "user-1", "198.51.100.1")
+	if check == nil || check.Locked {
+		t.Fatalf("Check of the delayed account = %+v, want a delay", check)
+	}
+
+	// MaxIPFailures locks the IP
+	throttle.Failure(ctx, "", "203.0.113.7")
+	block, _ = throttle.Failure(ctx, "", "203.0.113.7")
+	if block == nil || !block.Locked || block.NewLock {
+		t.Fatalf("failure at MaxIPFailures = %+v, want a lock of the IP", block)
+	}
+	check, _ = throttle.Check(ctx, "user-1", "203.0.113.7")
+	if check == nil || !check.Locked || !check.Until.Equal(block.Until) {
+		t.Errorf("Check = %+v, want the IP lock until %s", check, block.Until)
+	}
+
+	// Success clears the account, not the IP
+	if err := throttle.Success(ctx, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := throttle.Check(ctx, "user-1", "198.51.100.1"); block != nil {
+		t.Errorf("Check after success = %+v, want nil", block)
+	}
+	if block, _ := throttle.Check(ctx, "", "203.0.113.7"); block == nil {
+		t.Error("Success cleared the IP lock")
+	}
+}
+
+func TestLoginThrottleStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryThrottleStore()
+	store.err = errors.New("store down")
+	throttle := NewLoginThrottle(store, nil, LoginThrottleConfig{DelayAfter: 1, BaseDelay: time.Second, MaxDelay: time.Second, Window: time.Hour, Lockout: time.Hour}, nil)
+
+	if _, err := throttle.Check(ctx, "user-1", "203.0.113.7"); err == nil {
+		t.Error("Check succeeded with a failing store")
+	}
+	if _, err := throttle.Failure(ctx, "user-1", "203.0.113.7"); err == nil {
+		t.Error("Failure succeeded with a failing store")
+	}
+}
+
+func TestFallbackLoginThrottleStore(t *testing.T) {
+	ctx := context.Background()
+	down := errors.New("redis down")
+	until := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name         string
+		primaryErr   error
+		fallbackErr  error
+		wantErr      bool
+		wantPrimary  bool // The failure and block are in the primary store
+		wantFallback bool
+	}{
+		{"primary up", nil, nil, false, true, false},
+		{"primary down", down, nil, false, false, true},
+		{"fallback down only", nil, down, false, true, false},
+		{"both down", down, down, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, fallback := newMemoryThrottleStore(), newMemoryThrottleStore()
+			primary.err, fallback.err = tt.primaryErr, tt.fallbackErr
+			store := NewFallbackLoginThrottleStore(primary, fallback)
+
+			n, err := store.AddFailure(ctx, "ip:1", time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddFailure error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && n != 1 {
+				t.Errorf("AddFailure = %d, want 1", n)
+			}
+			if err := store.Block(ctx, "ip:1", until, true); (err != nil) != tt.wantErr {
+				t.Fatalf("Block error = %v, want error %v", err, tt.wantErr)
+			}
+			got, locked, err := store.Blocked(ctx, "ip:1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Blocked error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!got.Equal(until) || !locked) {
+				t.Errorf("Blocked = (%s, %v), want (%s, true)", got, locked, until)
+			}
+
+			if _, ok := primary.blocks["ip:1"]; ok != tt.wantPrimary {
+				t.Errorf("block in primary = %v, want %v", ok, tt.wantPrimary)
+			}
+			if _, ok := fallback.blocks["ip:1"]; ok != tt.wantFallback {
+				t.Errorf("block in fallback = %v, want %v", ok, tt.wantFallback)
+			}
+			if tt.primaryErr == nil && fallback.calls > 0 {
+				t.Errorf("fallback called %d times while the primary is up", fallback.calls)
+			}
+
+			if err := store.Reset(ctx, "ip:1"); (err != nil) != tt.wantErr {
+				t.Errorf("Reset error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFallbackLoginThrottleStoreResetClearsBoth(t *testing.T) {
+	ctx := context.Background()
+	primary, fallback := newMemoryThrottleStore(), newMemoryThrottleStore()
+	store := NewFallbackLoginThrottleStore(primary, fallback)
+
+	// Failures counted in the fallback during an outage
+	primary.err = errors.New("redis down")
+	store.AddFailure(ctx, "account:1", time.Hour)
+	primary.err = nil
+	store.AddFailure(ctx, "account:1", time.Hour)
+
+	if err := store.Reset(ctx, "account:1"); err != nil {
+		t.Fatal(err)
+	}
+	if primary.failures["account:1"] != 0 || fallback.failures["account:1"] != 0 {
+		t.Errorf("failures after Reset: primary %d, fallback %d, want 0", primary.failures["account:1"], fallback.failures["account:1"])
+	}
+}
@@ -10,6 +10,7 @@ type PlaceOrderReq struct {
 	Amount         float64            `json:"amount,omitempty"` // optional for market buy
 	QuoteAmountMax *float64           `json:"quote_amount_max,omitempty"`
 	TIF            models.TimeInForce `json:"tif" binding:"required,oneof=GTC IOC FOK POST_ONLY"`
+	ClientOrderID  string             `json:"clientOrderId,omitempty" binding:"omitempty,max=64"`
 }
 
 type AmendReq struct {
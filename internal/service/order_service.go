@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
 	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"math"
 )
@@ -17,19 +18,62 @@ type OrderService struct {
 	trade   *repo.TradeRepo
 	wallet  *repo.WalletRepo
 	cache   *CacheService // Redis cache for invalidation
+	events  *EventBus     // Post-commit market events (optional)
 	feeRate float64
 }
 
-func NewOrderService(db *sql.DB, mr *repo.MarketRepo, or *repo.OrderRepo, tr *repo.TradeRepo, wr *repo.WalletRepo, cs *CacheService) *OrderService {
+func NewOrderService(db *sql.DB, mr *repo.MarketRepo, or *repo.OrderRepo, tr *repo.TradeRepo, wr *repo.WalletRepo, cs *CacheService, events *EventBus) *OrderService {
 	return &OrderService{
-		db: db, market: mr, order: or, trade: tr, wallet: wr, cache: cs,
+		db: db, market: mr, order: or, trade: tr, wallet: wr, cache: cs, events: events,
 		feeRate: 0.001,
 	}
 }
 
+var (
+	ErrOrderNotFound        = errors.New("order not found")
+	ErrDuplicateClientOrder = errors.New("duplicate clientOrderId")
+)
+
 // ---------------- LIST ORDERS ----------------
-func (s *OrderService) ListOrders(ctx context.Context, userID string, status string) ([]*models.Order, error) {
-	return s.order.GetByUserID(ctx, userID, status)
+func (s *OrderService) ListOrders(ctx context.Context, userID string, filter repo.OrderFilter) ([]*models.Order, *repo.Cursor, error) {
+	return s.order.GetByUserID(ctx, userID, filter)
+}
+
+// ---------------- GET ORDER ----------------
+// GetOrder returns one of the user's orders together with its fills
+func (s *OrderService) GetOrder(ctx context.Context, userID, orderID string) (*models.Order, []repo.TradeWithSymbol, error) {
+	o, err := s.order.GetByID(ctx, orderID)
+	if err == sql.ErrNoRows { return nil, nil, ErrOrderNotFound }
+	if err != nil { return nil, nil, err }
+	// Don't reveal other users' orders
+	if o.UserID != userID { return nil, nil, ErrOrderNotFound }
+
+	fills, err := s.trade.GetByOrderID(ctx, o.ID)
+	if err != nil { return nil, nil, err }
+	return o, fills, nil
+}
+
+// GetOrderByClientID returns one of the user's orders, with its fills, by its clientOrderId
+func (s *OrderService) GetOrderByClientID(ctx context.Context, userID, clientOrderID string) (*models.Order, []repo.TradeWithSymbol, error) {
+	orders, _, err := s.order.GetByUserID(ctx, userID, repo.OrderFilter{ClientOrderID: clientOrderID, Limit: 1})
+	if err != nil { return nil, nil, err }
+	if len(orders) == 0 { return nil, nil, ErrOrderNotFound }
+
+	fills, err := s.trade.GetByOrderID(ctx, orders[0].ID)
+	if err != nil { return nil, nil, err }
+	return orders[0], fills, nil
+}
+
+// GetOrderTrades returns the fills of one of the user's orders
+func (s *OrderService) GetOrderTrades(ctx context.Context, userID, orderID string) ([]repo.TradeWithSymbol, error) {
+	_, fills, err := s.GetOrder(ctx, userID, orderID)
+	return fills, err
+}
+
+// OrderFills returns the fills of an order in the API trade schema.
+// Callers are expected to have checked ownership already.
+func (s *OrderService) OrderFills(ctx context.Context, orderID string) ([]repo.TradeWithSymbol, error) {
+	return s.trade.GetByOrderID(ctx, orderID)
 }
 
 // ---------------- PLACE ORDER ----------------
@@ -90,7 +134,14 @@ func (s *OrderService) PlaceOrder(ctx context.Context, userID string, req PlaceO
 		QuoteAmountMax: req.QuoteAmountMax,
 		Status: models.Open, Fee: 0, TIF: req.TIF,
 	}
+	if req.ClientOrderID != "" {
+		taker.ClientOrderID = &req.ClientOrderID
+	}
 	if err := s.order.Insert(ctx, tx, taker); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "orders_user_client_order_id_key" {
+			return nil, nil, ErrDuplicateClientOrder
+		}
 		return nil, nil, err
 	}
 
@@ -108,7 +159,15 @@ func (s *OrderService) PlaceOrder(ctx context.Context, userID string, req PlaceO
 	// Invalidate orderbook cache after successful order placement
 	if s.cache != nil {
 		go s.cache.InvalidateOrderBook(context.Background(), req.MarketID)
+		if len(trades) > 0 {
+			go s.cache.InvalidateRecentTrades(context.Background(), req.MarketID)
+		}
+	}
+
+	if len(trades) > 0 {
+		s.events.Publish(Event{Type: EventTrades, MarketID: market.ID, Symbol: market.Symbol, Trades: trades})
 	}
+	s.events.Publish(Event{Type: EventBookChanged, MarketID: market.ID, Symbol: market.Symbol, BookPrices: touchedPrices(taker, trades), OrderIDs: touchedOrders(taker, trades)})
 	
 	return taker, trades, nil
 }
@@ -437,6 +496,11 @@ func (s *OrderService) CancelOrder(ctx context.Context, userID, orderID string)
 	if s.cache != nil {
 		go s.cache.InvalidateOrderBook(context.Background(), o.MarketID)
 	}
+	var touched []BookPrice
+	if o.Price != nil {
+		touched = []BookPrice{{Side: o.Side, Price: *o.Price}}
+	}
+	s.events.Publish(Event{Type: EventBookChanged, MarketID: market.ID, Symbol: market.Symbol, BookPrices: touched, OrderIDs: []string{o.ID}})
 	
 	return nil
 }
@@ -464,6 +528,7 @@ func (s *OrderService) AmendOrder(ctx context.Context, userID, orderID string, r
 	market, err := s.market.GetByID(ctx, tx, o.MarketID)
 	if err != nil { return nil, err }
 
+	oldPrice := o.Price
 	newPrice := o.Price
 	if req.NewPrice != nil { newPrice = req.NewPrice }
 
@@ -508,5 +573,45 @@ func (s *OrderService) AmendOrder(ctx context.Context, userID, orderID string, r
 	o.Amount = &req.NewAmount
 
 	if err := tx.Commit(); err != nil { return nil, err }
+
+	if s.cache != nil {
+		go s.cache.InvalidateOrderBook(context.Background(), o.MarketID)
+	}
+	touched := []BookPrice{{Side: o.Side, Price: *oldPrice}, {Side: o.Side, Price: *newPrice}}
+	s.events.Publish(Event{Type: EventBookChanged, MarketID: market.ID, Symbol: market.Symbol, BookPrices: touched, OrderIDs: []string{o.ID}})
 	return o, nil
 }
+
+// touchedOrders lists the orders changed by a placement: the taker and its makers
+func touchedOrders(taker *models.Order, trades []*models.Trade) []string {
+	ids := []string{taker.ID}
+	seen := map[string]bool{taker.ID: true}
+	for _, t := range trades {
+		if !seen[t.MakerOrderID] {
+			seen[t.MakerOrderID] = true
+			ids = append(ids, t.MakerOrderID)
+		}
+	}
+	return ids
+}
+
+// touchedPrices lists the book levels changed by a placement: the taker's own
+// price and every maker price it traded against
+func touchedPrices(taker *models.Order, trades []*models.Trade) []BookPrice {
+	var touched []BookPrice
+	if taker.Type == models.OrderTypeLimit && taker.Price != nil {
+		touched = append(touched, BookPrice{Side: taker.Side, Price: *taker.Price})
+	}
+	makerSide := models.Sell
+	if taker.Side == models.Sell {
+		makerSide = models.Buy
+	}
+	seen := make(map[float64]bool)
+	for _, t := range trades {
+		if !seen[t.Price] {
+			seen[t.Price] = true
+			touched = append(touched, BookPrice{Side: makerSide, Price: t.Price})
+		}
+	}
+	return touched
+}
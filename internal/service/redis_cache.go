@@ -64,6 +64,63 @@ func (s *CacheService) InvalidateOrderBook(ctx context.Context, marketID string)
 	return s.client.Del(ctx, key).Err()
 }
 
+// ============================================================================
+// PUBLIC TRADES CACHING
+// ============================================================================
+
+// GetRecentTrades retrieves the cached recent trades window for a market
+func (s *CacheService) GetRecentTrades(ctx context.Context, marketID string) ([]repo.PublicTrade, error) {
+	return s.getTrades(ctx, fmt.Sprintf("trades:recent:%s", marketID))
+}
+
+// SetRecentTrades caches the recent trades window for a market with TTL
+func (s *CacheService) SetRecentTrades(ctx context.Context, marketID string, trades []repo.PublicTrade, ttl time.Duration) error {
+	return s.setTrades(ctx, fmt.Sprintf("trades:recent:%s", marketID), trades, ttl)
+}
+
+// InvalidateRecentTrades removes the recent trades window from cache
+func (s *CacheService) InvalidateRecentTrades(ctx context.Context, marketID string) error {
+	key := fmt.Sprintf("trades:recent:%s", marketID)
+	return s.client.Del(ctx, key).Err()
+}
+
+// GetTradePage retrieves a cached historical trades page
+func (s *CacheService) GetTradePage(ctx context.Context, key string) ([]repo.PublicTrade, error) {
+	return s.getTrades(ctx, fmt.Sprintf("trades:history:%s", key))
+}
+
+// SetTradePage caches a historical trades page with TTL
+func (s *CacheService) SetTradePage(ctx context.Context, key string, trades []repo.PublicTrade, ttl time.Duration) error {
+	return s.setTrades(ctx, fmt.Sprintf("trades:history:%s", key), trades, ttl)
+}
+
+func (s *CacheService) getTrades(ctx context.Context, key string) ([]repo.PublicTrade, error) {
+	data, err := s.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		// Cache miss
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis get error: %v", err)
+	}
+
+	var trades []repo.PublicTrade
+	if err := json.Unmarshal(data, &trades); err != nil {
+		return nil, fmt.Errorf("json unmarshal error: %v", err)
+	}
+
+	return trades, nil
+}
+
+func (s *CacheService) setTrades(ctx context.Context, key string, trades []repo.PublicTrade, ttl time.Duration) error {
+	data, err := json.Marshal(trades)
+	if err != nil {
+		return fmt.Errorf("json marshal error: %v", err)
+	}
+
+	return s.client.Set(ctx, key, data, ttl).Err()
+}
+
 // ============================================================================
 // MARKET CANDLES CACHING
 // ============================================================================
@@ -105,6 +162,7 @@ func (s *CacheService) SetCandle(ctx context.Context, symbol string, candle *mod
 		"low":        candle.Low,
 		"close":      candle.Close,
 		"volume":     candle.Volume,
+		"last_seq":   candle.LastTradeSeq,
 	}
 
 	pipe := s.client.Pipeline()
@@ -181,6 +239,8 @@ func (s *CacheService) UpdateCandleWithTrade(ctx context.Context, trade repo.Tra
 			Low:       trade.Price,
 			Close:     trade.Price,
 			Volume:    trade.QuoteAmount,
+
+			LastTradeSeq: trade.Seq,
 		}
 	} else {
 		// Update existing candle
@@ -190,7 +250,12 @@ func (s *CacheService) UpdateCandleWithTrade(ctx context.Context, trade repo.Tra
 		if trade.Price < candle.Low {
 			candle.Low = trade.Price
 		}
-		candle.Close = trade.Price
+		// Trades can be applied out of sequence when a gap is filled late,
+		// only a later trade moves the close
+		if trade.Seq > candle.LastTradeSeq {
+			candle.Close = trade.Price
+			candle.LastTradeSeq = trade.Seq
+		}
 		candle.Volume += trade.QuoteAmount
 	}
 
@@ -257,6 +322,7 @@ func (s *CacheService) parseCandle(data map[string]string, symbol string) (*mode
 	fmt.Sscanf(data["low"], "%f", &candle.Low)
 	fmt.Sscanf(data["close"], "%f", &candle.Close)
 	fmt.Sscanf(data["volume"], "%f", &candle.Volume)
+	fmt.Sscanf(data["last_seq"], "%d", &candle.LastTradeSeq)
 
 	return candle, nil
 }
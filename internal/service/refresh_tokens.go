@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, the session has been revoked")
+	ErrRefreshTokenRaced   = errors.New("refresh token was just rotated by another request")
+)
+
+// RefreshTokenTTL is the lifetime of a refresh token; each rotation starts a new one
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
+// rotationGrace is how long after a rotation the old token is still taken for
+// a concurrent refresh (e.g. two browser tabs) rather than reuse
+const rotationGrace = 10 * time.Second
+
+// Revocation reasons recorded in refresh_tokens
+const (
+	RevokedLogout    = "logout"
+	RevokedLogoutAll = "logout_all"
+	RevokedReuse     = "reuse"
+	RevokedSession   = "session_revoked"
+)
+
+// revokedSessionTTL is how long a revoked session stays in the cache; it
+// outlives every access token issued to the session
+const revokedSessionTTL = 20 * time.Minute
+
+// RevokedSessionCache remembers revoked sessions so that requests carrying
+// their access tokens are rejected without a database query. CacheService
+// implements it on Redis.
+type RevokedSessionCache interface {
+	MarkSessionsRevoked(ctx context.Context, sessionIDs []string, ttl time.Duration) error
+	SessionRevoked(ctx context.Context, sessionID string) (bool, error)
+}
+
+var _ RevokedSessionCache = (*CacheService)(nil)
+
+// refreshTokenStore is the storage of refresh tokens; RefreshTokenRepo
+// implements it on Postgres
+type refreshTokenStore interface {
+	Insert(ctx context.Context, userID, familyID, tokenHash, ip, userAgent string, expiresAt time.Time) (string, error)
+	Get(ctx context.Context, tokenHash string) (*repo.RefreshToken, error)
+	Rotate(ctx context.Context, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID, reason string) ([]string, error)
+	RevokeUser(ctx context.Context, userID, exceptFamily, reason string) ([]string, error)
+	RevokeUserFamily(ctx context.Context, userID, familyID, reason string) ([]string, error)
+	FamilyRevoked(ctx context.Context, familyID string) (bool, error)
+	ListSessions(ctx context.Context, userID string) ([]repo.SessionRow, error)
+}
+
+var _ refreshTokenStore = (*repo.RefreshTokenRepo)(nil)
+
+// RefreshTokenService issues and rotates refresh tokens. The tokens of a
+// login form a family: each refresh replaces the token with a new one, and a
+// token presented after it was replaced means it leaked, so the family is
+// revoked.
+type RefreshTokenService struct {
+	repo    refreshTokenStore
+	revoked RevokedSessionCache // nil checks the database on every request
+	events  *EventBus
+}
+
+func NewRefreshTokenService(r *repo.RefreshTokenRepo, revoked RevokedSessionCache, events *EventBus) *RefreshTokenService {
+	return &RefreshTokenService{repo: r, revoked: revoked, events: events}
+}
+
+// Issue starts a new family (session) and returns its first token
+func (s *RefreshTokenService) Issue(ctx context.Context, userID, ip, userAgent string) (token, familyID string, err error) {
+	token, err = randomHex(64)
+	if err != nil {
+		return "", "", err
+	}
+	familyID, err = s.repo.Insert(ctx, userID, "", hashToken(token), ip, userAgent, time.Now().Add(RefreshTokenTTL))
+	if err != nil {
+		return "", "", err
+	}
+	return token, familyID, nil
+}
+
+// Rotate exchanges a live token for the next one of its family. A token that
+// was already rotated revokes the family and returns ErrRefreshTokenReused,
+// with the user set so the caller can notify them, unless it was rotated
+// within rotationGrace: that is a concurrent refresh and returns
+// ErrRefreshTokenRaced without revoking anything.
+func (s *RefreshTokenService) Rotate(ctx context.Context, token, ip, userAgent string) (userID, familyID, next string, err error) {
+	oldHash := hashToken(token)
+	t, err := s.repo.Get(ctx, oldHash)
+	if err == sql.ErrNoRows {
+		return "", "", "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	if t.RevokedAt != nil {
+		return "", "", "", ErrRefreshTokenRevoked
+	}
+	if t.RotatedAt != nil {
+		if time.Since(*t.RotatedAt) < rotationGrace {
+			return "", "", "", ErrRefreshTokenRaced
+		}
+		return t.UserID, t.FamilyID, "", s.revokeReused(ctx, t)
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return "", "", "", ErrRefreshTokenExpired
+	}
+
+	next, err = randomHex(64)
+	if err != nil {
+		return "", "", "", err
+	}
+	ok, err := s.repo.Rotate(ctx, oldHash, hashToken(next), ip, userAgent, time.Now().Add(RefreshTokenTTL))
+	if err != nil {
+		return "", "", "", err
+	}
+	if !ok {
+		// Rotated by a concurrent request with the same token
+		return "", "", "", ErrRefreshTokenRaced
+	}
+	return t.UserID, t.FamilyID, next, nil
+}
+
+func (s *RefreshTokenService) revokeReused(ctx context.Context, t *repo.RefreshToken) error {
+	families, err := s.repo.RevokeFamily(ctx, t.FamilyID, RevokedReuse)
+	if err != nil {
+		return err
+	}
+	s.sessionsRevoked(ctx, t.UserID, families)
+	return ErrRefreshTokenReused
+}
+
+// SessionActive reports whether the session of an access token was not
+// revoked, from the cache when there is one and else from the database
+func (s *RefreshTokenService) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	if s.revoked != nil {
+		revoked, err := s.revoked.SessionRevoked(ctx, sessionID)
+		if err == nil {
+			return !revoked, nil
+		}
+		log.Printf("Error reading revoked sessions from cache: %v", err)
+	}
+	revoked, err := s.repo.FamilyRevoked(ctx, sessionID)
+	return !revoked, err
+}
+
+// sessionsRevoked caches revoked sessions and announces them, so that their
+// access tokens and WebSocket connections end on every instance
+func (s *RefreshTokenService) sessionsRevoked(ctx context.Context, userID string, sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+	if s.revoked != nil {
+		if err := s.revoked.MarkSessionsRevoked(ctx, sessionIDs, revokedSessionTTL); err != nil {
+			log.Printf("Error caching revoked sessions: %v", err)
+		}
+	}
+	if s.events != nil {
+		s.events.Publish(Event{Type: EventSessionsRevoked, UserID: userID, SessionIDs: sessionIDs})
+	}
+}
+
+// Revoke ends the session of a token by revoking its family and returns the
+// user, or ErrRefreshTokenInvalid
+func (s *RefreshTokenService) Revoke(ctx context.Context, token string) (userID string, err error) {
+	t, err := s.repo.Get(ctx, hashToken(token))
+	if err == sql.ErrNoRows {
+		return "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	families, err := s.repo.RevokeFamily(ctx, t.FamilyID, RevokedLogout)
+	if err != nil {
+		return "", err
+	}
+	if len(families) == 0 {
+		return "", ErrRefreshTokenRevoked
+	}
+	s.sessionsRevoked(ctx, t.UserID, families)
+	return t.UserID, nil
+}
+
+// RevokeAll ends every session of the user and returns how many were live
+func (s *RefreshTokenService) RevokeAll(ctx context.Context, userID string) (int64, error) {
+	families, err := s.repo.RevokeUser(ctx, userID, "", RevokedLogoutAll)
+	if err != nil {
+		return 0, err
+	}
+	s.sessionsRevoked(ctx, userID, families)
+	return int64(len(families)), nil
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// fakeTokenStore serves one token and records the calls Rotate makes
+type fakeTokenStore struct {
+	refreshTokenStore // other methods are not used by Rotate
+
+	token    *repo.RefreshToken
+	rotateOK bool
+
+	rotated      bool
+	revokedWith  string
+	revokedCalls int
+}
+
+func (f *fakeTokenStore) Get(ctx context.Context, tokenHash string) (*repo.RefreshToken, error) {
+	if f.token == nil {
+		return nil, sql.ErrNoRows
+	}
+	return f.token, nil
+}
+
+func (f *fakeTokenStore) Rotate(ctx context.Context, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error) {
+	f.rotated = true
+	return f.rotateOK, nil
+}
+
+func (f *fakeTokenStore) RevokeFamily(ctx context.Context, familyID, reason string) ([]string, error) {
+	f.revokedCalls++
+	f.revokedWith = reason
+	return []string{familyID}, nil
+}
+
+// fakeRevokedCache records the sessions marked revoked
+type fakeRevokedCache struct{ marked []string }
+
+func (f *fakeRevokedCache) MarkSessionsRevoked(ctx context.Context, sessionIDs []string, ttl time.Duration) error {
+	f.marked = append(f.marked, sessionIDs...)
+	return nil
+}
+
+func (f *fakeRevokedCache) SessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	return false, nil
+}
+
+func TestRefreshTokenRotate(t *testing.T) {
+	const userID, familyID = "user", "family"
+	now := time.Now()
+	at := func(d time.Duration) *time.Time { v := now.Add(d); return &v }
+	token := func(expires time.Duration, rotated, revoked *time.Time) *repo.RefreshToken {
+		return &repo.RefreshToken{UserID: userID, FamilyID: familyID, ExpiresAt: now.Add(expires), RotatedAt: rotated, RevokedAt: revoked}
+	}
+
+	tests := []struct {
+		name       string
+		token      *repo.RefreshToken
+		rotateOK   bool
+		wantErr    error
+		wantRotate bool // repo.Rotate was called
+		wantRevoke bool // the family was revoked for reuse
+	}{
+		{"rotates", token(time.Hour, nil, nil), true, nil, true, false},
+		{"unknown token", nil, true, ErrRefreshTokenInvalid, false, false},
+		{"revoked", token(time.Hour, nil, at(-time.Minute)), true, ErrRefreshTokenRevoked, false, false},
+		{"revoked and rotated", token(time.Hour, at(-time.Hour), at(-time.Minute)), true, ErrRefreshTokenRevoked, false, false},
+		{"expired", token(-time.Second, nil, nil), true, ErrRefreshTokenExpired, false, false},
+		{"rotated within grace", token(time.Hour, at(-rotationGrace/2), nil), true, ErrRefreshTokenRaced, false, false},
+		{"reused after grace", token(time.Hour, at(-rotationGrace-time.Second), nil), true, ErrRefreshTokenReused, false, true},
+		{"reused after expiry", token(-time.Hour, at(-2*time.Hour), nil), true, ErrRefreshTokenReused, false, true},
+		{"lost the rotation race", token(time.Hour, nil, nil), false, ErrRefreshTokenRaced, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeTokenStore{token: tt.token, rotateOK: tt.rotateOK}
+			cache := &fakeRevokedCache{}
+			events := NewEventBus()
+			ch, unsubscribe := events.Subscribe(1)
+			defer unsubscribe()
+			s := &RefreshTokenService{repo: store, revoked: cache, events: events}
+
+			gotUser, gotFamily, next, err := s.Rotate(context.Background(), "token", "ip", "agent")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rotate error = %v, want %v", err, tt.wantErr)
+			}
+			if store.rotated != tt.wantRotate {
+				t.Errorf("repo.Rotate called = %v, want %v", store.rotated, tt.wantRotate)
+			}
+
+			switch {
+			case tt.wantErr == nil:
+				if gotUser != userID || gotFamily != familyID || next == "" {
+					t.Errorf("Rotate = %q, %q, %q, want %q, %q and a new token", gotUser, gotFamily, next, userID, familyID)
+				}
+			case tt.wantRevoke:
+				// The caller notifies the user of the reuse
+				if gotUser != userID || gotFamily != familyID || next != "" {
+					t.Errorf("Rotate = %q, %q, %q, want %q, %q and no token", gotUser, gotFamily, next, userID, familyID)
+				}
+			default:
+				if gotUser != "" || gotFamily != "" || next != "" {
+					t.Errorf("Rotate = %q, %q, %q, want nothing", gotUser, gotFamily, next)
+				}
+			}
+
+			if !tt.wantRevoke {
+				if store.revokedCalls != 0 || len(cache.marked) != 0 {
+					t.Errorf("revoked %d families and cached %v, want none", store.revokedCalls, cache.marked)
+				}
+				select {
+				case ev := <-ch:
+					t.Errorf("published %+v, want nothing", ev)
+				default:
+				}
+				return
+			}
+			if store.revokedCalls != 1 || store.revokedWith != RevokedReuse {
+				t.Errorf("revoked %d families with %q, want 1 with %q", store.revokedCalls, store.revokedWith, RevokedReuse)
+			}
+			if len(cache.marked) != 1 || cache.marked[0] != familyID {
+				t.Errorf("cached revoked sessions %v, want [%s]", cache.marked, familyID)
+			}
+			select {
+			case ev := <-ch:
+				if ev.Type != EventSessionsRevoked || ev.UserID != userID || len(ev.SessionIDs) != 1 || ev.SessionIDs[0] != familyID {
+					t.Errorf("published %+v, want sessions_revoked of %s", ev, familyID)
+				}
+			default:
+				t.Error("no sessions_revoked event published")
+			}
+		})
+	}
+}
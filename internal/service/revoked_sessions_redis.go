@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Revoked sessions on Redis: a key per family, expiring once no access token
+// of the family can still be valid
+
+func revokedSessionKey(sessionID string) string { return "session:revoked:" + sessionID }
+
+// MarkSessionsRevoked records revoked sessions for ttl
+func (s *CacheService) MarkSessionsRevoked(ctx context.Context, sessionIDs []string, ttl time.Duration) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	pipe := s.client.Pipeline()
+	for _, id := range sessionIDs {
+		pipe.Set(ctx, revokedSessionKey(id), "1", ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SessionRevoked reports whether a session was recorded as revoked
+func (s *CacheService) SessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	err := s.client.Get(ctx, revokedSessionKey(sessionID)).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// secretBox encrypts secrets the server must read back, such as API key and
+// TOTP secrets, with AES-256-GCM
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+// newSecretBox derives the AES key from a configured secret
+func newSecretBox(secret string) (*secretBox, error) {
+	if secret == "" {
+		return nil, errors.New("encryption key is empty")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+// seal returns nonce || ciphertext
+func (b *secretBox) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (b *secretBox) open(data []byte) ([]byte, error) {
+	if len(data) < b.aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	return b.aead.Open(nil, nonce, ciphertext, nil)
+}
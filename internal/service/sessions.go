@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login session: a refresh token family
+type Session struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// Sessions lists the user's active sessions; currentID marks the caller's
+func (s *RefreshTokenService) Sessions(ctx context.Context, userID, currentID string) ([]Session, error) {
+	rows, err := s.repo.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(rows))
+	for _, r := range rows {
+		sessions = append(sessions, Session{
+			ID:         r.FamilyID,
+			Device:     DescribeDevice(r.UserAgent),
+			IP:         r.IP,
+			UserAgent:  r.UserAgent,
+			CreatedAt:  r.CreatedAt,
+			LastUsedAt: r.LastUsedAt,
+			ExpiresAt:  r.ExpiresAt,
+			Current:    r.FamilyID == currentID,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions
+func (s *RefreshTokenService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	families, err := s.repo.RevokeUserFamily(ctx, userID, sessionID, RevokedSession)
+	if err != nil {
+		return err
+	}
+	if len(families) == 0 {
+		return ErrSessionNotFound
+	}
+	s.sessionsRevoked(ctx, userID, families)
+	return nil
+}
+
+// RevokeOtherSessions ends every session of the user but currentID and
+// returns how many were live
+func (s *RefreshTokenService) RevokeOtherSessions(ctx context.Context, userID, currentID string) (int64, error) {
+	families, err := s.repo.RevokeUser(ctx, userID, currentID, RevokedSession)
+	if err != nil {
+		return 0, err
+	}
+	s.sessionsRevoked(ctx, userID, families)
+	return int64(len(families)), nil
+}
+
+// DeviceService remembers the devices a user signed in from
+type DeviceService struct {
+	repo *repo.DeviceRepo
+}
+
+func NewDeviceService(r *repo.DeviceRepo) *DeviceService {
+	return &DeviceService{repo: r}
+}
+
+// NewDeviceID returns a random id for the device cookie
+func NewDeviceID() (string, error) {
+	return randomHex(32)
+}
+
+// Seen records a login from a device and reports whether it is a new device
+// of a user who signed in from others before; a first login is not new
+func (s *DeviceService) Seen(ctx context.Context, userID, deviceID, ip, userAgent string) (bool, error) {
+	isNew, hadOthers, err := s.repo.Seen(ctx, userID, hashToken(deviceID), ip, userAgent)
+	if err != nil {
+		return false, err
+	}
+	return isNew && hadOthers, nil
+}
+
+// DescribeDevice names the browser and OS of a user agent, e.g. "Chrome on Windows"
+func DescribeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	var browser string
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "okhttp") || strings.Contains(ua, "dart") || strings.Contains(ua, "cfnetwork"):
+		browser = "App"
+	case strings.Contains(ua, "curl") || strings.Contains(ua, "postman") || strings.Contains(ua, "go-http-client"):
+		browser = "API client"
+	}
+
+	var os string
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		os = "iOS"
+	case strings.Contains(ua, "android"):
+		os = "Android"
+	case strings.Contains(ua, "windows"):
+		os = "Windows"
+	case strings.Contains(ua, "mac os") || strings.Contains(ua, "macintosh"):
+		os = "macOS"
+	case strings.Contains(ua, "linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+	return "Unknown device"
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// tickerWindowMinutes is the rolling window size; the window moves in 1-minute steps
+const tickerWindowMinutes = 24 * 60
+
+// tickerSeedOverlap bounds the time between inserting a trade and publishing
+// it after commit; seeded trades that recent may still arrive as events
+const tickerSeedOverlap = time.Minute
+
+// Ticker holds the rolling 24h statistics of one market
+type Ticker struct {
+	MarketID           string    `json:"marketId"`
+	Symbol             string    `json:"symbol"`
+	LastPrice          float64   `json:"lastPrice"`
+	OpenPrice          float64   `json:"openPrice"`
+	PriceChange        float64   `json:"priceChange"`
+	PriceChangePercent float64   `json:"priceChangePercent"`
+	High               float64   `json:"high"`
+	Low                float64   `json:"low"`
+	BaseVolume         float64   `json:"baseVolume"`
+	QuoteVolume        float64   `json:"quoteVolume"`
+	TradeCount         int64     `json:"tradeCount"`
+	BestBid            float64   `json:"bestBid"` // 0 when the side is empty
+	BestAsk            float64   `json:"bestAsk"` // 0 when the side is empty
+	OpenTime           time.Time `json:"openTime"`
+	CloseTime          time.Time `json:"closeTime"`
+}
+
+// tickerBucket aggregates the trades of one minute
+type tickerBucket struct {
+	minute            time.Time
+	open, high, low   float64
+	close             float64
+	openSeq, closeSeq int64
+	baseVol, quoteVol float64
+	count             int64
+}
+
+// marketTicker keeps a ring of minute buckets covering the window
+type marketTicker struct {
+	marketID string
+	symbol   string
+	buckets  [tickerWindowMinutes]tickerBucket
+	last     float64
+	lastSeq  int64
+	bestBid  float64
+	bestAsk  float64
+	dirty    bool
+}
+
+func bucketIndex(minute time.Time) int {
+	return int((minute.Unix() / 60) % tickerWindowMinutes)
+}
+
+func (m *marketTicker) addTrade(price, amount, quote float64, seq int64, at time.Time) {
+	minute := at.Truncate(time.Minute)
+	b := &m.buckets[bucketIndex(minute)]
+
+	switch {
+	case b.minute.Equal(minute) && b.count > 0:
+		if price > b.high {
+			b.high = price
+		}
+		if price < b.low {
+			b.low = price
+		}
+		if seq < b.openSeq {
+			b.open, b.openSeq = price, seq
+		}
+		if seq > b.closeSeq {
+			b.close, b.closeSeq = price, seq
+		}
+		b.baseVol += amount
+		b.quoteVol += quote
+		b.count++
+	case b.minute.Before(minute) || b.count == 0:
+		*b = tickerBucket{
+			minute: minute,
+			open:   price, high: price, low: price, close: price,
+			openSeq: seq, closeSeq: seq,
+			baseVol: amount, quoteVol: quote,
+			count: 1,
+		}
+	default:
+		// Older than the window the slot now holds
+		return
+	}
+
+	if seq > m.lastSeq {
+		m.last, m.lastSeq = price, seq
+	}
+	m.dirty = true
+}
+
+func (m *marketTicker) snapshot(now time.Time) Ticker {
+	windowStart := now.Truncate(time.Minute).Add(-(tickerWindowMinutes - 1) * time.Minute)
+
+	t := Ticker{
+		MarketID:  m.marketID,
+		Symbol:    m.symbol,
+		LastPrice: m.last,
+		BestBid:   m.bestBid,
+		BestAsk:   m.bestAsk,
+		OpenTime:  windowStart,
+		CloseTime: now,
+	}
+
+	var openSeq int64
+	for i := range m.buckets {
+		b := &m.buckets[i]
+		if b.count == 0 || b.minute.Before(windowStart) {
+			continue
+		}
+		if t.TradeCount == 0 || b.openSeq < openSeq {
+			t.OpenPrice, openSeq = b.open, b.openSeq
+		}
+		if t.TradeCount == 0 || b.high > t.High {
+			t.High = b.high
+		}
+		if t.TradeCount == 0 || b.low < t.Low {
+			t.Low = b.low
+		}
+		t.BaseVolume += b.baseVol
+		t.QuoteVolume += b.quoteVol
+		t.TradeCount += b.count
+	}
+
+	if t.TradeCount == 0 {
+		// Quiet market: flat stats around the last known price
+		t.OpenPrice, t.High, t.Low = m.last, m.last, m.last
+		return t
+	}
+
+	t.PriceChange = t.LastPrice - t.OpenPrice
+	if t.OpenPrice != 0 {
+		t.PriceChangePercent = t.PriceChange / t.OpenPrice * 100
+	}
+	return t
+}
+
+// TickerService maintains rolling 24h tickers for all markets incrementally
+// from trade and order book events, seeded once from the trades table.
+type TickerService struct {
+	market *repo.MarketRepo
+	order  *repo.OrderRepo
+	trade  *repo.TradeRepo
+	events *EventBus
+
+	mu       sync.RWMutex
+	tickers  map[string]*marketTicker // market id -> ticker
+	bySymbol map[string]string        // symbol -> market id
+
+	// Recent trades counted while seeding: their events may still arrive,
+	// and are skipped by sequence until seededUntil
+	seeded      map[int64]struct{}
+	seededUntil time.Time
+}
+
+// NewTickerService creates a ticker service; call Start to seed and run it
+func NewTickerService(mr *repo.MarketRepo, or *repo.OrderRepo, tr *repo.TradeRepo, events *EventBus) *TickerService {
+	return &TickerService{
+		market:   mr,
+		order:    or,
+		trade:    tr,
+		events:   events,
+		tickers:  make(map[string]*marketTicker),
+		bySymbol: make(map[string]string),
+	}
+}
+
+// Start seeds the tickers from the database and keeps them updated from events
+func (s *TickerService) Start(ctx context.Context) error {
+	// Subscribe before seeding so no trade falls between the two
+	ch, unsubscribe := s.events.Subscribe(4096)
+
+	if err := s.seed(ctx); err != nil {
+		unsubscribe()
+		return err
+	}
+
+	go s.run(ctx, ch, unsubscribe)
+	return nil
+}
+
+// Get returns the ticker of a market by symbol
+func (s *TickerService) Get(symbol string) (Ticker, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.tickers[s.bySymbol[symbol]]
+	if !ok {
+		return Ticker{}, false
+	}
+	return m.snapshot(time.Now()), true
+}
+
+// All returns the tickers of all markets sorted by symbol
+func (s *TickerService) All() []Ticker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	out := make([]Ticker, 0, len(s.tickers))
+	for _, m := range s.tickers {
+		out = append(out, m.snapshot(now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Symbol < out[j].Symbol })
+	return out
+}
+
+func (s *TickerService) seed(ctx context.Context) error {
+	markets, err := s.market.GetAllActiveMarkets(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, mk := range markets {
+		s.getOrCreate(mk.ID, mk.Symbol)
+	}
+	s.mu.Unlock()
+
+	// Events of trades committed since subscribing may already be counted by
+	// the seed. They are told apart by sequence, not by the highest seeded
+	// sequence: sequences are taken at insert, so a trade numbered below it
+	// can commit after the seed read. Trades are inserted and committed within
+	// tickerSeedOverlap, so only those since then need remembering.
+	now := time.Now()
+	since := now.Truncate(time.Minute).Add(-(tickerWindowMinutes - 1) * time.Minute)
+	stats, recentSeqs, err := s.trade.GetMinuteStats(ctx, since, now.Add(-tickerSeedOverlap))
+	if err != nil {
+		return err
+	}
+	last, err := s.trade.GetLastTrades(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, st := range stats {
+		m, ok := s.tickers[st.MarketID]
+		if !ok {
+			continue // Inactive market
+		}
+		m.buckets[bucketIndex(st.Minute)] = tickerBucket{
+			minute: st.Minute,
+			open:   st.Open, high: st.High, low: st.Low, close: st.Close,
+			openSeq: st.FirstSeq, closeSeq: st.LastSeq,
+			baseVol: st.BaseVolume, quoteVol: st.QuoteVolume,
+			count: st.Count,
+		}
+	}
+	for _, t := range last {
+		if m, ok := s.tickers[t.MarketID]; ok && t.Seq > m.lastSeq {
+			m.last, m.lastSeq = t.Price, t.Seq
+		}
+	}
+	s.seeded = make(map[int64]struct{}, len(recentSeqs))
+	for _, seq := range recentSeqs {
+		s.seeded[seq] = struct{}{}
+	}
+	s.seededUntil = time.Now().Add(tickerSeedOverlap)
+	s.mu.Unlock()
+
+	for _, mk := range markets {
+		s.refreshBook(ctx, mk.ID)
+	}
+
+	log.Printf("Ticker service seeded %d markets from %d minute buckets", len(markets), len(stats))
+	return nil
+}
+
+func (s *TickerService) run(ctx context.Context, ch <-chan Event, unsubscribe func()) {
+	defer unsubscribe()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	lastMinute := time.Now().Truncate(time.Minute)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev := <-ch:
+			switch ev.Type {
+			case EventTrades:
+				s.applyTrades(ev)
+			case EventBookChanged:
+				s.refreshBook(ctx, ev.MarketID)
+			}
+
+		case now := <-ticker.C:
+			// Old buckets leave the window every minute, even without trades
+			if minute := now.Truncate(time.Minute); minute.After(lastMinute) {
+				lastMinute = minute
+				s.markAllDirty()
+			}
+			s.publishDirty(now)
+		}
+	}
+}
+
+func (s *TickerService) applyTrades(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seeded != nil && time.Now().After(s.seededUntil) {
+		s.seeded = nil // Every event of a seeded trade has arrived
+	}
+
+	m := s.getOrCreate(ev.MarketID, ev.Symbol)
+	for _, t := range ev.Trades {
+		if _, ok := s.seeded[t.Seq]; ok {
+			delete(s.seeded, t.Seq)
+			continue // Already counted while seeding
+		}
+		m.addTrade(t.Price, t.Amount, t.QuoteAmount, t.Seq, t.TradeTime)
+	}
+}
+
+func (s *TickerService) refreshBook(ctx context.Context, marketID string) {
+	book, err := s.order.GetOrderBook(ctx, marketID, 1, nil)
+	if err != nil {
+		log.Printf("Ticker: error fetching top of book for market %s: %v", marketID, err)
+		return
+	}
+
+	var bid, ask float64
+	if len(book.Bids) > 0 {
+		bid = book.Bids[0].Price
+	}
+	if len(book.Asks) > 0 {
+		ask = book.Asks[0].Price
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.tickers[marketID]
+	if !ok {
+		return
+	}
+	if m.bestBid != bid || m.bestAsk != ask {
+		m.bestBid, m.bestAsk = bid, ask
+		m.dirty = true
+	}
+}
+
+func (s *TickerService) markAllDirty() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.tickers {
+		m.dirty = true
+	}
+}
+
+func (s *TickerService) publishDirty(now time.Time) {
+	s.mu.Lock()
+	var changed []Ticker
+	for _, m := range s.tickers {
+		if m.dirty {
+			changed = append(changed, m.snapshot(now))
+			m.dirty = false
+		}
+	}
+	s.mu.Unlock()
+
+	if len(changed) > 0 {
+		s.events.Publish(Event{Type: EventTicker, Tickers: changed})
+	}
+}
+
+// getOrCreate must be called with s.mu held
+func (s *TickerService) getOrCreate(marketID, symbol string) *marketTicker {
+	m, ok := s.tickers[marketID]
+	if !ok {
+		m = &marketTicker{marketID: marketID, symbol: symbol}
+		s.tickers[marketID] = m
+		s.bySymbol[symbol] = marketID
+	}
+	return m
+}
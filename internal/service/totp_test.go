@@ -0,0 +1,67 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Key is the SHA1 secret of the RFC 6238 test vectors
+const rfc6238Key = "12345678901234567890"
+
+// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestHOTPRFC6238Vectors(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		step := totpStep(time.Unix(tt.unix, 0))
+		if got := hotp([]byte(rfc6238Key), uint64(step)); got != tt.code {
+			t.Errorf("hotp at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte(rfc6238Key))
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      time.Time
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", secret, "050471", now, 0, step, true},
+		{"lowercase secret", strings.ToLower(secret), "050471", now, 0, step, true},
+		{"previous step within skew", secret, "050471", now.Add(totpPeriod), 0, step, true},
+		{"next step within skew", secret, "050471", now.Add(-totpPeriod), 0, step, true},
+		{"outside skew", secret, "050471", now.Add(2 * totpPeriod), 0, 0, false},
+		{"step already used", secret, "050471", now, step, 0, false},
+		{"wrong code", secret, "050472", now, 0, 0, false},
+		{"wrong length", secret, "50471", now, 0, 0, false},
+		{"8-digit code", secret, "14050471", now, 0, 0, false},
+		{"invalid secret", "not base32!", "050471", now, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := validateTOTP(tt.secret, tt.code, tt.now, tt.lastStep)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("validateTOTP = (%d, %v), want (%d, %v)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+var (
+	ErrTwoFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTwoFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrTwoFANotEnrolled      = errors.New("two-factor authentication enrollment not started")
+	ErrInvalidTwoFACode      = errors.New("invalid two-factor authentication code")
+	ErrInvalidLoginChallenge = errors.New("login challenge is invalid or expired")
+	ErrTwoFALocked           = errors.New("too many invalid two-factor authentication codes")
+)
+
+// TwoFALockedError is returned by Verify while code checks are locked
+type TwoFALockedError struct{ Until time.Time }
+
+func (e *TwoFALockedError) Error() string {
+	return ErrTwoFALocked.Error() + ", try again after " + e.Until.UTC().Format(time.RFC3339)
+}
+
+func (e *TwoFALockedError) Is(target error) bool { return target == ErrTwoFALocked }
+
+const (
+	LoginChallengeTTL      = 5 * time.Minute
+	maxLoginChallengeTries = 5
+	// maxTwoFAFailures wrong codes in a row, across logins and X-2FA-CODE
+	// checks, lock code checks for twoFALockout
+	maxTwoFAFailures        = 5
+	twoFALockout            = 15 * time.Minute
+	recoveryCodeCount       = 10
+	recoveryCodeBytes       = 5 // 10 hex characters, shown as xxxxx-xxxxx
+	defaultTwoFAIssuerLabel = "Crypto Trading"
+)
+
+// TwoFAService manages TOTP two-factor authentication: enrollment, code and
+// recovery code checks, and the second step of a login
+type TwoFAService struct {
+	repo   *repo.TwoFARepo
+	box    *secretBox
+	issuer string
+}
+
+// NewTwoFAService creates the service; TOTP secrets are encrypted with a key
+// derived from encryptionKey and issuer names the account in authenticator apps
+func NewTwoFAService(r *repo.TwoFARepo, encryptionKey, issuer string) (*TwoFAService, error) {
+	box, err := newSecretBox(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("2FA %w", err)
+	}
+	if issuer == "" {
+		issuer = defaultTwoFAIssuerLabel
+	}
+	return &TwoFAService{repo: r, box: box, issuer: issuer}, nil
+}
+
+// TwoFAStatus is the user's 2FA state
+type TwoFAStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+func (s *TwoFAService) Status(ctx context.Context, userID string) (*TwoFAStatus, error) {
+	st, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &TwoFAStatus{Enabled: st.Enabled}
+	if st.Enabled {
+		if status.RecoveryCodesRemaining, err = s.repo.CountRecoveryCodes(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Enabled reports whether the user has 2FA on
+func (s *TwoFAService) Enabled(ctx context.Context, userID string) (bool, error) {
+	st, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return st.Enabled, nil
+}
+
+// Enroll starts enrollment with a new secret, returned with its otpauth URI.
+// 2FA is enabled once a code of the secret is confirmed with Enable.
+func (s *TwoFAService) Enroll(ctx context.Context, userID, account string) (secret, uri string, err error) {
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	enc, err := s.box.seal([]byte(secret))
+	if err != nil {
+		return "", "", err
+	}
+	err = s.repo.SetPendingSecret(ctx, userID, base64.StdEncoding.EncodeToString(enc))
+	if err == sql.ErrNoRows {
+		return "", "", ErrTwoFAAlreadyEnabled
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(s.issuer, account, secret), nil
+}
+
+// Enable turns 2FA on with a code of the enrolled secret and returns the
+// recovery codes, which are only shown here
+func (s *TwoFAService) Enable(ctx context.Context, userID, code string) ([]string, error) {
+	st, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if st.Enabled {
+		return nil, ErrTwoFAAlreadyEnabled
+	}
+	if st.SecretEnc == nil {
+		return nil, ErrTwoFANotEnrolled
+	}
+	step, ok, err := s.checkTOTP(st, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTwoFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.Enable(ctx, userID, step, hashes)
+	if err == sql.ErrNoRows {
+		return nil, ErrTwoFAAlreadyEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify accepts a TOTP code or an unused recovery code. Each is accepted
+// once: a TOTP code cannot be replayed within its validity window. Every
+// check counts until one succeeds; too many lock checks with a
+// *TwoFALockedError.
+func (s *TwoFAService) Verify(ctx context.Context, userID, code string) error {
+	st, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !st.Enabled || st.SecretEnc == nil {
+		return ErrTwoFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrInvalidTwoFACode
+	}
+	lockedUntil, ok, err := s.repo.BeginAttempt(ctx, userID, maxTwoFAFailures, twoFALockout)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &TwoFALockedError{Until: lockedUntil}
+	}
+
+	if err := s.checkCode(ctx, userID, st, code); err != nil {
+		return err
+	}
+	return s.repo.ResetAttempts(ctx, userID)
+}
+
+// checkCode checks a TOTP or recovery code and uses it up
+func (s *TwoFAService) checkCode(ctx context.Context, userID string, st *repo.TwoFAState, code string) error {
+	if len(code) == totpDigits {
+		step, ok, err := s.checkTOTP(st, code)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if ok, err = s.repo.AdvanceStep(ctx, userID, step); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			return ErrInvalidTwoFACode
+		}
+		return nil
+	}
+
+	ok, err := s.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFACode
+	}
+	return nil
+}
+
+// Disable turns 2FA off after checking a code
+func (s *TwoFAService) Disable(ctx context.Context, userID, code string) error {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.repo.Disable(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a code
+func (s *TwoFAService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// StartLogin issues the challenge token of a login waiting for its code
+func (s *TwoFAService) StartLogin(ctx context.Context, userID, ip string) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.InsertChallenge(ctx, userID, hashToken(token), ip, time.Now().Add(LoginChallengeTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// LoginUser returns the user of a pending login challenge, so the login can
+// be checked before its code is
+func (s *TwoFAService) LoginUser(ctx context.Context, token string) (string, error) {
+	userID, err := s.repo.ChallengeUser(ctx, hashToken(token), maxLoginChallengeTries)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidLoginChallenge
+	}
+	return userID, err
+}
+
+// CompleteLogin checks the code of a login challenge and returns its user.
+// A challenge allows a few attempts and completes once.
+func (s *TwoFAService) CompleteLogin(ctx context.Context, token, code string) (string, error) {
+	tokenHash := hashToken(token)
+	userID, err := s.repo.AttemptChallenge(ctx, tokenHash, maxLoginChallengeTries)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidLoginChallenge
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := s.Verify(ctx, userID, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFACode) {
+			return userID, err
+		}
+		return "", err
+	}
+	ok, err := s.repo.CompleteChallenge(ctx, tokenHash)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrInvalidLoginChallenge
+	}
+	return userID, nil
+}
+
+// checkTOTP decrypts the secret and checks a code, skipping steps already used
+func (s *TwoFAService) checkTOTP(st *repo.TwoFAState, code string) (int64, bool, error) {
+	enc, err := base64.StdEncoding.DecodeString(*st.SecretEnc)
+	if err != nil {
+		return 0, false, fmt.Errorf("decoding 2FA secret: %w", err)
+	}
+	secret, err := s.box.open(enc)
+	if err != nil {
+		return 0, false, fmt.Errorf("decrypting 2FA secret: %w", err)
+	}
+	step, ok := validateTOTP(string(secret), strings.TrimSpace(code), time.Now(), st.LastStep)
+	return step, ok, nil
+}
+
+// newRecoveryCodes returns codes for the user and the hashes to store
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := hex.EncodeToString(buf)
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a code ignoring case, dashes and spaces
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
+
+// hashToken is the SHA-256 hex of a token, as stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
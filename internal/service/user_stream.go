@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+)
+
+// Security event types
+const (
+	SecurityLogin          = "login"
+	SecurityLoginFailed    = "login_failed"
+	SecurityLogout         = "logout"
+	SecurityLogoutAll      = "logout_all"
+	SecurityNewDevice      = "new_device"
+	SecuritySessionRevoked = "session_revoked"
+
+	// A rotated refresh token was presented again; its session was revoked
+	SecurityRefreshTokenReuse = "refresh_token_reuse"
+
+	SecurityTwoFAEnabled  = "2fa_enabled"
+	SecurityTwoFADisabled = "2fa_disabled"
+
+	SecurityPasskeyAdded   = "passkey_added"
+	SecurityPasskeyRemoved = "passkey_removed"
+
+	// Too many failed logins locked the account / its unlock token was used
+	SecurityAccountLocked   = "account_locked"
+	SecurityAccountUnlocked = "account_unlocked"
+
+	SecurityPasswordChanged = "password_changed"
+	SecurityPasswordReset   = "password_reset"
+	SecurityEmailVerified   = "email_verified"
+)
+
+// SecurityEvent is an account security event pushed to the user's stream
+type SecurityEvent struct {
+	Type      string    `json:"type"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Time      time.Time `json:"time"`
+}
+
+// PublishSecurityEvent publishes a security event for a user. It is safe to
+// call on a nil bus.
+func PublishSecurityEvent(events *EventBus, userID, typ, ip, userAgent string) {
+	events.Publish(Event{
+		Type:     EventSecurity,
+		UserID:   userID,
+		Security: &SecurityEvent{Type: typ, IP: ip, UserAgent: userAgent, Time: time.Now()},
+	})
+}
+
+// Order update kinds of the user stream
+const (
+	OrderAccepted = "accepted"
+	OrderUpdated  = "updated"
+)
+
+// OrderUpdateKind names an order update from the order's state: its terminal
+// status, "accepted" when the order has not changed since it was placed, and
+// "updated" otherwise.
+func OrderUpdateKind(o *models.Order) string {
+	switch o.Status {
+	case models.Filled, models.Canceled, models.Rejected, models.Expired:
+		return string(o.Status)
+	}
+	// Placement inserts and fills the taker in one transaction, so its
+	// timestamps are equal until a later change
+	if o.UpdatedAt.Equal(o.CreatedAt) {
+		return OrderAccepted
+	}
+	return OrderUpdated
+}
+
+// UserStreamService turns committed trading activity into private per-user
+// events. Like BookService it re-reads the orders, fills and balances named by
+// market events, so everything it publishes reflects committed state.
+type UserStreamService struct {
+	order  *repo.OrderRepo
+	trade  *repo.TradeRepo
+	wallet *repo.WalletRepo
+	events *EventBus
+}
+
+// NewUserStreamService creates a user stream service
+func NewUserStreamService(or *repo.OrderRepo, tr *repo.TradeRepo, wr *repo.WalletRepo, events *EventBus) *UserStreamService {
+	return &UserStreamService{order: or, trade: tr, wallet: wr, events: events}
+}
+
+// Start processes market events until ctx is done
+func (s *UserStreamService) Start(ctx context.Context) {
+	ch, unsubscribe := s.events.Subscribe(4096)
+	go s.run(ctx, ch, unsubscribe)
+}
+
+func (s *UserStreamService) run(ctx context.Context, ch <-chan Event, unsubscribe func()) {
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-ch:
+			var err error
+			switch ev.Type {
+			case EventTrades:
+				err = s.publishFills(ctx, ev.Trades)
+			case EventBookChanged:
+				err = s.publishOrders(ctx, ev.MarketID, ev.OrderIDs)
+			}
+			if err != nil {
+				log.Printf("UserStream: error handling %s event for market %s: %v", ev.Type, ev.MarketID, err)
+			}
+		}
+	}
+}
+
+// publishFills sends each side of the trades to the owner of the order
+func (s *UserStreamService) publishFills(ctx context.Context, trades []*models.Trade) error {
+	ids := make([]string, len(trades))
+	for i, t := range trades {
+		ids[i] = t.ID
+	}
+	fills, err := s.trade.GetFillsByTradeIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byUser := make(map[string][]repo.UserFill)
+	var users []string
+	for _, f := range fills {
+		if _, ok := byUser[f.UserID]; !ok {
+			users = append(users, f.UserID)
+		}
+		byUser[f.UserID] = append(byUser[f.UserID], f)
+	}
+	for _, userID := range users {
+		s.events.Publish(Event{Type: EventUserFills, UserID: userID, Fills: byUser[userID]})
+	}
+	return nil
+}
+
+// publishOrders sends the current state of the touched orders to their owners,
+// followed by the owners' balances in the market's assets
+func (s *UserStreamService) publishOrders(ctx context.Context, marketID string, orderIDs []string) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	orders, err := s.order.GetByIDs(ctx, orderIDs)
+	if err != nil {
+		return err
+	}
+
+	byUser := make(map[string][]*models.Order)
+	var users []string
+	for _, o := range orders {
+		if _, ok := byUser[o.UserID]; !ok {
+			users = append(users, o.UserID)
+		}
+		byUser[o.UserID] = append(byUser[o.UserID], o)
+	}
+	for _, userID := range users {
+		s.events.Publish(Event{Type: EventUserOrders, MarketID: marketID, UserID: userID, Orders: byUser[userID]})
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+	balances, err := s.wallet.GetMarketBalances(ctx, users, marketID)
+	if err != nil {
+		return err
+	}
+	balancesByUser := make(map[string][]repo.Balance)
+	for _, b := range balances {
+		balancesByUser[b.UserID] = append(balancesByUser[b.UserID], b)
+	}
+	for _, userID := range users {
+		if len(balancesByUser[userID]) > 0 {
+			s.events.Publish(Event{Type: EventUserBalances, MarketID: marketID, UserID: userID, Balances: balancesByUser[userID]})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/models"
+	"github.com/dangdinh2405/cryto-trading-web-backend/internal/repo"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPasskeyChallenge    = errors.New("passkey challenge is invalid or expired")
+	ErrPasskeyVerification = errors.New("passkey verification failed")
+	ErrPasskeyNotFound     = errors.New("passkey not found")
+	ErrPasskeyExists       = errors.New("passkey is already registered")
+	ErrPasskeySignCount    = errors.New("passkey signature counter did not increase, the authenticator may be cloned")
+	ErrInvalidPasskeyName  = errors.New("passkey name must be 1 to 64 characters")
+)
+
+const (
+	webauthnTimeout = 5 * time.Minute
+
+	challengeRegistration = "registration"
+	challengeLogin        = "login"
+)
+
+// WebAuthnConfig identifies the relying party to authenticators
+type WebAuthnConfig struct {
+	RPID    string   // Domain of the site, e.g. example.com
+	RPName  string   // Name shown by the authenticator
+	Origins []string // Origins allowed in client data, e.g. https://example.com
+}
+
+// WebAuthnService runs the passkey registration and login ceremonies.
+// Attestation is not requested ("none"), so any authenticator is accepted.
+type WebAuthnService struct {
+	repo    *repo.WebAuthnRepo
+	cfg     WebAuthnConfig
+	origins map[string]bool
+}
+
+func NewWebAuthnService(r *repo.WebAuthnRepo, cfg WebAuthnConfig) *WebAuthnService {
+	origins := make(map[string]bool, len(cfg.Origins))
+	for _, o := range cfg.Origins {
+		origins[strings.TrimRight(o, "/")] = true
+	}
+	return &WebAuthnService{repo: r, cfg: cfg, origins: origins}
+}
+
+// Options types, in the WebAuthn JSON form accepted by
+// PublicKeyCredential.parseCreationOptionsFromJSON / parseRequestOptionsFromJSON
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUser struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	Challenge              Base64URL              `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   WebAuthnUser           `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+}
+
+// Credential types, as serialized by PublicKeyCredential.toJSON()
+
+type RegistrationResponse struct {
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		Transports        []string  `json:"transports"`
+	} `json:"response"`
+}
+
+type AssertionResponse struct {
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+// PasskeyLogin is the result of a verified assertion
+type PasskeyLogin struct {
+	UserID       string
+	UserVerified bool // The authenticator checked a PIN or biometric
+}
+
+// BeginRegistration returns the options of a new credential named name
+func (s *WebAuthnService) BeginRegistration(ctx context.Context, userID, username, displayName, name string) (*CreationOptions, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return nil, ErrInvalidPasskeyName
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.repo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.newChallenge(ctx, challengeRegistration, userID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: s.cfg.RPID, Name: s.cfg.RPName},
+		User:      WebAuthnUser{ID: uid[:], Name: username, DisplayName: displayName},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: coseES256},
+			{Type: "public-key", Alg: coseEdDSA},
+			{Type: "public-key", Alg: coseRS256},
+		},
+		Timeout:            webauthnTimeout.Milliseconds(),
+		Attestation:        "none",
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+	}
+	return opts, nil
+}
+
+// FinishRegistration verifies a new credential and stores it
+func (s *WebAuthnService) FinishRegistration(ctx context.Context, userID string, resp RegistrationResponse) (*models.WebAuthnCredential, error) {
+	cd, err := parseClientData(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	challengeUser, name, err := s.consumeChallenge(ctx, cd, "webauthn.create", challengeRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challengeUser != userID {
+		return nil, ErrPasskeyChallenge
+	}
+
+	v, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed attestation object", ErrPasskeyVerification)
+	}
+	attestation, _ := v.(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, fmt.Errorf("%w: no attested credential", ErrPasskeyVerification)
+	}
+	if !bytes.Equal(ad.credentialID, resp.RawID) {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrPasskeyVerification)
+	}
+	if _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyVerification, err)
+	}
+
+	if _, err := s.repo.GetCredential(ctx, ad.credentialID); err == nil {
+		return nil, ErrPasskeyExists
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	transports := resp.Response.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	cred := &models.WebAuthnCredential{
+		UserID:       userID,
+		CredentialID: ad.credentialID,
+		PublicKey:    ad.publicKey,
+		SignCount:    int64(ad.signCount),
+		Name:         name,
+		Transports:   transports,
+		AAGUID:       formatAAGUID(ad.aaguid),
+	}
+	if err := s.repo.InsertCredential(ctx, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// BeginLogin returns the options of a login. With a user, only their
+// credentials are allowed; without one, the authenticator offers its
+// discoverable credentials (passkey autofill).
+func (s *WebAuthnService) BeginLogin(ctx context.Context, userID string) (*RequestOptions, error) {
+	allowed := []CredentialDescriptor{}
+	if userID != "" {
+		creds, err := s.repo.ListByUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		allowed = descriptors(creds)
+	}
+	challenge, err := s.newChallenge(ctx, challengeLogin, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		RPID:             s.cfg.RPID,
+		Timeout:          webauthnTimeout.Milliseconds(),
+		UserVerification: "preferred",
+		AllowCredentials: allowed,
+	}, nil
+}
+
+// FinishLogin verifies an assertion and returns the user it authenticates
+func (s *WebAuthnService) FinishLogin(ctx context.Context, resp AssertionResponse) (*PasskeyLogin, error) {
+	cd, err := parseClientData(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	challengeUser, _, err := s.consumeChallenge(ctx, cd, "webauthn.get", challengeLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := s.repo.GetCredential(ctx, resp.RawID)
+	if err == sql.ErrNoRows {
+		return nil, ErrPasskeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if challengeUser != "" && challengeUser != cred.UserID {
+		return nil, ErrPasskeyNotFound
+	}
+	if len(resp.Response.UserHandle) > 0 {
+		uid, err := uuid.Parse(cred.UserID)
+		if err != nil || !bytes.Equal(resp.Response.UserHandle, uid[:]) {
+			return nil, fmt.Errorf("%w: user handle mismatch", ErrPasskeyVerification)
+		}
+	}
+
+	ad, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+
+	key, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("stored passkey public key: %w", err)
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, resp.Response.Signature) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrPasskeyVerification)
+	}
+
+	// Authenticators without a counter always report 0; otherwise it must grow
+	count := int64(ad.signCount)
+	if (count != 0 || cred.SignCount != 0) && count <= cred.SignCount {
+		return &PasskeyLogin{UserID: cred.UserID}, ErrPasskeySignCount
+	}
+	ok, err := s.repo.UpdateSignCount(ctx, cred.ID, count)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &PasskeyLogin{UserID: cred.UserID}, ErrPasskeySignCount
+	}
+
+	return &PasskeyLogin{UserID: cred.UserID, UserVerified: ad.flags&authFlagUserVerified != 0}, nil
+}
+
+// List returns the user's passkeys
+func (s *WebAuthnService) List(ctx context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	return s.repo.ListByUser(ctx, userID)
+}
+
+// Rename renames one of the user's passkeys
+func (s *WebAuthnService) Rename(ctx context.Context, userID, id, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return ErrInvalidPasskeyName
+	}
+	ok, err := s.repo.Rename(ctx, userID, id, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// Remove deletes one of the user's passkeys
+func (s *WebAuthnService) Remove(ctx context.Context, userID, id string) error {
+	ok, err := s.repo.Delete(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func (s *WebAuthnService) newChallenge(ctx context.Context, kind, userID, name string) (Base64URL, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(challenge)
+	if err := s.repo.InsertChallenge(ctx, encoded, kind, userID, name, time.Now().Add(webauthnTimeout)); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// consumeChallenge checks the client data type and origin and uses up its
+// challenge, returning the ceremony's user and credential name
+func (s *WebAuthnService) consumeChallenge(ctx context.Context, cd *clientData, typ, kind string) (userID, name string, err error) {
+	if cd.Type != typ {
+		return "", "", fmt.Errorf("%w: unexpected client data type %q", ErrPasskeyVerification, cd.Type)
+	}
+	if !s.origins[cd.Origin] {
+		return "", "", fmt.Errorf("%w: origin %q not allowed", ErrPasskeyVerification, cd.Origin)
+	}
+	userID, name, err = s.repo.ConsumeChallenge(ctx, strings.TrimRight(cd.Challenge, "="), kind)
+	if err == sql.ErrNoRows {
+		return "", "", ErrPasskeyChallenge
+	}
+	return userID, name, err
+}
+
+// checkAuthenticatorData checks the RP id hash and user presence
+func (s *WebAuthnService) checkAuthenticatorData(ad *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(s.cfg.RPID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: RP id mismatch", ErrPasskeyVerification)
+	}
+	if ad.flags&authFlagUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrPasskeyVerification)
+	}
+	return nil
+}
+
+func descriptors(creds []*models.WebAuthnCredential) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: c.CredentialID, Transports: c.Transports})
+	}
+	return list
+}
@@ -0,0 +1,14 @@
+-- Client-supplied order IDs and keyset pagination indexes for order/trade history.
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS client_order_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS orders_user_client_order_id_key
+    ON orders (user_id, client_order_id)
+    WHERE client_order_id IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS orders_user_created_idx
+    ON orders (user_id, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS trades_maker_order_idx ON trades (maker_order_id);
+CREATE INDEX IF NOT EXISTS trades_taker_order_idx ON trades (taker_order_id);
+CREATE INDEX IF NOT EXISTS trades_time_idx ON trades (trade_time DESC, id DESC);
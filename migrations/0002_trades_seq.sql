@@ -0,0 +1,29 @@
+-- Monotonic trade sequence used as the public trade ID and for ordered trade streams.
+
+-- Existing trades are numbered in trade order (BIGSERIAL would number them in
+-- physical order), then the sequence continues after the highest number.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND table_name = 'trades'
+          AND column_name = 'seq'
+    ) THEN
+        ALTER TABLE trades ADD COLUMN seq BIGINT;
+
+        UPDATE trades t SET seq = n.rn
+        FROM (SELECT id, row_number() OVER (ORDER BY trade_time, id) AS rn FROM trades) n
+        WHERE t.id = n.id;
+
+        CREATE SEQUENCE trades_seq_seq OWNED BY trades.seq;
+        PERFORM setval('trades_seq_seq', COALESCE((SELECT MAX(seq) FROM trades), 0) + 1, false);
+        ALTER TABLE trades ALTER COLUMN seq SET DEFAULT nextval('trades_seq_seq');
+        ALTER TABLE trades ALTER COLUMN seq SET NOT NULL;
+    END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS trades_seq_key ON trades (seq);
+CREATE INDEX IF NOT EXISTS trades_market_seq_idx ON trades (market_id, seq DESC);
+CREATE INDEX IF NOT EXISTS trades_market_time_idx ON trades (market_id, trade_time);
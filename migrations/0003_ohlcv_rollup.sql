@@ -0,0 +1,16 @@
+-- Materialized candles for intervals above 1m (5m, 15m, 1h, 4h, 1D, 1W, 1M),
+-- maintained from ohlcv_1m as minutes close. 1D/1W/1M buckets are aligned to
+-- CANDLE_TIMEZONE; rebuild them after changing it.
+
+CREATE TABLE IF NOT EXISTS ohlcv_rollup (
+    symbol     TEXT        NOT NULL,
+    timeframe  TEXT        NOT NULL,
+    open_time  TIMESTAMPTZ NOT NULL,
+    close_time TIMESTAMPTZ NOT NULL,
+    open       NUMERIC     NOT NULL,
+    high       NUMERIC     NOT NULL,
+    low        NUMERIC     NOT NULL,
+    close      NUMERIC     NOT NULL,
+    volume     NUMERIC     NOT NULL DEFAULT 0,
+    PRIMARY KEY (symbol, timeframe, open_time)
+);
@@ -0,0 +1,19 @@
+-- API keys for programmatic access. Requests are signed with HMAC-SHA256, so
+-- the secret is stored encrypted (AES-GCM, key from API_KEY_ENCRYPTION_KEY)
+-- rather than hashed.
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key          TEXT        NOT NULL UNIQUE,
+    secret_enc   BYTEA       NOT NULL,
+    label        TEXT        NOT NULL DEFAULT '',
+    scopes       TEXT[]      NOT NULL,
+    ip_allowlist TEXT[]      NOT NULL DEFAULT '{}',
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id) WHERE revoked_at IS NULL;
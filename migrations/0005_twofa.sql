@@ -0,0 +1,31 @@
+-- TOTP two-factor authentication. user_auth.twofa_secret holds the secret
+-- encrypted like API key secrets (base64 of AES-GCM nonce || ciphertext), set
+-- on enrollment and only enabled once a first code is verified.
+
+ALTER TABLE user_auth ALTER COLUMN twofa_secret TYPE TEXT;
+-- Last accepted TOTP time step, so a code cannot be used twice
+ALTER TABLE user_auth ADD COLUMN IF NOT EXISTS twofa_last_step BIGINT;
+
+-- One-time recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS twofa_recovery_codes (
+    id         UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash  TEXT        NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_twofa_recovery_codes_user ON twofa_recovery_codes (user_id) WHERE used_at IS NULL;
+
+-- Second step of a login with 2FA: issued after the password check, exchanged
+-- for tokens with a code
+CREATE TABLE IF NOT EXISTS login_challenges (
+    id           UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash   TEXT        NOT NULL UNIQUE,
+    ip_address   INET,
+    attempts     INT         NOT NULL DEFAULT 0,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
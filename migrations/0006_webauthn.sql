@@ -0,0 +1,27 @@
+-- WebAuthn passkeys. A user may register several named credentials, so they
+-- live in their own table; user_auth.passkey_public_key is not used.
+-- users.passkey_enabled is kept in sync with whether any credential exists.
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id            UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA       NOT NULL UNIQUE,
+    public_key    BYTEA       NOT NULL, -- COSE_Key from the attested credential data
+    sign_count    BIGINT      NOT NULL DEFAULT 0,
+    name          TEXT        NOT NULL,
+    transports    TEXT[]      NOT NULL DEFAULT '{}',
+    aaguid        UUID,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user ON webauthn_credentials (user_id);
+
+-- Pending registration and login ceremonies; each challenge is used once
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    challenge  TEXT        PRIMARY KEY,
+    kind       TEXT        NOT NULL, -- 'registration' or 'login'
+    user_id    UUID        REFERENCES users(id) ON DELETE CASCADE,
+    name       TEXT        NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL
+);
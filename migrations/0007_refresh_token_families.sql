@@ -0,0 +1,16 @@
+-- Refresh token rotation. Every refresh replaces the token with a new one of
+-- the same family (one family per login); presenting a token that was already
+-- rotated revokes the whole family.
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+UPDATE refresh_tokens SET family_id = gen_random_uuid() WHERE family_id IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET DEFAULT gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at     TIMESTAMPTZ;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS revoked_reason TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_active ON refresh_tokens (user_id)
+    WHERE revoked_at IS NULL AND rotated_at IS NULL;
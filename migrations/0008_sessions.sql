@@ -0,0 +1,15 @@
+-- Login sessions are refresh token families; the live token of a family
+-- carries the client of its last refresh. Known devices are remembered per
+-- user (by the hash of a device cookie) to notify logins from new ones.
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS user_devices (
+    user_id       UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_hash   TEXT        NOT NULL,
+    user_agent    TEXT        NOT NULL DEFAULT '',
+    ip_address    INET,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_hash)
+);
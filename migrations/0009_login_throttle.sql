@@ -0,0 +1,20 @@
+-- Brute-force protection for logins. Failures and blocks live in Redis when
+-- it is available; login_throttle is the fallback store. Keys are
+-- 'account:<user id>' and 'ip:<address>'.
+
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key               TEXT        PRIMARY KEY,
+    failures          INT         NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    blocked_until     TIMESTAMPTZ,
+    locked            BOOLEAN     NOT NULL DEFAULT false
+);
+
+-- Single-use links that lift an account lockout early
+CREATE TABLE IF NOT EXISTS account_unlock_tokens (
+    token_hash TEXT        PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
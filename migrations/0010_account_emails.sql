@@ -0,0 +1,30 @@
+-- Email verification gates trading. Accounts created before verification
+-- existed are treated as verified so they keep trading.
+
+-- The backfill runs only when the column is created: re-running it would
+-- mark users who registered since as verified.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND table_name = 'user_auth'
+          AND column_name = 'email_verified_at'
+    ) THEN
+        ALTER TABLE user_auth ADD COLUMN email_verified_at TIMESTAMPTZ;
+        UPDATE user_auth SET email_verified_at = COALESCE(last_password_change, NOW());
+    END IF;
+END
+$$;
+
+-- Single-use tokens mailed to users: password resets and email verification
+CREATE TABLE IF NOT EXISTS account_tokens (
+    token_hash TEXT        PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose    TEXT        NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS account_tokens_user_idx ON account_tokens(user_id, purpose, created_at DESC);
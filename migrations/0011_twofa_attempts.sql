@@ -0,0 +1,5 @@
+-- Wrong 2FA codes are counted per user, across logins and sensitive actions
+-- (X-2FA-CODE), and lock code checks for a while after too many.
+
+ALTER TABLE user_auth ADD COLUMN IF NOT EXISTS twofa_failed_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE user_auth ADD COLUMN IF NOT EXISTS twofa_locked_until    TIMESTAMPTZ;